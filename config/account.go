@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultAccountDeletionGracePeriod is how long a deleted account can be restored
+const defaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// AccountDeletionGracePeriod is how long a deleted account is kept before its data is erased
+var AccountDeletionGracePeriod = defaultAccountDeletionGracePeriod
+
+// InitAccountDeletion reads ACCOUNT_DELETION_GRACE_PERIOD (e.g. "720h", "0" erases accounts right away)
+func InitAccountDeletion() error {
+	AccountDeletionGracePeriod = defaultAccountDeletionGracePeriod
+	value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if value == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD '%s'", value)
+	}
+	AccountDeletionGracePeriod = d
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// AdminEmails are made administrators once their email address is verified, and at startup if it is
+var AdminEmails []string
+
+// InitAdmin reads ADMIN_EMAIL, a comma-separated list of email addresses
+func InitAdmin() {
+	AdminEmails = nil
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAIL"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			AdminEmails = append(AdminEmails, email)
+		}
+	}
+}
+
+// IsAdminEmail reports whether ADMIN_EMAIL lists the email address
+func IsAdminEmail(email string) bool {
+	for _, admin := range AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LDAPConfig describes the directory users can sign in against with their directory password
+type LDAPConfig struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool   // Don't verify the server certificate (testing only)
+	BindDN             string // Service account used to search for users, anonymous if empty
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // {username} is replaced with the escaped login name
+
+	IDAttribute    string // Stable unique ID of an entry (e.g. entryUUID, objectGUID), the DN if empty
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string // Attribute listing the user's group DNs, e.g. memberOf
+	GroupBaseDN    string // Where to search groups with GroupFilter, the base DN if empty
+	GroupFilter    string // Optional group search, {dn} and {username} are replaced, e.g. (member={dn})
+
+	// Members of these groups (DN or CN) become administrators on every login, everyone else
+	// loses the role. Without admin groups the role is left alone
+	AdminGroups []string
+}
+
+var (
+	LDAPEnabled bool
+	LDAP        LDAPConfig
+)
+
+// InitLDAP reads the LDAP_* environment variables
+func InitLDAP() error {
+	LDAPEnabled = strings.TrimSpace(os.Getenv("LDAP_ENABLED")) == "true"
+	if !LDAPEnabled {
+		return nil
+	}
+
+	LDAP = LDAPConfig{
+		URL:                os.Getenv("LDAP_URL"),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         envOrDefault("LDAP_USER_FILTER", "(&(objectClass=person)(|(uid={username})(mail={username})))"),
+		IDAttribute:        os.Getenv("LDAP_ID_ATTRIBUTE"),
+		EmailAttribute:     envOrDefault("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		NameAttribute:      envOrDefault("LDAP_NAME_ATTRIBUTE", "cn"),
+		GroupAttribute:     envOrDefault("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		GroupBaseDN:        os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:        os.Getenv("LDAP_GROUP_FILTER"),
+	}
+	for _, group := range strings.Split(os.Getenv("LDAP_ADMIN_GROUPS"), ";") {
+		if group = strings.TrimSpace(group); group != "" {
+			LDAP.AdminGroups = append(LDAP.AdminGroups, group)
+		}
+	}
+
+	if LDAP.URL == "" || LDAP.BaseDN == "" {
+		return fmt.Errorf("LDAP is enabled but LDAP_URL or LDAP_BASE_DN is missing")
+	}
+	if !strings.Contains(LDAP.UserFilter, "{username}") {
+		return fmt.Errorf("LDAP_USER_FILTER must contain {username}")
+	}
+	if LDAP.GroupBaseDN == "" {
+		LDAP.GroupBaseDN = LDAP.BaseDN
+	}
+
+	return nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
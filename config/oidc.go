@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -13,71 +16,219 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// DefaultOIDCProviderName names the provider configured with the single-provider OIDC_* variables
+const DefaultOIDCProviderName = "default"
+
+// OIDCProviderConfig describes one OpenID Connect provider users can sign in with
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`  // Used in the login and callback URLs
+	Label        string   `json:"label"` // Shown on the login button
+	IssuerURL    string   `json:"issuer_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"` // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string   `json:"redirect_url"`  // Defaults to /sync/api/auth/oidc/<name>/callback on the request host
+	Scopes       []string `json:"scopes"`
+
+	// Where the provider sends the browser after logging out, defaults to /sync/login on the request host
+	PostLogoutRedirectURL string `json:"post_logout_redirect_url"`
+
+	// Group mapping, re-evaluated on every login: members of an admin group become administrators,
+	// everyone else loses the role. Without admin groups the role is left alone
+	GroupsClaim string   `json:"groups_claim"` // Claim listing the user's groups, dots for nested claims (e.g. "realm_access.roles")
+	AdminGroups []string `json:"admin_groups"`
+
+	// Members of a group join the workspace it maps to (by name), and leave it when they are no
+	// longer in the group, if this provider added them
+	WorkspaceGroups map[string]string `json:"workspace_groups"`
+}
+
+// OIDCProvider is a configured provider together with its discovered endpoints
+type OIDCProvider struct {
+	OIDCProviderConfig
+
+	Provider      *oidc.Provider
+	OAuth2Config  *oauth2.Config
+	Verifier      *oidc.IDTokenVerifier
+	EndSessionURL string // RP-initiated logout endpoint, empty if the provider has none
+}
+
 var (
-	OIDCEnabled      bool
-	OIDCIssuerURL    string
-	OIDCClientID     string
-	OIDCClientSecret string
-	OIDCRedirectURL  string
-	OIDCScopes       []string
-
-	OIDCProvider *oidc.Provider
-	OAuth2Config *oauth2.Config
-	OIDCVerifier *oidc.IDTokenVerifier
+	OIDCEnabled   bool
+	OIDCProviders []*OIDCProvider // In the order they are shown on the login page
+
+	providerNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
 )
 
+// InitOIDC loads the single provider of OIDC_ENABLED/OIDC_ISSUER_URL/..., the providers in
+// OIDC_CONFIG_FILE and those listed in OIDC_PROVIDERS, then discovers their endpoints
+// Providers that can't be reached are skipped and reported in the returned error
 func InitOIDC() error {
-	envValue := os.Getenv("OIDC_ENABLED")
-	OIDCEnabled = strings.TrimSpace(envValue) == "true"
+	OIDCProviders = nil
+	OIDCEnabled = false
+
+	configs, err := loadOIDCProviderConfigs()
+	if err != nil {
+		return err
+	}
 
-	if !OIDCEnabled {
-		return nil
+	var errs []error
+	for _, cfg := range configs {
+		provider, err := newOIDCProvider(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OIDC provider %q: %w", cfg.Name, err))
+			continue
+		}
+		OIDCProviders = append(OIDCProviders, provider)
 	}
 
-	OIDCIssuerURL = os.Getenv("OIDC_ISSUER_URL")
-	OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
-	OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
-	OIDCRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	OIDCEnabled = len(OIDCProviders) > 0
+	return errors.Join(errs...)
+}
+
+// GetOIDCProvider returns the provider with the given name, or nil
+func GetOIDCProvider(name string) *OIDCProvider {
+	for _, provider := range OIDCProviders {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+func loadOIDCProviderConfigs() ([]OIDCProviderConfig, error) {
+	var configs []OIDCProviderConfig
+
+	if strings.TrimSpace(os.Getenv("OIDC_ENABLED")) == "true" {
+		configs = append(configs, oidcProviderConfigFromEnv(DefaultOIDCProviderName, "OIDC_"))
+	}
 
-	scopesStr := os.Getenv("OIDC_SCOPES")
-	if scopesStr == "" {
-		OIDCScopes = []string{oidc.ScopeOpenID, "profile", "email"}
-	} else {
-		OIDCScopes = strings.Split(scopesStr, ",")
+	if path := os.Getenv("OIDC_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OIDC_CONFIG_FILE: %w", err)
+		}
+		var file struct {
+			Providers []OIDCProviderConfig `json:"providers"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse OIDC_CONFIG_FILE: %w", err)
+		}
+		configs = append(configs, file.Providers...)
 	}
 
-	// Validate required config
-	if OIDCIssuerURL == "" || OIDCClientID == "" || OIDCClientSecret == "" {
-		return fmt.Errorf("OIDC is enabled but missing required configuration")
+	// OIDC_PROVIDERS=staff,customers reads OIDC_STAFF_ISSUER_URL, OIDC_STAFF_CLIENT_ID, ...
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		configs = append(configs, oidcProviderConfigFromEnv(name, prefix))
 	}
 
-	// Initialize OIDC provider
+	seen := make(map[string]bool)
+	for i := range configs {
+		cfg := &configs[i]
+		if !providerNamePattern.MatchString(cfg.Name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q: use lowercase letters, digits, '-' and '_'", cfg.Name)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("OIDC provider %q is configured more than once", cfg.Name)
+		}
+		seen[cfg.Name] = true
+
+		if cfg.IssuerURL == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q is missing required configuration", cfg.Name)
+		}
+		if cfg.Label == "" {
+			cfg.Label = "SSO"
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+		}
+		if cfg.GroupsClaim == "" {
+			cfg.GroupsClaim = "groups"
+		}
+	}
+
+	return configs, nil
+}
+
+func oidcProviderConfigFromEnv(name, prefix string) OIDCProviderConfig {
+	cfg := OIDCProviderConfig{
+		Name:         name,
+		Label:        os.Getenv(prefix + "LABEL"),
+		IssuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+
+		PostLogoutRedirectURL: os.Getenv(prefix + "POST_LOGOUT_REDIRECT_URL"),
+	}
+	if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+		cfg.Scopes = strings.Split(scopes, ",")
+	}
+	cfg.GroupsClaim = os.Getenv(prefix + "GROUPS_CLAIM")
+	for _, group := range strings.Split(os.Getenv(prefix+"ADMIN_GROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			cfg.AdminGroups = append(cfg.AdminGroups, group)
+		}
+	}
+	// team-payments=Payments,team-data=Data
+	for _, mapping := range strings.Split(os.Getenv(prefix+"WORKSPACE_GROUPS"), ",") {
+		group, workspace, ok := strings.Cut(mapping, "=")
+		group, workspace = strings.TrimSpace(group), strings.TrimSpace(workspace)
+		if !ok || group == "" || workspace == "" {
+			continue
+		}
+		if cfg.WorkspaceGroups == nil {
+			cfg.WorkspaceGroups = make(map[string]string)
+		}
+		cfg.WorkspaceGroups[group] = workspace
+	}
+	return cfg
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig) (*OIDCProvider, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	provider, err := oidc.NewProvider(ctx, OIDCIssuerURL)
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
 	if err != nil {
-		return fmt.Errorf("failed to initialize OIDC provider: %w", err)
+		return nil, fmt.Errorf("failed to initialize OIDC provider: %w", err)
 	}
 
-	OIDCProvider = provider
-
-	// Configure OAuth2
-	OAuth2Config = &oauth2.Config{
-		ClientID:     OIDCClientID,
-		ClientSecret: OIDCClientSecret,
-		RedirectURL:  OIDCRedirectURL,
-		Endpoint:     provider.Endpoint(),
-		Scopes:       OIDCScopes,
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to read OIDC provider metadata: %w", err)
 	}
 
-	// Configure ID token verifier
-	oidcConfig := &oidc.Config{
-		ClientID: OIDCClientID,
+	// Public clients identify themselves with the client_id parameter instead of HTTP basic auth
+	endpoint := provider.Endpoint()
+	if cfg.ClientSecret == "" {
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
 	}
-	OIDCVerifier = provider.Verifier(oidcConfig)
 
-	return nil
+	return &OIDCProvider{
+		OIDCProviderConfig: cfg,
+		Provider:           provider,
+		OAuth2Config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     endpoint,
+			Scopes:       cfg.Scopes,
+		},
+		Verifier:      provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		EndSessionURL: metadata.EndSessionEndpoint,
+	}, nil
+}
+
+// MapsGroups reports whether logins through the provider update the user's role or workspaces from their groups
+func (p *OIDCProvider) MapsGroups() bool {
+	return len(p.AdminGroups) > 0 || len(p.WorkspaceGroups) > 0
 }
 
 // GenerateState generates a random state parameter for OAuth2 flow
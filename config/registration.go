@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Registration modes
+const (
+	RegistrationOpen        = "open"         // Anyone can sign up
+	RegistrationVerifyEmail = "verify_email" // Anyone can sign up, but must verify their email before pushing diagrams
+	RegistrationInviteOnly  = "invite_only"  // Signing up requires an invitation
+	RegistrationDisabled    = "disabled"     // No local signups, accounts are created through OIDC only
+)
+
+var (
+	RegistrationMode    string
+	AllowedEmailDomains []string
+)
+
+// InitRegistration reads REGISTRATION_MODE and ALLOWED_EMAIL_DOMAINS
+func InitRegistration() error {
+	RegistrationMode = strings.TrimSpace(os.Getenv("REGISTRATION_MODE"))
+	switch RegistrationMode {
+	case "":
+		RegistrationMode = RegistrationOpen
+	case RegistrationOpen, RegistrationVerifyEmail, RegistrationInviteOnly, RegistrationDisabled:
+	default:
+		return fmt.Errorf("unsupported REGISTRATION_MODE '%s' (use %s, %s, %s or %s)", RegistrationMode,
+			RegistrationOpen, RegistrationVerifyEmail, RegistrationInviteOnly, RegistrationDisabled)
+	}
+
+	AllowedEmailDomains = nil
+	for _, domain := range strings.Split(os.Getenv("ALLOWED_EMAIL_DOMAINS"), ",") {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if domain != "" {
+			AllowedEmailDomains = append(AllowedEmailDomains, domain)
+		}
+	}
+
+	return nil
+}
+
+// EmailDomainAllowed reports whether new accounts may be created for an email address
+// All domains are allowed when ALLOWED_EMAIL_DOMAINS is empty
+func EmailDomainAllowed(email string) bool {
+	if len(AllowedEmailDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range AllowedEmailDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailVerificationRequired reports whether unverified accounts are restricted
+func EmailVerificationRequired() bool {
+	return RegistrationMode == RegistrationVerifyEmail
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// minSCIMTokenLength keeps the provisioning token from being guessable
+const minSCIMTokenLength = 32
+
+var (
+	SCIMEnabled bool
+	SCIMToken   string // Bearer token the identity provider presents
+
+	// Members of these SCIM groups (by display name) are administrators, everyone else
+	// loses the role when their memberships change. Without admin groups the role is left alone
+	SCIMAdminGroups []string
+)
+
+// InitSCIM reads SCIM_TOKEN and SCIM_ADMIN_GROUPS; provisioning is enabled when a token is set
+func InitSCIM() error {
+	SCIMToken = strings.TrimSpace(os.Getenv("SCIM_TOKEN"))
+	SCIMEnabled = SCIMToken != ""
+	if SCIMEnabled && len(SCIMToken) < minSCIMTokenLength {
+		return fmt.Errorf("SCIM_TOKEN must be at least %d characters long", minSCIMTokenLength)
+	}
+
+	SCIMAdminGroups = nil
+	for _, group := range strings.Split(os.Getenv("SCIM_ADMIN_GROUPS"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			SCIMAdminGroups = append(SCIMAdminGroups, group)
+		}
+	}
+
+	return nil
+}
+
+// IsSCIMAdminGroup reports whether members of the SCIM group with this display name are administrators
+func IsSCIMAdminGroup(displayName string) bool {
+	for _, group := range SCIMAdminGroups {
+		if strings.EqualFold(group, displayName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultTrashRetentionDays is how long deleted diagrams stay in the trash
+const defaultTrashRetentionDays = 30
+
+// TrashRetention is how long a diagram stays in the trash before it is erased
+// 0 keeps trashed diagrams until the trash is emptied
+var TrashRetention = defaultTrashRetentionDays * 24 * time.Hour
+
+// InitTrash reads TRASH_RETENTION_DAYS (e.g. "7", "0" never erases trashed diagrams on its own)
+func InitTrash() error {
+	TrashRetention = defaultTrashRetentionDays * 24 * time.Hour
+	value := os.Getenv("TRASH_RETENTION_DAYS")
+	if value == "" {
+		return nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return fmt.Errorf("invalid TRASH_RETENTION_DAYS '%s'", value)
+	}
+	TrashRetention = time.Duration(days) * 24 * time.Hour
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var WebAuthn *webauthn.WebAuthn
+
+// InitWebAuthn configures passkey support
+// WEBAUTHN_ORIGINS lists the origins the app is served from (defaults to APP_BASE_URL, then http://localhost:PORT)
+// and WEBAUTHN_RP_ID the domain passkeys are bound to (defaults to the host of the first origin)
+func InitWebAuthn() error {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		if base := os.Getenv("APP_BASE_URL"); base != "" {
+			origins = []string{strings.TrimRight(base, "/")}
+		} else {
+			port := os.Getenv("PORT")
+			if port == "" {
+				port = "8080"
+			}
+			origins = []string{"http://localhost:" + port}
+		}
+	}
+
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		parsed, err := url.Parse(origins[0])
+		if err != nil || parsed.Hostname() == "" {
+			return fmt.Errorf("invalid WebAuthn origin '%s'", origins[0])
+		}
+		rpID = parsed.Hostname()
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "ChartDB Sync",
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return err
+	}
+
+	WebAuthn = w
+	return nil
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"log"
+
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// PromoteAdmins makes the existing accounts of the given emails administrators
+// Only accounts with a verified email are promoted, so nobody can claim the role by signing up first
+func PromoteAdmins(emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	result := DB.Model(&models.User{}).
+		Where("LOWER(email) IN ? AND is_admin = ? AND email_verified_at IS NOT NULL", emails, false).
+		Updates(map[string]interface{}{"is_admin": true, "admin_source": models.AdminSourceManual})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Made %d account(s) from ADMIN_EMAIL administrators", result.RowsAffected)
+	}
+	return nil
+}
+
+// PromoteAdminEmail makes the user an administrator if ADMIN_EMAIL lists their email address and it is verified
+// Called wherever an email address becomes verified
+func PromoteAdminEmail(tx *gorm.DB, user *models.User) error {
+	if user.IsAdmin || user.EmailVerifiedAt == nil || !config.IsAdminEmail(user.Email) {
+		return nil
+	}
+
+	if err := tx.Model(user).Updates(map[string]interface{}{"is_admin": true, "admin_source": models.AdminSourceManual}).Error; err != nil {
+		return err
+	}
+	log.Printf("Made %s from ADMIN_EMAIL an administrator", user.Email)
+	return nil
+}
@@ -26,6 +26,18 @@ func InitDB() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	// Accounts that existed before email verification was introduced count as verified
+	backfillEmailVerified := DB.Migrator().HasTable(&models.User{}) && !DB.Migrator().HasColumn(&models.User{}, "EmailVerifiedAt")
+
+	// Diagrams that existed before table and relationship counts were stored need them counted
+	backfillDiagramCounts := DB.Migrator().HasTable(&models.Diagram{}) && !DB.Migrator().HasColumn(&models.Diagram{}, "TableCount")
+
+	// Versions that existed before authors were recorded are attributed to the diagram owner
+	backfillVersionAuthors := DB.Migrator().HasTable(&models.DiagramVersion{}) && !DB.Migrator().HasColumn(&models.DiagramVersion{}, "AuthorID")
+
+	// Administrators from before the source of the role was recorded count as granted by an administrator
+	backfillAdminSource := DB.Migrator().HasTable(&models.User{}) && !DB.Migrator().HasColumn(&models.User{}, "AdminSource")
+
 	// Auto-migrate only the essential models
 	// Diagram data is now stored as JSON in DiagramVersion.Data
 	// Old entity tables (DBTable, DBField, etc.) are no longer used
@@ -33,10 +45,118 @@ func InitDB() {
 		&models.User{},
 		&models.Diagram{},
 		&models.DiagramVersion{},
+		&models.ShareLink{},
+		&models.DiagramTransfer{},
+		&models.PersonalAccessToken{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.Invitation{},
+		&models.RecoveryCode{},
+		&models.LoginChallenge{},
+		&models.Setting{},
+		&models.Passkey{},
+		&models.WebAuthnSession{},
+		&models.UserIdentity{},
+		&models.IdentityLinkRequest{},
+		&models.SCIMGroup{},
+		&models.SCIMGroupMember{},
+		&models.AccountDeletion{},
+		&models.AuditEvent{},
+		&models.DiagramTag{},
+		&models.Workspace{},
+		&models.WorkspaceMember{},
 	)
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	if backfillEmailVerified {
+		if err := DB.Model(&models.User{}).Where("email_verified_at IS NULL").Update("email_verified_at", gorm.Expr("created_at")).Error; err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	if backfillDiagramCounts {
+		if err := countDiagramContents(); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	if backfillAdminSource {
+		if err := DB.Model(&models.User{}).Where("is_admin = ?", true).Update("admin_source", models.AdminSourceManual).Error; err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	if backfillVersionAuthors {
+		if err := DB.Exec(`UPDATE diagram_versions SET author_id = (SELECT user_id FROM diagrams WHERE diagrams.id = diagram_versions.diagram_id) WHERE author_id IS NULL`).Error; err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	// OIDC accounts used to be linked through the oidc_subject/oidc_issuer columns of users
+	if DB.Migrator().HasColumn(&models.User{}, "o_id_c_subject") {
+		if err := migrateOIDCSubjects(); err != nil {
+			log.Fatal("Failed to migrate database:", err)
+		}
+	}
+
+	if err := initSearchIndex(); err != nil {
+		log.Fatal("Failed to create search index:", err)
+	}
+
 	log.Println("Database initialized successfully (JSON-only mode)")
 }
+
+// countDiagramContents stores the table and relationship counts of the latest version of every diagram
+func countDiagramContents() error {
+	var diagrams []models.Diagram
+	if err := DB.Unscoped().Find(&diagrams).Error; err != nil {
+		return err
+	}
+
+	for _, diagram := range diagrams {
+		var version models.DiagramVersion
+		if err := DB.Where("diagram_id = ?", diagram.ID).Order("version desc").First(&version).Error; err != nil {
+			continue
+		}
+		content, err := models.ParseDiagramContent(version.Data)
+		if err != nil {
+			continue
+		}
+		if err := DB.Unscoped().Model(&diagram).UpdateColumns(map[string]interface{}{
+			"table_count":        len(content.Tables),
+			"relationship_count": len(content.Relationships),
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOIDCSubjects moves the OIDC subjects stored on users into user identities and drops the old columns
+func migrateOIDCSubjects() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO user_identities (user_id, provider, issuer, subject, email, created_at)
+			SELECT id, '', COALESCE(o_id_c_issuer, ''), o_id_c_subject, email, created_at FROM users
+			WHERE o_id_c_subject <> '' AND deleted_at IS NULL`).Error; err != nil {
+			return err
+		}
+		// The subject index was unique across all users, so it also held the empty subjects of local accounts
+		if tx.Migrator().HasIndex(&models.User{}, "idx_users_o_id_c_subject") {
+			if err := tx.Migrator().DropIndex(&models.User{}, "idx_users_o_id_c_subject"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().DropColumn(&models.User{}, "o_id_c_subject"); err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropColumn(&models.User{}, "o_id_c_issuer"); err != nil {
+			return err
+		}
+		// SQLite drops columns by rebuilding the table, which loses its indexes
+		return tx.AutoMigrate(&models.User{})
+	})
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// Kinds of entries in the search index
+const (
+	SearchEntityDiagram = "diagram"
+	SearchEntityTable   = "table"
+	SearchEntityView    = "view"
+	SearchEntityField   = "field"
+	SearchEntityComment = "comment"
+	SearchEntityNote    = "note"
+)
+
+// searchIndexBatchSize is how many entries are inserted per statement, within SQLite's variable limit
+const searchIndexBatchSize = 200
+
+// searchEntry is a row of the search index
+type searchEntry struct {
+	entityType string
+	path       string
+	content    string
+}
+
+// initSearchIndex creates the full-text search index of the latest diagram versions
+// Entries live in search_entries, keyed by diagram so they can be replaced cheaply; search_index is an
+// FTS5 index over their content, kept in sync by triggers. It is filled from the existing diagrams when
+// first created
+func initSearchIndex() error {
+	if DB.Migrator().HasTable("search_entries") {
+		return nil
+	}
+
+	statements := []string{
+		`CREATE TABLE search_entries (
+			id INTEGER PRIMARY KEY,
+			diagram_id INTEGER NOT NULL,
+			entity_type TEXT NOT NULL,
+			path TEXT NOT NULL,
+			content TEXT NOT NULL
+		)`,
+		`CREATE INDEX idx_search_entries_diagram_id ON search_entries (diagram_id)`,
+		`CREATE VIRTUAL TABLE search_index USING fts5(content, content='search_entries', content_rowid='id')`,
+		`CREATE TRIGGER search_entries_insert AFTER INSERT ON search_entries BEGIN
+			INSERT INTO search_index (rowid, content) VALUES (new.id, new.content);
+		END`,
+		`CREATE TRIGGER search_entries_delete AFTER DELETE ON search_entries BEGIN
+			INSERT INTO search_index (search_index, rowid, content) VALUES ('delete', old.id, old.content);
+		END`,
+	}
+
+	var diagrams []models.Diagram
+	if err := DB.Unscoped().Find(&diagrams).Error; err != nil {
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		for i := range diagrams {
+			var version models.DiagramVersion
+			if err := tx.Where("diagram_id = ?", diagrams[i].ID).Order("version desc").First(&version).Error; err != nil {
+				continue
+			}
+			if err := IndexDiagram(tx, &diagrams[i], version.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IndexDiagram replaces the search index entries of a diagram with the contents of its latest version:
+// the diagram name, table and field names, their comments and the notes
+func IndexDiagram(tx *gorm.DB, diagram *models.Diagram, data string) error {
+	if err := RemoveFromSearchIndex(tx, []uint{diagram.ID}); err != nil {
+		return err
+	}
+
+	var entries []searchEntry
+	add := func(entityType, path, content string) {
+		if strings.TrimSpace(content) != "" {
+			entries = append(entries, searchEntry{entityType, path, content})
+		}
+	}
+
+	add(SearchEntityDiagram, diagram.Name, diagram.Name)
+	if content, err := models.ParseDiagramContent(data); err == nil {
+		for _, table := range content.Tables {
+			tablePath := table.Name
+			if table.Schema != "" {
+				tablePath = table.Schema + "." + table.Name
+			}
+			entityType := SearchEntityTable
+			if table.IsView {
+				entityType = SearchEntityView
+			}
+			add(entityType, tablePath, table.Name)
+			add(SearchEntityComment, tablePath, table.Comments)
+
+			for _, field := range table.Fields {
+				fieldPath := tablePath + "." + field.Name
+				add(SearchEntityField, fieldPath, field.Name)
+				add(SearchEntityComment, fieldPath, field.Comments)
+			}
+		}
+		for i, note := range content.Notes {
+			add(SearchEntityNote, fmt.Sprintf("Note %d", i+1), note.Content)
+		}
+	}
+
+	for start := 0; start < len(entries); start += searchIndexBatchSize {
+		end := start + searchIndexBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		values := make([]interface{}, 0, 4*(end-start))
+		for _, entry := range entries[start:end] {
+			placeholders = append(placeholders, "(?, ?, ?, ?)")
+			values = append(values, diagram.ID, entry.entityType, entry.path, entry.content)
+		}
+		if err := tx.Exec("INSERT INTO search_entries (diagram_id, entity_type, path, content) VALUES "+strings.Join(placeholders, ", "), values...).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveFromSearchIndex removes diagrams from the search index
+// ids is a list of diagram IDs or a subquery that selects them
+func RemoveFromSearchIndex(tx *gorm.DB, ids interface{}) error {
+	return tx.Exec("DELETE FROM search_entries WHERE diagram_id IN (?)", ids).Error
+}
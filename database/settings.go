@@ -0,0 +1,92 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm/clause"
+)
+
+// settingsCache holds the settings read so far, so middleware can check them on every request
+// Settings are only written through this package, which keeps the cache up to date
+var settingsCache = struct {
+	mu     sync.RWMutex
+	values map[string]*string
+}{values: make(map[string]*string)}
+
+// getSetting returns the stored value of a setting, nil if it was never set
+func getSetting(key string) (*string, error) {
+	settingsCache.mu.RLock()
+	value, ok := settingsCache.values[key]
+	settingsCache.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	// Read under the write lock, so a concurrent write can't be overwritten by a stale value
+	settingsCache.mu.Lock()
+	defer settingsCache.mu.Unlock()
+	if value, ok := settingsCache.values[key]; ok {
+		return value, nil
+	}
+
+	var settings []models.Setting
+	if err := DB.Where("key = ?", key).Limit(1).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	if len(settings) > 0 {
+		value = &settings[0].Value
+	}
+	settingsCache.values[key] = value
+	return value, nil
+}
+
+// setSetting stores a setting and updates its cached value
+func setSetting(key, value string) error {
+	settingsCache.mu.Lock()
+	defer settingsCache.mu.Unlock()
+
+	delete(settingsCache.values, key)
+	if err := DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Setting{
+		Key:   key,
+		Value: value,
+	}).Error; err != nil {
+		return err
+	}
+	settingsCache.values[key] = &value
+	return nil
+}
+
+// GetBoolSetting returns a boolean server setting, false if it was never set
+func GetBoolSetting(key string) bool {
+	value, err := getSetting(key)
+	if err != nil || value == nil {
+		return false
+	}
+	enabled, _ := strconv.ParseBool(*value)
+	return enabled
+}
+
+// GetIntSetting returns an integer server setting, or fallback if it was never set
+func GetIntSetting(key string, fallback int) int {
+	value, err := getSetting(key)
+	if err != nil || value == nil {
+		return fallback
+	}
+	number, err := strconv.Atoi(*value)
+	if err != nil {
+		return fallback
+	}
+	return number
+}
+
+// SetIntSetting stores an integer server setting
+func SetIntSetting(key string, value int) error {
+	return setSetting(key, strconv.Itoa(value))
+}
+
+// SetBoolSetting stores a boolean server setting
+func SetBoolSetting(key string, value bool) error {
+	return setSetting(key, strconv.FormatBool(value))
+}
@@ -1,5 +1,17 @@
 const API_BASE = '/sync/api'
 
+// WebAuthn exchanges binary fields as base64url strings in JSON
+function base64urlToBuffer(value) {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+  const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, '='))
+  return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer
+}
+
+function bufferToBase64url(buffer) {
+  const binary = String.fromCharCode(...new Uint8Array(buffer))
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
 class ApiService {
   constructor() {
     // Token is now stored in httpOnly cookie, not localStorage
@@ -14,7 +26,7 @@ class ApiService {
     localStorage.removeItem('chartdb_sync_user')
   }
 
-  async request(endpoint, options = {}) {
+  async request(endpoint, options = {}, retry = true) {
     const headers = {
       'Content-Type': 'application/json',
       ...options.headers
@@ -29,7 +41,14 @@ class ApiService {
       credentials: 'include' // Important: include cookies in requests
     })
 
-    if (response.status === 401) {
+    // Access tokens are short-lived, renew silently and try once more
+    // (a 401 from login/signup means wrong credentials, not an expired session)
+    const canRefresh = !['/auth/login', '/auth/signup', '/auth/2fa/verify', '/auth/passkeys/login/finish', '/auth/identities/link'].includes(endpoint)
+    if (response.status === 401 && retry && canRefresh && await this.refreshSession()) {
+      return this.request(endpoint, options, false)
+    }
+
+    if (response.status === 401 && canRefresh) {
       this.clearAuth()
       window.location.href = '/sync/login'
       throw new Error('Unauthorized')
@@ -37,6 +56,12 @@ class ApiService {
 
     const data = await response.json()
 
+    // An administrator requires 2FA and this account hasn't set it up yet
+    if (response.status === 403 && data.code === 'two_factor_setup_required' &&
+        window.location.pathname !== '/sync/two-factor') {
+      window.location.href = '/sync/two-factor'
+    }
+
     if (!response.ok) {
       throw new Error(data.error || 'Request failed')
     }
@@ -44,43 +69,352 @@ class ApiService {
     return data
   }
 
+  // Share a single refresh between concurrent requests
+  async refreshSession() {
+    if (!this.refreshPromise) {
+      this.refreshPromise = fetch(`${API_BASE}/auth/refresh`, {
+        method: 'POST',
+        credentials: 'include'
+      })
+        // 409: another tab refreshed a moment ago and the cookies are already renewed
+        .then(response => response.ok || response.status === 409)
+        .catch(() => false)
+        .finally(() => {
+          this.refreshPromise = null
+        })
+    }
+    return this.refreshPromise
+  }
+
   // Auth endpoints
-  async signup(email, password, name) {
+  async signup(email, password, name, inviteToken = '') {
     const data = await this.request('/auth/signup', {
       method: 'POST',
-      body: JSON.stringify({ email, password, name })
+      body: JSON.stringify({ email, password, name, invite_token: inviteToken })
     })
     this.setUser(data.user)
     return data
   }
 
+  // Returns { two_factor_required, challenge_token } instead of a session when 2FA is enabled
   async login(email, password) {
     const data = await this.request('/auth/login', {
       method: 'POST',
       body: JSON.stringify({ email, password })
     })
+    if (!data.two_factor_required) {
+      this.setUser(data.user)
+    }
+    return data
+  }
+
+  // Passwordless login with a passkey stored on this device or a security key
+  async loginWithPasskey() {
+    const options = await this.request('/auth/passkeys/login/begin', { method: 'POST' })
+    const publicKey = options.publicKey
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge)
+    if (publicKey.allowCredentials) {
+      publicKey.allowCredentials = publicKey.allowCredentials.map(c => ({ ...c, id: base64urlToBuffer(c.id) }))
+    }
+
+    const credential = await navigator.credentials.get({ publicKey })
+    const data = await this.request('/auth/passkeys/login/finish', {
+      method: 'POST',
+      body: JSON.stringify({
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+          clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+          authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+          signature: bufferToBase64url(credential.response.signature),
+          userHandle: credential.response.userHandle ? bufferToBase64url(credential.response.userHandle) : null
+        }
+      })
+    })
+    this.setUser(data.user)
+    return data
+  }
+
+  async verifyTwoFactorLogin(challengeToken, code) {
+    const data = await this.request('/auth/2fa/verify', {
+      method: 'POST',
+      body: JSON.stringify({ challenge_token: challengeToken, code })
+    })
+    this.setUser(data.user)
+    return data
+  }
+
+  // Confirms linking an SSO identity to the existing account with the same email
+  async getIdentityLink(token) {
+    return this.request(`/auth/identities/link/${encodeURIComponent(token)}`)
+  }
+
+  async linkIdentity(token, password, code) {
+    const data = await this.request('/auth/identities/link', {
+      method: 'POST',
+      body: JSON.stringify({ token, password, code })
+    })
     this.setUser(data.user)
     return data
   }
 
+  // After an SSO login, the response names the provider's logout page to end its session too
   async logout() {
+    let redirect = '/sync/login'
     try {
-      await this.request('/auth/logout', {
+      const data = await this.request('/auth/logout', {
         method: 'POST'
       })
+      if (data.logout_url) {
+        redirect = data.logout_url
+      }
     } finally {
       this.clearAuth()
-      window.location.href = '/sync/login'
+      window.location.href = redirect
     }
   }
 
+  // Password reset
+  async requestPasswordReset(email) {
+    return this.request('/auth/password/reset-request', {
+      method: 'POST',
+      body: JSON.stringify({ email })
+    })
+  }
+
+  async resetPassword(token, newPassword) {
+    return this.request('/auth/password/reset', {
+      method: 'POST',
+      body: JSON.stringify({ token, new_password: newPassword })
+    })
+  }
+
+  // Registration and email verification
+  async getRegistrationSettings() {
+    return this.request('/auth/registration')
+  }
+
+  async verifyEmail(token) {
+    return this.request('/auth/verify-email', {
+      method: 'POST',
+      body: JSON.stringify({ token })
+    })
+  }
+
+  async resendVerificationEmail() {
+    return this.request('/auth/resend-verification', {
+      method: 'POST'
+    })
+  }
+
+  // Two-factor authentication
+  async getTwoFactorStatus() {
+    return this.request('/auth/2fa')
+  }
+
+  async setupTwoFactor() {
+    return this.request('/auth/2fa/setup', {
+      method: 'POST'
+    })
+  }
+
+  async enableTwoFactor(code) {
+    return this.request('/auth/2fa/enable', {
+      method: 'POST',
+      body: JSON.stringify({ code })
+    })
+  }
+
+  async disableTwoFactor(password, code) {
+    return this.request('/auth/2fa/disable', {
+      method: 'POST',
+      body: JSON.stringify({ password, code })
+    })
+  }
+
+  async regenerateRecoveryCodes(code) {
+    return this.request('/auth/2fa/recovery-codes', {
+      method: 'POST',
+      body: JSON.stringify({ code })
+    })
+  }
+
+  // Passkeys
+  async listPasskeys() {
+    return this.request('/auth/passkeys')
+  }
+
+  async registerPasskey(name) {
+    const options = await this.request('/auth/passkeys/register/begin', {
+      method: 'POST',
+      body: JSON.stringify({ name })
+    })
+    const publicKey = options.publicKey
+    publicKey.challenge = base64urlToBuffer(publicKey.challenge)
+    publicKey.user.id = base64urlToBuffer(publicKey.user.id)
+    if (publicKey.excludeCredentials) {
+      publicKey.excludeCredentials = publicKey.excludeCredentials.map(c => ({ ...c, id: base64urlToBuffer(c.id) }))
+    }
+
+    const credential = await navigator.credentials.create({ publicKey })
+    return this.request('/auth/passkeys/register/finish', {
+      method: 'POST',
+      body: JSON.stringify({
+        id: credential.id,
+        rawId: bufferToBase64url(credential.rawId),
+        type: credential.type,
+        response: {
+          clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+          attestationObject: bufferToBase64url(credential.response.attestationObject),
+          transports: credential.response.getTransports ? credential.response.getTransports() : []
+        }
+      })
+    })
+  }
+
+  async deletePasskey(passkeyId) {
+    return this.request(`/auth/passkeys/${passkeyId}`, {
+      method: 'DELETE'
+    })
+  }
+
+  // Linked SSO identities
+  async listIdentities() {
+    return this.request('/auth/identities')
+  }
+
+  async unlinkIdentity(identityId) {
+    return this.request(`/auth/identities/${identityId}`, {
+      method: 'DELETE'
+    })
+  }
+
+  // Admin settings
+  async getSettings() {
+    return this.request('/admin/settings')
+  }
+
+  async updateSettings(settings) {
+    return this.request('/admin/settings', {
+      method: 'PUT',
+      body: JSON.stringify(settings)
+    })
+  }
+
+  // Invitations
+  async getInvitation(token) {
+    return this.request(`/auth/invitations/${encodeURIComponent(token)}`)
+  }
+
+  async listInvitations() {
+    return this.request('/invitations')
+  }
+
+  async createInvitation(email) {
+    return this.request('/invitations', {
+      method: 'POST',
+      body: JSON.stringify({ email })
+    })
+  }
+
+  async revokeInvitation(invitationId) {
+    return this.request(`/invitations/${invitationId}`, {
+      method: 'DELETE'
+    })
+  }
+
   async getCurrentUser() {
     return this.request('/auth/me')
   }
 
+  // Account data export and deletion
+  // The export is a zip file, so it is downloaded without going through request()
+  async exportData() {
+    const response = await fetch(`${API_BASE}/auth/export`, { credentials: 'include' })
+    if (!response.ok) {
+      const data = await response.json().catch(() => ({}))
+      throw new Error(data.error || 'Export failed')
+    }
+
+    const blob = await response.blob()
+    const match = /filename="([^"]+)"/.exec(response.headers.get('Content-Disposition') || '')
+    const url = URL.createObjectURL(blob)
+    const link = document.createElement('a')
+    link.href = url
+    link.download = match ? match[1] : 'chartdb-export.zip'
+    link.click()
+    URL.revokeObjectURL(url)
+  }
+
+  async deleteAccount(confirm, password = '', code = '') {
+    const data = await this.request('/auth/me', {
+      method: 'DELETE',
+      body: JSON.stringify({ confirm, password, code })
+    })
+    this.clearAuth()
+    return data
+  }
+
+  async restoreAccount(token) {
+    return this.request('/auth/restore-account', {
+      method: 'POST',
+      body: JSON.stringify({ token })
+    })
+  }
+
+  // Personal access tokens
+  async listTokens() {
+    return this.request('/auth/tokens')
+  }
+
+  async createToken(name, scopes, expiresAt = null) {
+    return this.request('/auth/tokens', {
+      method: 'POST',
+      body: JSON.stringify({ name, scopes, expires_at: expiresAt })
+    })
+  }
+
+  async revokeToken(tokenId) {
+    return this.request(`/auth/tokens/${tokenId}`, {
+      method: 'DELETE'
+    })
+  }
+
+  // Sessions
+  async listSessions() {
+    return this.request('/auth/sessions')
+  }
+
+  async revokeSession(sessionId) {
+    return this.request(`/auth/sessions/${sessionId}`, {
+      method: 'DELETE'
+    })
+  }
+
+  async revokeOtherSessions() {
+    return this.request('/auth/sessions', {
+      method: 'DELETE'
+    })
+  }
+
   // Diagram endpoints
-  async listDiagrams() {
-    return this.request('/diagrams')
+  // Diagrams matching params, filters such as { name, tag, sort, order, page, per_page }
+  async listDiagrams(params = {}) {
+    const query = new URLSearchParams(params).toString()
+    return this.request(`/diagrams${query ? `?${query}` : ''}`)
+  }
+
+  // All diagrams; the list is only paginated when a page is asked for
+  async listAllDiagrams() {
+    return this.listDiagrams()
+  }
+
+  async setDiagramTags(diagramId, tags) {
+    return this.request(`/diagrams/${diagramId}/tags`, {
+      method: 'PUT',
+      body: JSON.stringify({ tags })
+    })
   }
 
   async getDiagram(diagramId) {
@@ -126,16 +460,68 @@ class ApiService {
     })
   }
 
+  // Full-text search in the latest versions of all diagrams; params such as { type, diagram_id, page }
+  async search(q, params = {}) {
+    const query = new URLSearchParams({ q, ...params }).toString()
+    return this.request(`/search?${query}`)
+  }
+
+  // Trash
+  async listTrash() {
+    return this.request('/trash')
+  }
+
+  async restoreDiagram(diagramId) {
+    return this.request(`/trash/${diagramId}/restore`, {
+      method: 'POST'
+    })
+  }
+
+  async purgeDiagram(diagramId) {
+    return this.request(`/trash/${diagramId}`, {
+      method: 'DELETE'
+    })
+  }
+
+  async emptyTrash() {
+    return this.request('/trash', {
+      method: 'DELETE'
+    })
+  }
+
   async getVersions(diagramId) {
     return this.request(`/diagrams/${diagramId}/versions`)
   }
 
+  // Versions with who created them, from which client and how
+  async getTimeline(diagramId) {
+    return this.request(`/diagrams/${diagramId}/timeline`)
+  }
+
   // Delete a specific version/snapshot
   async deleteVersion(diagramId, version) {
     return this.request(`/diagrams/${diagramId}/versions/${version}`, {
       method: 'DELETE'
     })
   }
+
+  // Share links
+  async listShareLinks(diagramId) {
+    return this.request(`/diagrams/${diagramId}/shares`)
+  }
+
+  async createShareLink(diagramId, options = {}) {
+    return this.request(`/diagrams/${diagramId}/shares`, {
+      method: 'POST',
+      body: JSON.stringify(options)
+    })
+  }
+
+  async revokeShareLink(diagramId, shareId) {
+    return this.request(`/diagrams/${diagramId}/shares/${shareId}`, {
+      method: 'DELETE'
+    })
+  }
 }
 
 export const api = new ApiService()
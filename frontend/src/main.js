@@ -6,9 +6,20 @@ import './style.css'
 // Import views
 import Login from './views/Login.vue'
 import Signup from './views/Signup.vue'
+import ForgotPassword from './views/ForgotPassword.vue'
+import ResetPassword from './views/ResetPassword.vue'
+import VerifyEmail from './views/VerifyEmail.vue'
+import TwoFactor from './views/TwoFactor.vue'
+import Passkeys from './views/Passkeys.vue'
+import ConnectedAccounts from './views/ConnectedAccounts.vue'
+import LinkAccount from './views/LinkAccount.vue'
+import Account from './views/Account.vue'
+import RestoreAccount from './views/RestoreAccount.vue'
 import Sync from './views/Sync.vue'
 import Dashboard from './views/Dashboard.vue'
 import DiagramDetail from './views/DiagramDetail.vue'
+import Trash from './views/Trash.vue'
+import Search from './views/Search.vue'
 
 // Reactive auth state
 export const isAuthenticated = ref(false)
@@ -37,9 +48,20 @@ const router = createRouter({
     { path: '/', redirect: '/login' },
     { path: '/login', component: Login, meta: { guest: true } },
     { path: '/signup', component: Signup, meta: { guest: true } },
+    { path: '/forgot-password', component: ForgotPassword, meta: { guest: true } },
+    { path: '/reset-password', component: ResetPassword },
+    { path: '/verify-email', component: VerifyEmail },
+    { path: '/link-account', component: LinkAccount },
+    { path: '/restore-account', component: RestoreAccount },
     { path: '/sync', component: Sync, meta: { requiresAuth: true } },
     { path: '/dashboard', component: Dashboard, meta: { requiresAuth: true } },
     { path: '/dashboard/:diagramId', component: DiagramDetail, meta: { requiresAuth: true } },
+    { path: '/trash', component: Trash, meta: { requiresAuth: true } },
+    { path: '/search', component: Search, meta: { requiresAuth: true } },
+    { path: '/two-factor', component: TwoFactor, meta: { requiresAuth: true } },
+    { path: '/passkeys', component: Passkeys, meta: { requiresAuth: true } },
+    { path: '/connected-accounts', component: ConnectedAccounts, meta: { requiresAuth: true } },
+    { path: '/account', component: Account, meta: { requiresAuth: true } },
   ]
 })
 
@@ -0,0 +1,601 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/mailer"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	accountRestoreTokenPrefix = "art_"
+	accountPurgeInterval      = time.Hour
+)
+
+// exportPathPattern matches characters that are not kept in file names of the export
+var exportPathPattern = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ExportAccountData returns everything stored about the current user as a zip file:
+// account.json with the profile and account settings, audit_log.json with the user's audit events,
+// and for every diagram (including deleted ones) diagrams/<diagram_id>/diagram.json with its
+// metadata and versions/<version>.json with the data of each version
+// The zip file is streamed; an error while writing it can only cut the download short
+func ExportAccountData(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	export, err := accountExport(&user)
+	if err != nil {
+		log.Printf("Failed to export data of user %d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	filename := fmt.Sprintf("chartdb-export-%s.zip", time.Now().Format("2006-01-02"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	if err := writeAccountExport(c.Writer, &user, export); err != nil {
+		log.Printf("Failed to export data of user %d: %v", user.ID, err)
+		c.Abort()
+	}
+}
+
+// DeleteAccount deletes the current user's account and signs out everywhere
+// The account and its diagrams can be restored with the emailed link until the grace period
+// (ACCOUNT_DELETION_GRACE_PERIOD) ends, then they are erased for good
+func DeleteAccount(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !strings.EqualFold(strings.TrimSpace(req.Confirm), user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Type your email address to confirm"})
+		return
+	}
+	// Only local accounts have a password of their own to confirm with
+	if user.IsLocal() {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+			middleware.RecordAuthFailure(c)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+			return
+		}
+	}
+	if user.TwoFactorEnabled() && !verifySecondFactor(&user, req.Code, true) {
+		middleware.RecordAuthFailure(c)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// Someone has to be left to manage the server
+	if user.IsAdmin {
+		var admins int64
+		if err := database.DB.Model(&models.User{}).
+			Where("is_admin = ? AND deactivated_at IS NULL AND id <> ?", true, user.ID).
+			Count(&admins).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+			return
+		}
+		if admins == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You are the only administrator, make someone else an administrator first"})
+			return
+		}
+	}
+
+	token, err := middleware.GenerateOpaqueToken(accountRestoreTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	now := time.Now()
+	deletion := models.AccountDeletion{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		PurgeAt:   now.Add(config.AccountDeletionGracePeriod),
+		CreatedAt: now,
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.AccountDeletion{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := tx.Create(&deletion).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	// Restoring brings back the diagrams deleted here, not those the user had deleted before
+	if err := tx.Model(&models.Diagram{}).Where("user_id = ?", user.ID).Update("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	if err := tx.Model(&user).Update("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+		log.Printf("Failed to revoke sessions of deleted user %d: %v", user.ID, err)
+	}
+	middleware.ClearSessionCookies(c)
+
+	middleware.AuditUser(c, models.AuditAccountDeleted, &user, map[string]interface{}{
+		"purge_at": deletion.PurgeAt.Format(time.RFC3339),
+	})
+
+	if err := sendAccountDeletionEmail(c, &user, token, deletion.PurgeAt); err != nil {
+		log.Printf("Failed to send account deletion email to user %d: %v", user.ID, err)
+	}
+
+	log.Printf("User %d deleted their account, data will be erased at %s", user.ID, deletion.PurgeAt.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Your account has been deleted",
+		"purge_at": deletion.PurgeAt.Format(time.RFC3339),
+	})
+}
+
+// RestoreAccount undoes an account deletion with the token from the emailed link
+func RestoreAccount(c *gin.Context) {
+	var req models.RestoreAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var deletion models.AccountDeletion
+	err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(req.Token)).First(&deletion).Error
+	if err != nil || !deletion.PurgeAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired restore link"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Deleting the request first guards against concurrent use of the link
+	result := tx.Delete(&deletion)
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired restore link"})
+		return
+	}
+	if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", deletion.UserID).Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+	if err := tx.Unscoped().Model(&models.Diagram{}).
+		Where("user_id = ? AND deleted_at >= ?", deletion.UserID, deletion.CreatedAt).
+		Update("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore account"})
+		return
+	}
+
+	middleware.Audit(c, models.AuditEvent{Action: models.AuditAccountRestored, UserID: &deletion.UserID})
+
+	log.Printf("User %d restored their account", deletion.UserID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your account has been restored, you can sign in again"})
+}
+
+// StartAccountPurge erases the accounts whose grace period has ended, now and then every hour
+func StartAccountPurge() {
+	go func() {
+		purgeDeletedAccounts()
+
+		ticker := time.NewTicker(accountPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeDeletedAccounts()
+		}
+	}()
+}
+
+func purgeDeletedAccounts() {
+	var deletions []models.AccountDeletion
+	if err := database.DB.Where("purge_at <= ?", time.Now()).Find(&deletions).Error; err != nil {
+		log.Printf("Warning: failed to look up deleted accounts: %v", err)
+		return
+	}
+
+	for _, deletion := range deletions {
+		if err := database.DB.Transaction(func(tx *gorm.DB) error {
+			return purgeUser(tx, deletion.UserID)
+		}); err != nil {
+			log.Printf("Warning: failed to erase deleted account %d: %v", deletion.UserID, err)
+			continue
+		}
+		log.Printf("Erased deleted account %d", deletion.UserID)
+	}
+}
+
+// purgeUser permanently removes a user with their diagrams and everything else that refers to them
+// Their audit events are kept until they expire, without their email, IP addresses and user agents
+func purgeUser(tx *gorm.DB, userID uint) error {
+	// The account may already be gone, then only the events with its ID are found
+	user := models.User{ID: userID}
+	if err := tx.Unscoped().Limit(1).Find(&user, userID).Error; err != nil {
+		return err
+	}
+
+	diagramIDs := tx.Unscoped().Model(&models.Diagram{}).Select("id").Where("user_id = ?", userID)
+	sessionIDs := tx.Model(&models.Session{}).Select("id").Where("user_id = ?", userID)
+
+	if err := purgeDiagrams(tx, diagramIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.ShareLink{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("session_id IN (?)", sessionIDs).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("invited_by_id = ?", userID).Delete(&models.Invitation{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Invitation{}).Where("accepted_user_id = ?", userID).Update("accepted_user_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.LoginChallenge{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.Passkey{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.WebAuthnSession{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserIdentity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.IdentityLinkRequest{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.SCIMGroupMember{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.AccountDeletion{}).Error; err != nil {
+		return err
+	}
+	if err := userAuditEvents(tx, &user).Updates(map[string]interface{}{"email": "", "ip_address": "", "user_agent": ""}).Error; err != nil {
+		return err
+	}
+	// Events about the user, such as administrator actions, name them in their details
+	if err := tx.Model(&models.AuditEvent{}).Where("json_extract(details, '$.user_id') = ?", userID).
+		Update("details", gorm.Expr("json_remove(details, '$.email', '$.previous_email')")).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&models.User{}, userID).Error
+}
+
+// accountExport collects the account.json file of a data export
+func accountExport(user *models.User) (*models.AccountExport, error) {
+	export := models.AccountExport{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Profile: models.AccountExportProfile{
+			ID:               user.ID,
+			Email:            user.Email,
+			Name:             user.Name,
+			AuthProvider:     user.AuthProvider,
+			IsAdmin:          user.IsAdmin,
+			EmailVerifiedAt:  formatOptionalTime(user.EmailVerifiedAt),
+			TwoFactorEnabled: user.TwoFactorEnabled(),
+			ExternalID:       user.ExternalID,
+			CreatedAt:        user.CreatedAt.Format(time.RFC3339),
+			UpdatedAt:        user.UpdatedAt.Format(time.RFC3339),
+		},
+		Identities:           []models.UserIdentityResponse{},
+		Passkeys:             []models.PasskeyResponse{},
+		Sessions:             []models.SessionResponse{},
+		PersonalAccessTokens: []models.PersonalAccessTokenResponse{},
+		Invitations:          []models.InvitationResponse{},
+	}
+
+	var identities []models.UserIdentity
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, models.UserIdentityResponse{
+			ID:          identity.ID,
+			Provider:    identity.Provider,
+			Label:       identityLabel(identity.Provider),
+			Issuer:      identity.Issuer,
+			Email:       identity.Email,
+			LastLoginAt: formatOptionalTime(identity.LastLoginAt),
+			CreatedAt:   identity.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	var passkeys []models.Passkey
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range passkeys {
+		export.Passkeys = append(export.Passkeys, models.PasskeyResponse{
+			ID:         p.ID,
+			Name:       p.Name,
+			LastUsedAt: formatOptionalTime(p.LastUsedAt),
+			CreatedAt:  p.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	var sessions []models.Session
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		export.Sessions = append(export.Sessions, models.SessionResponse{
+			ID:         s.ID,
+			Device:     s.Device,
+			IPAddress:  s.IPAddress,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: s.LastSeenAt.Format(time.RFC3339),
+			ExpiresAt:  s.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range tokens {
+		export.PersonalAccessTokens = append(export.PersonalAccessTokens, toPersonalAccessTokenResponse(t))
+	}
+
+	var invitations []models.Invitation
+	if err := database.DB.Where("invited_by_id = ?", user.ID).Order("created_at").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+	for _, inv := range invitations {
+		export.Invitations = append(export.Invitations, toInvitationResponse(inv))
+	}
+
+	return &export, nil
+}
+
+// writeAccountExport writes the data export of a user as a zip file
+func writeAccountExport(w io.Writer, user *models.User, export *models.AccountExport) error {
+	archive := zip.NewWriter(w)
+
+	if err := writeExportJSON(archive, "account.json", export, time.Now()); err != nil {
+		return err
+	}
+	if err := writeAuditLogExport(archive, user); err != nil {
+		return err
+	}
+
+	var diagrams []models.Diagram
+	if err := database.DB.Unscoped().Where("user_id = ?", user.ID).Order("created_at").Find(&diagrams).Error; err != nil {
+		return err
+	}
+	for _, diagram := range diagrams {
+		if err := writeDiagramExport(archive, &diagram); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// userAuditEvents selects the audit events of a user: what they did, and failed logins with their email
+func userAuditEvents(tx *gorm.DB, user *models.User) *gorm.DB {
+	if user.Email == "" {
+		return tx.Model(&models.AuditEvent{}).Where("user_id = ?", user.ID)
+	}
+	return tx.Model(&models.AuditEvent{}).
+		Where("(user_id = ? OR (user_id IS NULL AND LOWER(email) = LOWER(?)))", user.ID, user.Email)
+}
+
+// writeAuditLogExport adds the audit events of a user to a data export as a JSON array, oldest first
+func writeAuditLogExport(archive *zip.Writer, user *models.User) error {
+	w, err := createExportFile(archive, "audit_log.json", time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	// Events are read in batches, there can be many
+	count := 0
+	var events []models.AuditEvent
+	result := userAuditEvents(database.DB, user).FindInBatches(&events, 500, func(tx *gorm.DB, batch int) error {
+		for _, event := range toAuditEventResponses(events, true) {
+			data, err := json.MarshalIndent(event, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := ",\n  "
+			if count == 0 {
+				separator = "\n  "
+			}
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	_, err = io.WriteString(w, "\n]\n")
+	return err
+}
+
+// writeDiagramExport adds the metadata, share links and versions of a diagram to a data export
+func writeDiagramExport(archive *zip.Writer, diagram *models.Diagram) error {
+	dir := "diagrams/" + exportPathPattern.ReplaceAllString(diagram.DiagramID, "_") + "/"
+
+	tags, err := loadDiagramTags([]models.Diagram{*diagram})
+	if err != nil {
+		return err
+	}
+
+	export := models.DiagramExport{
+		DiagramID:       diagram.DiagramID,
+		Name:            diagram.Name,
+		DatabaseType:    diagram.DatabaseType,
+		DatabaseEdition: diagram.DatabaseEdition,
+		Version:         diagram.Version,
+		Deleted:         diagram.DeletedAt.Valid,
+		Tags:            tags[diagram.ID],
+		CreatedAt:       diagram.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       diagram.UpdatedAt.Format(time.RFC3339),
+		Versions:        []models.DiagramVersionExport{},
+		ShareLinks:      []models.ShareLinkResponse{},
+	}
+
+	var links []models.ShareLink
+	if err := database.DB.Where("diagram_id = ?", diagram.ID).Order("created_at").Find(&links).Error; err != nil {
+		return err
+	}
+	for _, link := range links {
+		export.ShareLinks = append(export.ShareLinks, toShareLinkResponse(link))
+	}
+
+	// Versions are read one at a time, their data can be large
+	rows, err := database.DB.Model(&models.DiagramVersion{}).Where("diagram_id = ?", diagram.ID).Order("version").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version models.DiagramVersion
+		if err := database.DB.ScanRows(rows, &version); err != nil {
+			return err
+		}
+		export.Versions = append(export.Versions, models.DiagramVersionExport{
+			Version:     version.Version,
+			Description: version.Description,
+			Source:      version.Source,
+			Client:      version.Client,
+			CreatedAt:   version.CreatedAt.Format(time.RFC3339),
+		})
+
+		w, err := createExportFile(archive, fmt.Sprintf("%sversions/%d.json", dir, version.Version), version.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(version.Data)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return writeExportJSON(archive, dir+"diagram.json", export, diagram.UpdatedAt)
+}
+
+// writeExportJSON adds an indented JSON file to a data export
+func writeExportJSON(archive *zip.Writer, name string, v interface{}, modified time.Time) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := createExportFile(archive, name, modified)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// createExportFile adds a compressed file to a data export
+func createExportFile(archive *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+}
+
+// sendAccountDeletionEmail tells the user their account was deleted and how to restore it
+func sendAccountDeletionEmail(c *gin.Context, user *models.User, token string, purgeAt time.Time) error {
+	link := fmt.Sprintf("%s/sync/restore-account?token=%s", appBaseURL(c), url.QueryEscape(token))
+	return mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your ChartDB Sync account has been deleted",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Your ChartDB Sync account and its diagrams have been deleted.\n"+
+			"They will be erased for good on %s. Until then, you can restore them with this link:\n\n%s\n\n"+
+			"If you didn't delete your account, restore it and change your password.\n",
+			user.Name, purgeAt.Format("January 2, 2006 at 15:04 MST"), link),
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// auditAs records an audit event as if the request came from the user and returns its ID
+func auditAs(t *testing.T, user *models.User, event models.AuditEvent) uint {
+	t.Helper()
+
+	event.IPAddress = "203.0.113.7"
+	event.UserAgent = "Firefox"
+	if event.UserID == nil && event.Email == "" {
+		event.UserID = &user.ID
+		event.Email = user.Email
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	return event.ID
+}
+
+func TestExportAccountDataIncludesAuditLog(t *testing.T) {
+	user := createTestUser(t, "export@example.com")
+	other := createTestUser(t, "export-other@example.com")
+	createSearchTestDiagram(t, user, "export-shop", searchTestDiagram)
+
+	auditAs(t, user, models.AuditEvent{Action: models.AuditLoginSucceeded})
+	auditAs(t, user, models.AuditEvent{Action: models.AuditLoginFailed, Email: "EXPORT@example.com"})
+	auditAs(t, other, models.AuditEvent{Action: models.AuditLoginSucceeded})
+
+	r := gin.New()
+	r.GET("/export", func(c *gin.Context) { c.Set("user_id", user.ID) }, ExportAccountData)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("export is not a zip file: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name], _ = io.ReadAll(f)
+		f.Close()
+	}
+
+	for _, name := range []string{"account.json", "diagrams/export-shop/diagram.json", "diagrams/export-shop/versions/1.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("export is missing %s", name)
+		}
+	}
+
+	var events []models.AuditEventResponse
+	if err := json.Unmarshal(files["audit_log.json"], &events); err != nil {
+		t.Fatalf("audit_log.json: %v\n%s", err, files["audit_log.json"])
+	}
+	if len(events) != 2 {
+		t.Fatalf("exported %d audit events, want the user's 2: %+v", len(events), events)
+	}
+	if events[0].Action != models.AuditLoginSucceeded || events[0].IPAddress != "203.0.113.7" {
+		t.Errorf("first exported event = %+v", events[0])
+	}
+}
+
+func TestPurgeUserScrubsAuditEvents(t *testing.T) {
+	admin := createTestUser(t, "purge-admin@example.com")
+	user := createTestUser(t, "purge-audit@example.com")
+
+	ownIDs := []uint{
+		auditAs(t, user, models.AuditEvent{Action: models.AuditLoginSucceeded}),
+		auditAs(t, user, models.AuditEvent{Action: models.AuditLoginFailed, Email: user.Email}),
+	}
+	aboutID := auditAs(t, admin, models.AuditEvent{
+		Action:  models.AuditUserUpdated,
+		Details: map[string]interface{}{"user_id": user.ID, "email": user.Email, "previous_email": "old@example.com", "name": "Purged"},
+	})
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeUser(tx, user.ID)
+	}); err != nil {
+		t.Fatalf("purge user: %v", err)
+	}
+
+	var own []models.AuditEvent
+	database.DB.Where("id IN ?", ownIDs).Find(&own)
+	if len(own) != 2 {
+		t.Fatalf("%d events of the user kept, want 2", len(own))
+	}
+	for _, event := range own {
+		if event.Email != "" || event.IPAddress != "" || event.UserAgent != "" {
+			t.Errorf("%s event still has %q, %q, %q", event.Action, event.Email, event.IPAddress, event.UserAgent)
+		}
+	}
+
+	var about models.AuditEvent
+	database.DB.First(&about, aboutID)
+	if _, ok := about.Details["email"]; ok || about.Details["previous_email"] != nil {
+		t.Errorf("administrator event still names the user: %v", about.Details)
+	}
+	if about.Details["name"] != "Purged" || about.Email != admin.Email || about.IPAddress == "" {
+		t.Errorf("administrator event lost more than the user's email: %+v", about)
+	}
+}
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultUsersPerPage = 50
+	maxUsersPerPage     = 200
+)
+
+// ListUsers lists users for the admin console (admin only)
+// Supports ?q= (search in email and name), ?status=active|disabled, ?page= and ?per_page=
+func ListUsers(c *gin.Context) {
+	page, perPage := pagination(c, defaultUsersPerPage, maxUsersPerPage)
+
+	query := database.DB.Model(&models.User{})
+	if q := strings.ToLower(strings.TrimSpace(c.Query("q"))); q != "" {
+		pattern := "%" + q + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(name) LIKE ?", pattern, pattern)
+	}
+	switch c.Query("status") {
+	case "":
+	case "active":
+		query = query.Where("deactivated_at IS NULL")
+	case "disabled":
+		query = query.Where("deactivated_at IS NOT NULL")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or disabled"})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("id").Offset((page - 1) * perPage).Limit(perPage).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AdminUserListResponse{
+		Users:   toAdminUserResponses(users),
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// GetUser returns a user for the admin console (admin only)
+func GetUser(c *gin.Context) {
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, toAdminUserResponses([]models.User{*user})[0])
+}
+
+// AdminUpdateUser changes a user's email, name or administrator role (admin only)
+func AdminUpdateUser(c *gin.Context) {
+	adminID := middleware.GetUserID(c)
+
+	var req models.AdminUpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != user.Email {
+			var existing models.User
+			if err := database.DB.Unscoped().Where("LOWER(email) = LOWER(?) AND id <> ?", email, user.ID).First(&existing).Error; err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+				return
+			}
+			updates["email"] = email
+			updates["email_verified_at"] = time.Now()
+		}
+	}
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.IsAdmin != nil && !*req.IsAdmin && user.ID == adminID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't remove your own administrator role"})
+		return
+	}
+
+	if len(updates) > 0 {
+		previousEmail := user.Email
+		if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+		details := map[string]interface{}{}
+		for field, value := range updates {
+			if field != "email_verified_at" {
+				details[field] = value
+			}
+		}
+		if _, ok := updates["email"]; ok {
+			details["previous_email"] = previousEmail
+		}
+		auditUserChange(c, models.AuditUserUpdated, user, details)
+		// An email set by an administrator counts as verified
+		database.DB.First(user, user.ID)
+		if err := database.PromoteAdminEmail(database.DB, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+			return
+		}
+	}
+	if req.IsAdmin != nil {
+		if err := setAdminRole(c, user, *req.IsAdmin, models.AdminSourceManual); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+			return
+		}
+	}
+
+	database.DB.First(user, user.ID)
+	c.JSON(http.StatusOK, toAdminUserResponses([]models.User{*user})[0])
+}
+
+// DisableUser deactivates a user: they are signed out everywhere, their personal access tokens
+// are revoked and they can't sign in until enabled again (admin only)
+func DisableUser(c *gin.Context) {
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if user.ID == middleware.GetUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't disable your own account"})
+		return
+	}
+
+	if user.IsActive() {
+		if err := deactivateUser(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+			return
+		}
+		auditUserChange(c, models.AuditUserDisabled, user, nil)
+	}
+
+	database.DB.First(user, user.ID)
+	c.JSON(http.StatusOK, toAdminUserResponses([]models.User{*user})[0])
+}
+
+// EnableUser lets a disabled user sign in again (admin only)
+// Their revoked personal access tokens stay revoked
+func EnableUser(c *gin.Context) {
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.IsActive() {
+		if err := database.DB.Model(user).Update("deactivated_at", nil).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+			return
+		}
+		auditUserChange(c, models.AuditUserEnabled, user, nil)
+	}
+
+	database.DB.First(user, user.ID)
+	c.JSON(http.StatusOK, toAdminUserResponses([]models.User{*user})[0])
+}
+
+// LogoutUser ends all sessions of a user (admin only)
+// Personal access tokens keep working; disable the user to revoke them too
+func LogoutUser(c *gin.Context) {
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	count, err := middleware.RevokeUserSessions(user.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	auditUserChange(c, models.AuditUserLoggedOut, user, map[string]interface{}{"sessions": count})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User signed out of all sessions", "count": count})
+}
+
+// ResetUserPassword sets a new password for a user and signs them out everywhere, or emails them
+// a reset link when no password is given (admin only)
+func ResetUserPassword(c *gin.Context) {
+	var req models.AdminResetPasswordRequest
+	// The body is optional
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.IsLocal() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This user signs in with single sign-on or the directory"})
+		return
+	}
+
+	if req.NewPassword == "" {
+		if !user.IsActive() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Enable the account before sending a reset link"})
+			return
+		}
+		if err := sendPasswordResetEmail(c, user); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset link sent to " + user.Email})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := database.DB.Model(user).Update("password", string(hashedPassword)).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Whoever knew the old password must not stay signed in
+	if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password reset: %v", user.ID, err)
+	}
+
+	auditUserChange(c, models.AuditPasswordReset, user, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// DeleteUser disables a user and soft-deletes them with their diagrams (admin only)
+// Both stay in the database; TransferUserDiagrams can still hand the diagrams to someone else
+func DeleteUser(c *gin.Context) {
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if user.ID == middleware.GetUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't delete your own account"})
+		return
+	}
+
+	if user.IsActive() {
+		if err := deactivateUser(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+			return
+		}
+	}
+
+	tx := database.DB.Begin()
+
+	result := tx.Where("user_id = ?", user.ID).Delete(&models.Diagram{})
+	if result.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.SCIMGroupMember{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	if err := tx.Delete(user).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	auditUserChange(c, models.AuditUserDeleted, user, map[string]interface{}{"diagrams": result.RowsAffected})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully", "diagram_count": result.RowsAffected})
+}
+
+// setAdminRole grants or revokes the administrator role of a user on behalf of source: an
+// administrator (AdminSourceManual), or the admin groups of ldap, scim or oidc:<provider>
+// Administrators always decide. A directory grants the role to users who don't have it yet, and
+// only revokes it from users it granted it to, so it never overrides an administrator or another
+// directory. Revoking the role ends the user's sessions and removes the admin scope from their
+// personal access tokens.
+func setAdminRole(c *gin.Context, user *models.User, isAdmin bool, source string) error {
+	if user.IsAdmin == isAdmin {
+		return nil
+	}
+	if !isAdmin && source != models.AdminSourceManual && user.AdminSource != source {
+		return nil
+	}
+
+	adminSource := ""
+	if isAdmin {
+		adminSource = source
+	}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"is_admin": isAdmin, "admin_source": adminSource}).Error; err != nil {
+			return err
+		}
+		if isAdmin {
+			return nil
+		}
+		return removeAdminScope(tx, user.ID)
+	}); err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+			return err
+		}
+	}
+	auditUserChange(c, models.AuditUserRoleChanged, user, map[string]interface{}{"is_admin": isAdmin, "source": source})
+	return nil
+}
+
+// removeAdminScope takes the admin scope away from the personal access tokens of a user
+// Tokens left without a scope are deleted
+func removeAdminScope(tx *gorm.DB, userID uint) error {
+	var tokens []models.PersonalAccessToken
+	if err := tx.Where("user_id = ?", userID).Find(&tokens).Error; err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		scopes := slices.DeleteFunc(token.ScopeList(), func(scope string) bool { return scope == models.ScopeAdmin })
+		switch {
+		case len(scopes) == len(token.ScopeList()):
+			continue
+		case len(scopes) == 0:
+			if err := tx.Delete(&token).Error; err != nil {
+				return err
+			}
+		default:
+			if err := tx.Model(&token).Update("scopes", strings.Join(scopes, ",")).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deactivateUser blocks a user from signing in and ends their sessions and personal access tokens
+func deactivateUser(user *models.User) error {
+	now := time.Now()
+	if err := database.DB.Model(user).Update("deactivated_at", now).Error; err != nil {
+		return err
+	}
+	if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+		return err
+	}
+	return database.DB.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}).Error
+}
+
+// auditUserChange records a change made to a user, by the signed-in administrator if there is one
+// The user the change was made to is kept in the details
+func auditUserChange(c *gin.Context, action string, user *models.User, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["user_id"] = user.ID
+	details["email"] = user.Email
+	middleware.Audit(c, models.AuditEvent{Action: action, Details: details})
+}
+
+// findAdminTargetUser loads the user in the userId parameter, responding with 404 if there is none
+func findAdminTargetUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := database.DB.First(&user, c.Param("userId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// toAdminUserResponses converts users for the admin console, counting their diagrams and sessions
+func toAdminUserResponses(users []models.User) []models.AdminUserResponse {
+	userIDs := make([]uint, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	type userCount struct {
+		UserID uint
+		Count  int64
+	}
+	diagramCounts := make(map[uint]int64)
+	sessionCounts := make(map[uint]int64)
+	if len(userIDs) > 0 {
+		var counts []userCount
+		database.DB.Model(&models.Diagram{}).Select("user_id, COUNT(*) AS count").
+			Where("user_id IN ?", userIDs).Group("user_id").Scan(&counts)
+		for _, count := range counts {
+			diagramCounts[count.UserID] = count.Count
+		}
+
+		counts = nil
+		database.DB.Model(&models.Session{}).Select("user_id, COUNT(*) AS count").
+			Where("user_id IN ? AND expires_at > ?", userIDs, time.Now()).Group("user_id").Scan(&counts)
+		for _, count := range counts {
+			sessionCounts[count.UserID] = count.Count
+		}
+	}
+
+	response := make([]models.AdminUserResponse, len(users))
+	for i, user := range users {
+		authProvider := user.AuthProvider
+		if authProvider == "" {
+			authProvider = "local"
+		}
+		response[i] = models.AdminUserResponse{
+			ID:               user.ID,
+			Email:            user.Email,
+			Name:             user.Name,
+			AuthProvider:     authProvider,
+			IsAdmin:          user.IsAdmin,
+			Active:           user.IsActive(),
+			EmailVerified:    user.IsEmailVerified(),
+			TwoFactorEnabled: user.TwoFactorEnabled(),
+			DiagramCount:     diagramCounts[user.ID],
+			SessionCount:     sessionCounts[user.ID],
+			DeactivatedAt:    formatOptionalTime(user.DeactivatedAt),
+			CreatedAt:        user.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return response
+}
+
+// pagination reads the 1-based ?page= and ?per_page= query parameters
+func pagination(c *gin.Context, defaultPerPage, maxPerPage int) (int, int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err := strconv.Atoi(c.Query("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
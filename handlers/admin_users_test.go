@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	admin := createTestUser(t, "audit-admin@example.com")
+	target := createTestUser(t, "audit-target@example.com")
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", admin.ID)
+		c.Set("user_email", admin.Email)
+	})
+	r.PUT("/users/:userId", AdminUpdateUser)
+	r.POST("/users/:userId/disable", DisableUser)
+	r.POST("/users/:userId/enable", EnableUser)
+	r.POST("/users/:userId/logout", LogoutUser)
+	r.DELETE("/users/:userId", DeleteUser)
+	r.PUT("/settings", UpdateSettings)
+	defer database.SetBoolSetting(models.SettingRequireTwoFactor, false)
+
+	path := fmt.Sprintf("/users/%d", target.ID)
+	for _, tc := range []struct {
+		method, path, body string
+		action             string
+		details            map[string]interface{}
+	}{
+		{http.MethodPut, path, `{"name": "Renamed"}`, models.AuditUserUpdated, map[string]interface{}{"name": "Renamed"}},
+		{http.MethodPut, path, `{"is_admin": true}`, models.AuditUserRoleChanged, map[string]interface{}{"is_admin": true, "source": "admin"}},
+		{http.MethodPost, path + "/logout", "", models.AuditUserLoggedOut, nil},
+		{http.MethodPost, path + "/disable", "", models.AuditUserDisabled, nil},
+		{http.MethodPost, path + "/enable", "", models.AuditUserEnabled, nil},
+		{http.MethodDelete, path, "", models.AuditUserDeleted, nil},
+		{http.MethodPut, "/settings", `{"require_two_factor": true}`, models.AuditSettingsChanged, map[string]interface{}{models.SettingRequireTwoFactor: true}},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+
+		var event models.AuditEvent
+		if err := database.DB.Where("action = ?", tc.action).Order("id desc").First(&event).Error; err != nil {
+			t.Errorf("%s %s: no %s event: %v", tc.method, tc.path, tc.action, err)
+			continue
+		}
+		if event.UserID == nil || *event.UserID != admin.ID {
+			t.Errorf("%s event was not recorded for the administrator: %v", tc.action, event.UserID)
+		}
+		if tc.action != models.AuditSettingsChanged && event.Details["user_id"] != float64(target.ID) {
+			t.Errorf("%s event doesn't name the user: %v", tc.action, event.Details)
+		}
+		for key, want := range tc.details {
+			if event.Details[key] != want {
+				t.Errorf("%s event detail %s = %v, want %v", tc.action, key, event.Details[key], want)
+			}
+		}
+	}
+}
+
+func TestAdminRoleSources(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	setRole := func(user *models.User, isAdmin bool, source string) {
+		t.Helper()
+		if err := setAdminRole(c, user, isAdmin, source); err != nil {
+			t.Fatal(err)
+		}
+		database.DB.First(user, user.ID)
+	}
+
+	t.Run("directories don't revoke a role granted by an administrator", func(t *testing.T) {
+		user := createTestUser(t, "role-manual@example.com")
+		setRole(user, true, models.AdminSourceManual)
+		setRole(user, false, "ldap")
+		setRole(user, false, "oidc:okta")
+		if !user.IsAdmin || user.AdminSource != models.AdminSourceManual {
+			t.Errorf("role = %v from %q, want the administrator's grant to stay", user.IsAdmin, user.AdminSource)
+		}
+	})
+
+	t.Run("a directory only revokes the role it granted", func(t *testing.T) {
+		user := createTestUser(t, "role-directory@example.com")
+		setRole(user, true, "ldap")
+		setRole(user, false, "scim")
+		if !user.IsAdmin {
+			t.Fatal("SCIM revoked a role granted by LDAP")
+		}
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+		if _, err := middleware.CreateSession(c, user); err != nil {
+			t.Fatal(err)
+		}
+		adminOnly := models.PersonalAccessToken{UserID: user.ID, Name: "admin", TokenHash: "role-admin-only", Scopes: models.ScopeAdmin}
+		mixed := models.PersonalAccessToken{UserID: user.ID, Name: "mixed", TokenHash: "role-mixed", Scopes: "diagrams:read,admin"}
+		database.DB.Create(&adminOnly)
+		database.DB.Create(&mixed)
+
+		setRole(user, false, "ldap")
+		if user.IsAdmin || user.AdminSource != "" {
+			t.Errorf("role = %v from %q after LDAP revoked it", user.IsAdmin, user.AdminSource)
+		}
+
+		var sessions int64
+		database.DB.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&sessions)
+		if sessions != 0 {
+			t.Errorf("%d sessions left after losing the role", sessions)
+		}
+		if err := database.DB.First(&adminOnly, adminOnly.ID).Error; err == nil {
+			t.Error("token with only the admin scope was kept")
+		}
+		database.DB.First(&mixed, mixed.ID)
+		if mixed.Scopes != models.ScopeDiagramsRead {
+			t.Errorf("token scopes = %q, want %q", mixed.Scopes, models.ScopeDiagramsRead)
+		}
+	})
+
+	t.Run("administrators can revoke a role granted by a directory", func(t *testing.T) {
+		user := createTestUser(t, "role-override@example.com")
+		setRole(user, true, "scim")
+		setRole(user, false, models.AdminSourceManual)
+		if user.IsAdmin {
+			t.Error("administrator couldn't revoke a role granted through SCIM")
+		}
+	})
+}
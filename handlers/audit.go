@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditEventsPerPage = 50
+	maxAuditEventsPerPage     = 500
+)
+
+// ListAuditEvents returns audit events, newest first (admin only)
+// Filters: ?action= (comma-separated), ?user_id=, ?email=, ?diagram_id= (ChartDB ID), ?ip=,
+// ?since= and ?until= (RFC 3339); paginated with ?page= and ?per_page=
+func ListAuditEvents(c *gin.Context) {
+	query := database.DB.Model(&models.AuditEvent{})
+
+	if actions := c.Query("action"); actions != "" {
+		query = query.Where("action IN ?", strings.Split(actions, ","))
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id must be a number"})
+			return
+		}
+		query = query.Where("user_id = ?", id)
+	}
+	if email := strings.ToLower(strings.TrimSpace(c.Query("email"))); email != "" {
+		query = query.Where("LOWER(email) = ?", email)
+	}
+	if diagramID := c.Query("diagram_id"); diagramID != "" {
+		// Deleted diagrams keep their events
+		var diagram models.Diagram
+		if err := database.DB.Unscoped().Where("diagram_id = ?", diagramID).First(&diagram).Error; err != nil {
+			page, perPage := pagination(c, defaultAuditEventsPerPage, maxAuditEventsPerPage)
+			c.JSON(http.StatusOK, models.AuditEventListResponse{Events: []models.AuditEventResponse{}, Page: page, PerPage: perPage})
+			return
+		}
+		query = query.Where("diagram_id = ?", diagram.ID)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+	for param, condition := range map[string]string{"since": "created_at >= ?", "until": "created_at < ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time, e.g. 2024-01-31T00:00:00Z"})
+			return
+		}
+		query = query.Where(condition, t)
+	}
+
+	respondWithAuditEvents(c, query, true)
+}
+
+// GetDiagramActivity returns the activity feed of a diagram, newest first
+// Only the owner can see it; IP addresses and user agents are left out
+func GetDiagramActivity(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	respondWithAuditEvents(c, database.DB.Model(&models.AuditEvent{}).Where("diagram_id = ?", diagram.ID), false)
+}
+
+// respondWithAuditEvents responds with the requested page of the events matched by query
+func respondWithAuditEvents(c *gin.Context, query *gorm.DB, withClient bool) {
+	page, perPage := pagination(c, defaultAuditEventsPerPage, maxAuditEventsPerPage)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("id desc").Offset((page - 1) * perPage).Limit(perPage).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuditEventListResponse{
+		Events:  toAuditEventResponses(events, withClient),
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// toAuditEventResponses converts audit events, identifying their diagrams by ChartDB ID and name
+// IP address and user agent are only included withClient
+func toAuditEventResponses(events []models.AuditEvent, withClient bool) []models.AuditEventResponse {
+	var diagramIDs []uint
+	for _, event := range events {
+		if event.DiagramID != nil {
+			diagramIDs = append(diagramIDs, *event.DiagramID)
+		}
+	}
+	diagrams := make(map[uint]models.Diagram)
+	if len(diagramIDs) > 0 {
+		var found []models.Diagram
+		database.DB.Unscoped().Where("id IN ?", diagramIDs).Find(&found)
+		for _, diagram := range found {
+			diagrams[diagram.ID] = diagram
+		}
+	}
+
+	response := make([]models.AuditEventResponse, len(events))
+	for i, event := range events {
+		response[i] = models.AuditEventResponse{
+			ID:        event.ID,
+			Action:    event.Action,
+			UserID:    event.UserID,
+			Email:     event.Email,
+			Details:   event.Details,
+			CreatedAt: event.CreatedAt.Format(time.RFC3339),
+		}
+		if event.DiagramID != nil {
+			diagram := diagrams[*event.DiagramID]
+			response[i].DiagramID = diagram.DiagramID
+			response[i].DiagramName = diagram.Name
+		}
+		if withClient {
+			response[i].IPAddress = event.IPAddress
+			response[i].UserAgent = event.UserAgent
+		}
+	}
+	return response
+}
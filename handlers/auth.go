@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
 	"github.com/thorved/chartdb-backend/database"
 	"github.com/thorved/chartdb-backend/middleware"
 	"github.com/thorved/chartdb-backend/models"
@@ -18,9 +22,36 @@ func Signup(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
+	// An invitation lets the invited email sign up whatever the registration mode
+	var invitation *models.Invitation
+	if req.InviteToken != "" {
+		invitation = findPendingInvitation(req.InviteToken)
+		if invitation == nil || !invitation.MatchesEmail(req.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+			return
+		}
+		req.Email = invitation.Email
+	} else {
+		switch config.RegistrationMode {
+		case config.RegistrationDisabled:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+			return
+		case config.RegistrationInviteOnly:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Registration is by invitation only"})
+			return
+		}
+	}
+
+	if !config.EmailDomainAllowed(req.Email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is not allowed for this email domain"})
+		return
+	}
+
+	// Check if user already exists, including accounts deleted during their grace period
 	var existingUser models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := database.DB.Unscoped().Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		// Counted as a failure to slow down probing for registered emails
+		middleware.RecordAuthFailure(c)
 		c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
 		return
 	}
@@ -39,96 +70,229 @@ func Signup(c *gin.Context) {
 		Name:     req.Name,
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
+	// The invitation was sent to this address, so it doesn't need to be verified again
+	if invitation != nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	// Generate token
-	token, err := middleware.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	if invitation != nil {
+		if err := acceptInvitation(tx, invitation, user.ID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invitation"})
+			return
+		}
+	}
+
+	if err := database.PromoteAdminEmail(tx, &user); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	// Store token for single-session enforcement
-	user.CurrentToken = token
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	// Set auth cookie
-	SetAuthCookie(c, token)
+	// The account can sign in right away, but can't push diagrams until the email is verified
+	if config.EmailVerificationRequired() && !user.IsEmailVerified() {
+		if err := sendVerificationEmail(c, &user); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
 
-	c.JSON(http.StatusCreated, models.AuthResponse{
-		Token: token,
-		User: models.UserResponse{
-			ID:    user.ID,
-			Email: user.Email,
-			Name:  user.Name,
-		},
-	})
+	startSession(c, http.StatusCreated, &user, "signup")
 }
 
 // Login handles user authentication
 func Login(c *gin.Context) {
 	var req models.UserLoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if config.LDAPEnabled {
+		var ldapReq models.LDAPLoginRequest
+		if err := c.ShouldBindJSON(&ldapReq); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req = models.UserLoginRequest(ldapReq)
+	} else if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Find user
 	var user models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	err := database.DB.Where("email = ?", req.Email).First(&user).Error
+
+	// Directory users, and anyone without a local account while LDAP is enabled, sign in against LDAP
+	if config.LDAPEnabled && (err != nil || user.AuthProvider == ldapProviderName) {
+		ldapLogin(c, req.Email, req.Password)
+		return
+	}
+
+	if err != nil {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, nil, req.Email, "password", "unknown_email")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	// Accounts of an SSO or directory provider have no password of their own, and a password login
+	// would skip the 2FA requirement, which only applies to local accounts
+	if !user.IsLocal() {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, &user, user.Email, "password", "not_local")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, &user, user.Email, "password", "wrong_password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
-	// Generate token
-	token, err := middleware.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	if !user.IsActive() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
 		return
 	}
 
-	// Store token for single-session enforcement
-	user.CurrentToken = token
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+	// With 2FA the password only earns a challenge, completed with a code at /auth/2fa/verify
+	if user.TwoFactorEnabled() {
+		startLoginChallenge(c, &user)
+		return
+	}
+
+	startSession(c, http.StatusOK, &user, "password")
+}
+
+// startSession signs the user in and responds with the session tokens
+// method names how the user signed in, for the audit log
+func startSession(c *gin.Context, status int, user *models.User, method string) {
+	// Start a new session (other sessions of the user stay signed in)
+	tokens, err := middleware.CreateSession(c, user)
+	if err == middleware.ErrAccountDeactivated {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
-	// Set auth cookie
-	SetAuthCookie(c, token)
+	middleware.AuditUser(c, models.AuditLoginSucceeded, user, map[string]interface{}{"method": method})
 
-	c.JSON(http.StatusOK, models.AuthResponse{
-		Token: token,
+	// Set auth cookies
+	middleware.SetSessionCookies(c, tokens)
+
+	c.JSON(status, models.AuthResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
 		User: models.UserResponse{
-			ID:    user.ID,
-			Email: user.Email,
-			Name:  user.Name,
+			ID:               user.ID,
+			Email:            user.Email,
+			Name:             user.Name,
+			EmailVerified:    user.IsEmailVerified(),
+			TwoFactorEnabled: user.TwoFactorEnabled(),
 		},
 	})
 }
 
+// auditLoginFailure records a failed sign-in; user is nil when the email doesn't belong to an account
+func auditLoginFailure(c *gin.Context, user *models.User, email, method, reason string) {
+	event := models.AuditEvent{
+		Action:  models.AuditLoginFailed,
+		Email:   strings.ToLower(strings.TrimSpace(email)),
+		Details: map[string]interface{}{"method": method, "reason": reason},
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+	middleware.Audit(c, event)
+}
+
 // Logout handles user logout
+// Browsers are identified by their auth cookie; other clients send their access token as a Bearer
+// token or their refresh token in the body, as for RefreshToken
 func Logout(c *gin.Context) {
-	// Clear the current session token to invalidate other sessions
-	userID := middleware.GetUserID(c)
-	if userID > 0 {
-		database.DB.Model(&models.User{}).Where("id = ?", userID).Update("current_token", "")
+	// End only the session of this client, other devices stay signed in
+	var session *models.Session
+	if token, err := c.Cookie(middleware.AuthCookieName); err == nil && token != "" {
+		session = middleware.EndSession(token)
+	} else if token := middleware.BearerToken(c); token != "" {
+		session = middleware.EndSession(token)
 	}
+	if session == nil {
+		var req models.RefreshRequest
+		c.ShouldBindJSON(&req)
+		if req.RefreshToken != "" {
+			session = middleware.EndRefreshSession(req.RefreshToken)
+		}
+	}
+
+	middleware.ClearSessionCookies(c)
 
-	ClearAuthCookie(c)
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	// After an OIDC login, the browser also has to visit the provider to end its session there
+	response := gin.H{"message": "Logged out successfully"}
+	if session != nil && session.OIDCProvider != "" {
+		if provider := config.GetOIDCProvider(session.OIDCProvider); provider != nil {
+			if logoutURL := oidcLogoutURL(c, provider, session.IDToken); logoutURL != "" {
+				response["logout_url"] = logoutURL
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RefreshToken issues a new access token and rotates the refresh token
+// The refresh token is read from the request body or, for browsers, the refresh cookie
+func RefreshToken(c *gin.Context) {
+	var req models.RefreshRequest
+	c.ShouldBindJSON(&req)
+
+	refreshToken := req.RefreshToken
+	if refreshToken == "" {
+		refreshToken, _ = c.Cookie(middleware.RefreshCookieName)
+	}
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token required"})
+		return
+	}
+
+	tokens, err := middleware.RefreshSession(refreshToken)
+	if err != nil {
+		// Another tab refreshed with the same cookie a moment ago and already received new cookies
+		if err == middleware.ErrRefreshInProgress {
+			c.JSON(http.StatusConflict, gin.H{"error": "Session was just refreshed"})
+			return
+		}
+		if err == middleware.ErrInvalidRefreshToken || err == middleware.ErrRefreshTokenReused {
+			middleware.ClearSessionCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please login again."})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	middleware.SetSessionCookies(c, tokens)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
 }
 
 // GetCurrentUser returns the current authenticated user
@@ -142,9 +306,11 @@ func GetCurrentUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.UserResponse{
-		ID:    user.ID,
-		Email: user.Email,
-		Name:  user.Name,
+		ID:               user.ID,
+		Email:            user.Email,
+		Name:             user.Name,
+		EmailVerified:    user.IsEmailVerified(),
+		TwoFactorEnabled: user.TwoFactorEnabled(),
 	})
 }
 
@@ -173,9 +339,11 @@ func UpdateUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.UserResponse{
-		ID:    user.ID,
-		Email: user.Email,
-		Name:  user.Name,
+		ID:               user.ID,
+		Email:            user.Email,
+		Name:             user.Name,
+		EmailVerified:    user.IsEmailVerified(),
+		TwoFactorEnabled: user.TwoFactorEnabled(),
 	})
 }
 
@@ -198,8 +366,14 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	if user.AuthProvider == ldapProviderName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Your password is managed by your organization's directory"})
+		return
+	}
+
 	// Verify current password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		middleware.RecordAuthFailure(c)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
 		return
 	}
@@ -217,5 +391,12 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	// Other devices signed in with the old password are signed out, this one stays signed in
+	if _, err := middleware.RevokeUserSessions(user.ID, middleware.GetSessionID(c)); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password change: %v", user.ID, err)
+	}
+
+	middleware.Audit(c, models.AuditEvent{Action: models.AuditPasswordChanged})
+
 	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
 }
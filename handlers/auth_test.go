@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setTestPassword gives a test user a local password
+func setTestPassword(t *testing.T, user *models.User, password string) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Password = string(hash)
+	if err := database.DB.Model(user).Update("password", user.Password).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestSession signs the user in and returns the tokens of the new session
+func createTestSession(t *testing.T, user *models.User) *middleware.SessionTokens {
+	t.Helper()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	tokens, err := middleware.CreateSession(c, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestChangePasswordSignsOutOtherSessions(t *testing.T) {
+	user := createTestUser(t, "change-password@example.com")
+	setTestPassword(t, user, "old-password")
+
+	current := createTestSession(t, user)
+	createTestSession(t, user)
+	createTestSession(t, user)
+
+	r := gin.New()
+	r.PUT("/password", func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("session_id", current.SessionID)
+	}, ChangePassword)
+
+	req := httptest.NewRequest(http.MethodPut, "/password", strings.NewReader(`{"current_password": "old-password", "new_password": "new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var sessions []models.Session
+	database.DB.Where("user_id = ?", user.ID).Find(&sessions)
+	if len(sessions) != 1 || sessions[0].ID != current.SessionID {
+		t.Errorf("%d sessions left after changing the password, want only the current one", len(sessions))
+	}
+}
+
+// refresh exchanges a refresh token through the refresh endpoint
+func refresh(t *testing.T, refreshToken string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := gin.New()
+	r.POST("/refresh", RefreshToken)
+	body, _ := json.Marshal(models.RefreshRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func sessionExists(sessionID uint) bool {
+	var count int64
+	database.DB.Model(&models.Session{}).Where("id = ?", sessionID).Count(&count)
+	return count == 1
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	user := createTestUser(t, "refresh-rotate@example.com")
+	tokens := createTestSession(t, user)
+
+	w := refresh(t, tokens.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", w.Code, w.Body.String())
+	}
+	var rotated struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+	if rotated.RefreshToken == "" || rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh token was not rotated: %q", rotated.RefreshToken)
+	}
+	if claims, err := middleware.ValidateToken(rotated.Token); err != nil || claims.UserID != user.ID {
+		t.Fatalf("new access token: %v", err)
+	}
+
+	// Another tab presenting the old token right away is told the session was just refreshed
+	if w := refresh(t, tokens.RefreshToken); w.Code != http.StatusConflict {
+		t.Errorf("old token within the grace window: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if !sessionExists(tokens.SessionID) {
+		t.Fatal("session revoked within the grace window")
+	}
+
+	if w := refresh(t, rotated.RefreshToken); w.Code != http.StatusOK {
+		t.Errorf("rotated token: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	user := createTestUser(t, "refresh-reuse@example.com")
+	tokens := createTestSession(t, user)
+
+	w := refresh(t, tokens.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d: %s", w.Code, w.Body.String())
+	}
+	var rotated models.RefreshRequest
+	json.Unmarshal(w.Body.Bytes(), &rotated)
+
+	// Used a minute ago, so presenting it again is not a concurrent refresh
+	database.DB.Model(&models.RefreshToken{}).Where("token_hash = ?", middleware.HashOpaqueToken(tokens.RefreshToken)).
+		Update("used_at", time.Now().Add(-time.Minute))
+
+	if w := refresh(t, tokens.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused token: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if sessionExists(tokens.SessionID) {
+		t.Error("session kept after its refresh token was reused")
+	}
+	if w := refresh(t, rotated.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("latest token of the revoked session: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLogoutWithoutCookies(t *testing.T) {
+	user := createTestUser(t, "logout-api@example.com")
+
+	r := gin.New()
+	r.POST("/logout", Logout)
+	logout := func(header, body string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("logout: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	bearer := createTestSession(t, user)
+	other := createTestSession(t, user)
+	logout("Bearer "+bearer.AccessToken, "")
+	if sessionExists(bearer.SessionID) {
+		t.Error("session still active after logging out with its access token")
+	}
+	if !sessionExists(other.SessionID) {
+		t.Error("logging out ended another session")
+	}
+	if w := refresh(t, bearer.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: status %d", w.Code)
+	}
+
+	logout("", `{"refresh_token": "`+other.RefreshToken+`"}`)
+	if sessionExists(other.SessionID) {
+		t.Error("session still active after logging out with its refresh token")
+	}
+}
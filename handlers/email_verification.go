@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/mailer"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+const (
+	emailVerificationTokenPrefix = "evt_"
+	emailVerificationTokenTTL    = 24 * time.Hour
+)
+
+// GetRegistrationSettings returns how new accounts can be created, for the signup page
+func GetRegistrationSettings(c *gin.Context) {
+	allowedDomains := config.AllowedEmailDomains
+	if allowedDomains == nil {
+		allowedDomains = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mode":            config.RegistrationMode,
+		"allowed_domains": allowedDomains,
+		"ldap_enabled":    config.LDAPEnabled, // Login accepts directory usernames besides emails
+	})
+}
+
+// VerifyEmail confirms a user's email address with the token from the verification email
+func VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var verificationToken models.EmailVerificationToken
+	err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(req.Token)).First(&verificationToken).Error
+	if err != nil || !verificationToken.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Model(&models.User{}).Where("id = ? AND email_verified_at IS NULL", verificationToken.UserID).
+		Update("email_verified_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	if err := tx.Where("user_id = ?", verificationToken.UserID).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	var user models.User
+	if err := tx.First(&user, verificationToken.UserID).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if err := database.PromoteAdminEmail(tx, &user); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail sends a new verification link to the current user
+func ResendVerificationEmail(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.IsEmailVerified() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(c, &user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// sendVerificationEmail emails a new verification link to the user, invalidating earlier ones
+func sendVerificationEmail(c *gin.Context, user *models.User) error {
+	token, err := middleware.GenerateOpaqueToken(emailVerificationTokenPrefix)
+	if err != nil {
+		return err
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	verificationToken := models.EmailVerificationToken{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTokenTTL),
+	}
+	if err := tx.Create(&verificationToken).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/sync/verify-email?token=%s", appBaseURL(c), url.QueryEscape(token))
+	return mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email for ChartDB Sync",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm your email address by opening this link within %d hours:\n\n%s\n\n"+
+			"If you didn't create a ChartDB Sync account, you can ignore this email.\n",
+			user.Name, int(emailVerificationTokenTTL.Hours()), link),
+	})
+}
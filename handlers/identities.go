@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	identityLinkTokenPrefix = "lnk_"
+	identityLinkTTL         = 10 * time.Minute
+	maxIdentityLinkAttempts = 5
+)
+
+// ListIdentities returns the OIDC identities linked to the current user
+func ListIdentities(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var identities []models.UserIdentity
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch identities"})
+		return
+	}
+
+	response := make([]models.UserIdentityResponse, 0, len(identities))
+	for _, identity := range identities {
+		response = append(response, models.UserIdentityResponse{
+			ID:          identity.ID,
+			Provider:    identity.Provider,
+			Label:       identityLabel(identity.Provider),
+			Issuer:      identity.Issuer,
+			Email:       identity.Email,
+			LastLoginAt: formatOptionalTime(identity.LastLoginAt),
+			CreatedAt:   identity.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UnlinkIdentity removes an OIDC or LDAP identity from the current user
+// Accounts without a password of their own must keep at least one way to sign in
+func UnlinkIdentity(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	identityID := c.Param("identityId")
+
+	var identity models.UserIdentity
+	if err := database.DB.Where("id = ? AND user_id = ?", identityID, userID).First(&identity).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.AuthProvider == "oidc" {
+		var otherIdentities, passkeys int64
+		database.DB.Model(&models.UserIdentity{}).Where("user_id = ? AND id <> ?", userID, identity.ID).Count(&otherIdentities)
+		database.DB.Model(&models.Passkey{}).Where("user_id = ?", userID).Count(&passkeys)
+		if otherIdentities+passkeys == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This is the only way to sign in to your account"})
+			return
+		}
+	}
+
+	if err := database.DB.Delete(&identity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	middleware.Audit(c, models.AuditEvent{
+		Action:  models.AuditIdentityUnlinked,
+		Details: identityAuditDetails(&identity),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
+
+// GetIdentityLink describes a pending identity link for the confirmation page (public)
+func GetIdentityLink(c *gin.Context) {
+	link := findIdentityLink(c.Param("token"))
+	if link == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link expired, please sign in again"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, link.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link expired, please sign in again"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.IdentityLinkResponse{
+		Email:             user.Email,
+		Provider:          identityLabel(link.Provider),
+		TwoFactorRequired: user.TwoFactorEnabled(),
+	})
+}
+
+// LinkIdentity links a pending OIDC identity after the account owner confirmed it with their
+// password (and 2FA code), then signs them in
+func LinkIdentity(c *gin.Context) {
+	var req models.LinkIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	link := findIdentityLink(req.Token)
+	if link == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Link expired, please sign in again"})
+		return
+	}
+
+	// Count the attempt first, so concurrent guesses can't exceed the limit
+	result := database.DB.Model(link).
+		Where("attempts < ?", maxIdentityLinkAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		database.DB.Delete(link)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many attempts, please sign in again"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, link.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Link expired, please sign in again"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, &user, user.Email, "identity_link", "wrong_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect password"})
+		return
+	}
+
+	if user.TwoFactorEnabled() && !verifySecondFactor(&user, req.Code, true) {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, &user, user.Email, "identity_link", "wrong_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	now := time.Now()
+	identity := models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    link.Provider,
+		Issuer:      link.Issuer,
+		Subject:     link.Subject,
+		Email:       link.Email,
+		LastLoginAt: &now,
+	}
+
+	tx := database.DB.Begin()
+
+	// The link is single-use; losing a race for it means another request already linked it
+	if result := tx.Delete(link); result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Link expired, please sign in again"})
+		return
+	}
+
+	if err := tx.Create(&identity).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "This identity is already linked to an account"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	details := identityAuditDetails(&identity)
+	details["confirmed_with"] = "password"
+	middleware.AuditUser(c, models.AuditIdentityLinked, &user, details)
+
+	startSession(c, http.StatusOK, &user, "oidc")
+}
+
+// startIdentityLink stores an identity whose email matches an existing account and sends the
+// browser to the page where the account owner confirms the link
+func startIdentityLink(c *gin.Context, provider *config.OIDCProvider, user *models.User, identity *models.UserIdentity) {
+	token, err := middleware.GenerateOpaqueToken(identityLinkTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Only the latest login with an identity can be confirmed
+	database.DB.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).Delete(&models.IdentityLinkRequest{})
+
+	link := models.IdentityLinkRequest{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		Provider:  provider.Name,
+		Issuer:    identity.Issuer,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		ExpiresAt: time.Now().Add(identityLinkTTL),
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start account linking"})
+		return
+	}
+
+	c.Redirect(http.StatusTemporaryRedirect, "/sync/link-account?token="+url.QueryEscape(token))
+}
+
+// identityAuditDetails describes a linked identity in audit events
+func identityAuditDetails(identity *models.UserIdentity) map[string]interface{} {
+	return map[string]interface{}{
+		"provider": identity.Provider,
+		"issuer":   identity.Issuer,
+		"subject":  identity.Subject,
+		"email":    identity.Email,
+	}
+}
+
+// findIdentityLink returns the unexpired link request for a token, or nil
+func findIdentityLink(token string) *models.IdentityLinkRequest {
+	if token == "" {
+		return nil
+	}
+
+	var link models.IdentityLinkRequest
+	if err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(token)).First(&link).Error; err != nil {
+		return nil
+	}
+	if !link.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return &link
+}
+
+// identityLabel returns the display name of the provider of an identity
+func identityLabel(provider string) string {
+	if provider == ldapProviderName {
+		return "LDAP"
+	}
+	if oidcProvider := config.GetOIDCProvider(provider); oidcProvider != nil {
+		return oidcProvider.Label
+	}
+	return provider
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+	"github.com/thorved/chartdb-backend/totp"
+)
+
+// linkToken returns the token of a redirect to the identity link confirmation page
+func linkToken(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusTemporaryRedirect || err != nil || location.Path != "/sync/link-account" {
+		t.Fatalf("OIDC login wasn't sent to confirm the link: status %d, location %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	return location.Query().Get("token")
+}
+
+func identityCount(userID uint) int64 {
+	var count int64
+	database.DB.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	return count
+}
+
+func linkIdentity(token, password, code string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/identities/link", LinkIdentity)
+	return serveJSON(r, http.MethodPost, "/identities/link", fmt.Sprintf(`{"token": %q, "password": %q, "code": %q}`, token, password, code))
+}
+
+func TestOIDCLinksOnlyVerifiedEmails(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	user := createTestUser(t, "oidc-link-verified@example.com")
+
+	for _, emailVerified := range []interface{}{nil, false, "false"} {
+		claims := map[string]interface{}{"sub": "unverified", "email": user.Email}
+		if emailVerified != nil {
+			claims["email_verified"] = emailVerified
+		}
+		linkToken(t, oidcLogin(t, provider, claims))
+		if identityCount(user.ID) != 0 {
+			t.Fatalf("identity with email_verified %v linked without confirmation", emailVerified)
+		}
+	}
+
+	// Some providers send the flag as a string
+	w := oidcLogin(t, provider, map[string]interface{}{"sub": "verified", "email": user.Email, "email_verified": "true"})
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/sync/sync" {
+		t.Fatalf("login with a verified email: status %d, location %q: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	if identityCount(user.ID) != 1 {
+		t.Error("identity with a verified email was not linked")
+	}
+}
+
+func TestOIDCLinkConfirmation(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	user := createTestUser(t, "oidc-link-confirm@example.com")
+	setTestPassword(t, user, "link-password")
+	secret, _ := enableTestTwoFactor(t, user)
+	claims := map[string]interface{}{"sub": "confirm", "email": user.Email}
+
+	token := linkToken(t, oidcLogin(t, provider, claims))
+	r := gin.New()
+	r.GET("/identities/link/:token", GetIdentityLink)
+	if w := serveJSON(r, http.MethodGet, "/identities/link/"+token, ""); !strings.Contains(w.Body.String(), `"two_factor_required":true`) {
+		t.Errorf("link description: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := linkIdentity(token, "wrong-password", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := linkIdentity(token, "link-password", ""); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid code") {
+		t.Errorf("password without the 2FA code: status %d: %s", w.Code, w.Body.String())
+	}
+	if identityCount(user.ID) != 0 {
+		t.Fatal("identity linked before the link was confirmed")
+	}
+
+	code := totpCode(t, secret, time.Now().Add(totp.Period*time.Second))
+	if linked := loginUser(t, linkIdentity(token, "link-password", code)); linked.ID != user.ID {
+		t.Errorf("link confirmation signed in user %d, want %d", linked.ID, user.ID)
+	}
+	if identityCount(user.ID) != 1 {
+		t.Error("identity not linked after confirmation")
+	}
+	if w := linkIdentity(token, "link-password", code); w.Code != http.StatusUnauthorized {
+		t.Errorf("used link: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// The identity now signs in directly
+	if w := oidcLogin(t, provider, claims); w.Header().Get("Location") != "/sync/sync" {
+		t.Errorf("login with the linked identity: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestOIDCLinkAttemptLimit(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	user := createTestUser(t, "oidc-link-attempts@example.com")
+	setTestPassword(t, user, "link-password")
+
+	token := linkToken(t, oidcLogin(t, provider, map[string]interface{}{"sub": "attempts", "email": user.Email}))
+	for i := 0; i < maxIdentityLinkAttempts; i++ {
+		if w := linkIdentity(token, "wrong-password", ""); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Incorrect password") {
+			t.Fatalf("wrong password %d: status %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+
+	w := linkIdentity(token, "link-password", "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Too many attempts") {
+		t.Errorf("right password after the attempt limit: status %d: %s", w.Code, w.Body.String())
+	}
+	if identityCount(user.ID) != 0 {
+		t.Error("identity linked after the attempt limit")
+	}
+}
+
+func TestUnlinkLastSignInMethod(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	email := "oidc-unlink@example.com"
+
+	// The first login creates an account without a password of its own, the second links another identity
+	for _, subject := range []string{"unlink-first", "unlink-second"} {
+		w := oidcLogin(t, provider, map[string]interface{}{"sub": subject, "email": email, "email_verified": true})
+		if w.Header().Get("Location") != "/sync/sync" {
+			t.Fatalf("login as %s: status %d: %s", subject, w.Code, w.Body.String())
+		}
+	}
+	var user models.User
+	if err := database.DB.Where("email = ?", email).First(&user).Error; err != nil || user.AuthProvider != "oidc" {
+		t.Fatalf("OIDC login didn't create an OIDC account: %v", err)
+	}
+	var identities []models.UserIdentity
+	database.DB.Where("user_id = ?", user.ID).Order("id").Find(&identities)
+	if len(identities) != 2 {
+		t.Fatalf("%d identities, want 2", len(identities))
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.DELETE("/identities/:identityId", UnlinkIdentity)
+	unlink := func(identity models.UserIdentity) *httptest.ResponseRecorder {
+		return serveJSON(r, http.MethodDelete, fmt.Sprintf("/identities/%d", identity.ID), "")
+	}
+
+	if w := unlink(identities[0]); w.Code != http.StatusOK {
+		t.Fatalf("unlink one of two identities: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := unlink(identities[1]); w.Code != http.StatusBadRequest {
+		t.Errorf("unlink the last identity: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// A passkey is another way to sign in
+	database.DB.Create(&models.Passkey{UserID: user.ID, CredentialID: "unlink-passkey", Credential: "{}", Name: "Laptop"})
+	if w := unlink(identities[1]); w.Code != http.StatusOK {
+		t.Errorf("unlink the last identity with a passkey: status %d: %s", w.Code, w.Body.String())
+	}
+}
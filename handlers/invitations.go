@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/mailer"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	invitationTokenPrefix = "inv_"
+	invitationTTL         = 7 * 24 * time.Hour
+)
+
+// CreateInvitation invites someone to create an account and emails them the signup link
+// The link is also returned, so it can be shared another way if the email doesn't arrive
+// Only users with a verified email (and administrators) can invite, and only addresses in ALLOWED_EMAIL_DOMAINS
+// Inviting into a workspace takes an administrator, or an admin of that workspace
+func CreateInvitation(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+
+	if !config.EmailDomainAllowed(email) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is not allowed for this email domain"})
+		return
+	}
+
+	var existingUser models.User
+	if err := database.DB.Where("email = ?", email).First(&existingUser).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "User with this email already exists"})
+		return
+	}
+
+	var inviter models.User
+	if err := database.DB.First(&inviter, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Invitations are sent in the inviter's name, so unverified accounts can't use the server to send mail
+	if !inviter.IsEmailVerified() && !middleware.IsAdmin(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before inviting others"})
+		return
+	}
+
+	workspaceRole := ""
+	if req.WorkspaceID != nil {
+		var workspace models.Workspace
+		if err := database.DB.First(&workspace, *req.WorkspaceID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+			return
+		}
+		if !middleware.IsAdmin(c) && !isWorkspaceAdmin(userID, workspace.ID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins of this workspace can invite into it"})
+			return
+		}
+		workspaceRole = req.WorkspaceRole
+		if workspaceRole == "" {
+			workspaceRole = models.WorkspaceRoleMember
+		}
+	}
+
+	token, err := middleware.GenerateOpaqueToken(invitationTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+	tx := database.DB.Begin()
+
+	// Inviting the same email again replaces the earlier invitations
+	if err := tx.Model(&models.Invitation{}).
+		Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL AND revoked_at IS NULL", email).
+		Update("revoked_at", now).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	invitation := models.Invitation{
+		Email:         email,
+		InvitedByID:   userID,
+		WorkspaceID:   req.WorkspaceID,
+		WorkspaceRole: workspaceRole,
+		TokenHash:     middleware.HashOpaqueToken(token),
+		ExpiresAt:     now.Add(invitationTTL),
+	}
+	if err := tx.Create(&invitation).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invitation"})
+		return
+	}
+
+	link := fmt.Sprintf("%s/sync/signup?invite=%s", appBaseURL(c), url.QueryEscape(token))
+	err = mailer.Send(mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("%s invited you to ChartDB Sync", inviter.Name),
+		Body: fmt.Sprintf("Hi,\n\n"+
+			"%s (%s) invited you to ChartDB Sync.\n"+
+			"Open this link within %d days to create your account:\n\n%s\n",
+			inviter.Name, inviter.Email, int(invitationTTL.Hours()/24), link),
+	})
+	if err != nil {
+		log.Printf("Failed to send invitation email for invitation %d: %v", invitation.ID, err)
+	}
+
+	response := toInvitationResponse(invitation)
+	response.URL = link
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListInvitations returns the invitations sent by the current user, or all invitations for administrators
+func ListInvitations(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	query := database.DB.Order("created_at desc")
+	if !middleware.IsAdmin(c) {
+		query = query.Where("invited_by_id = ?", userID)
+	}
+
+	var invitations []models.Invitation
+	if err := query.Find(&invitations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invitations"})
+		return
+	}
+
+	response := make([]models.InvitationResponse, len(invitations))
+	for i, inv := range invitations {
+		response[i] = toInvitationResponse(inv)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeInvitation revokes a pending invitation (inviter or admin)
+func RevokeInvitation(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	invitationID := c.Param("invitationId")
+
+	query := database.DB.Where("id = ?", invitationID)
+	if !middleware.IsAdmin(c) {
+		query = query.Where("invited_by_id = ?", userID)
+	}
+
+	var invitation models.Invitation
+	if err := query.First(&invitation).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if invitation.AcceptedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invitation has already been accepted"})
+		return
+	}
+
+	if invitation.RevokedAt == nil {
+		now := time.Now()
+		invitation.RevokedAt = &now
+		if err := database.DB.Model(&invitation).Update("revoked_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked successfully"})
+}
+
+// GetInvitation returns the email of a pending invitation, for the signup page (public)
+func GetInvitation(c *gin.Context) {
+	invitation := findPendingInvitation(c.Param("token"))
+	if invitation == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid or expired invitation"})
+		return
+	}
+
+	var inviter models.User
+	database.DB.First(&inviter, invitation.InvitedByID)
+
+	response := gin.H{
+		"email":      invitation.Email,
+		"invited_by": inviter.Name,
+		"expires_at": invitation.ExpiresAt.Format(time.RFC3339),
+	}
+	if invitation.WorkspaceID != nil {
+		var workspace models.Workspace
+		if err := database.DB.First(&workspace, *invitation.WorkspaceID).Error; err == nil {
+			response["workspace"] = workspace.Name
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// findPendingInvitation looks up an invitation by its token, nil if it can't be accepted
+func findPendingInvitation(token string) *models.Invitation {
+	if !strings.HasPrefix(token, invitationTokenPrefix) {
+		return nil
+	}
+
+	var invitation models.Invitation
+	if err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(token)).First(&invitation).Error; err != nil {
+		return nil
+	}
+	if !invitation.IsPending() {
+		return nil
+	}
+	return &invitation
+}
+
+// findPendingInvitationForEmail returns the newest pending invitation for an email address, nil if there is none
+func findPendingInvitationForEmail(email string) *models.Invitation {
+	var invitation models.Invitation
+	err := database.DB.Where("LOWER(email) = LOWER(?) AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", email, time.Now()).
+		Order("created_at desc").First(&invitation).Error
+	if err != nil {
+		return nil
+	}
+	return &invitation
+}
+
+// acceptInvitation marks an invitation as used by a new account and adds it to the invitation's workspace
+// Fails if the invitation was accepted or revoked concurrently
+func acceptInvitation(tx *gorm.DB, invitation *models.Invitation, userID uint) error {
+	result := tx.Model(invitation).
+		Where("accepted_at IS NULL AND revoked_at IS NULL").
+		Updates(map[string]interface{}{
+			"accepted_at":      time.Now(),
+			"accepted_user_id": userID,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("invitation %d is no longer pending", invitation.ID)
+	}
+
+	// Deleting a workspace revokes its invitations, so it still exists unless that raced with the signup
+	if invitation.WorkspaceID == nil {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.Workspace{}).Where("id = ?", *invitation.WorkspaceID).Count(&count).Error; err != nil || count == 0 {
+		return err
+	}
+	return setWorkspaceMember(tx, *invitation.WorkspaceID, userID, invitation.WorkspaceRole, models.WorkspaceSourceManual)
+}
+
+func toInvitationResponse(inv models.Invitation) models.InvitationResponse {
+	status := "pending"
+	switch {
+	case inv.AcceptedAt != nil:
+		status = "accepted"
+	case inv.RevokedAt != nil:
+		status = "revoked"
+	case !inv.ExpiresAt.After(time.Now()):
+		status = "expired"
+	}
+
+	return models.InvitationResponse{
+		ID:            inv.ID,
+		Email:         inv.Email,
+		InvitedByID:   inv.InvitedByID,
+		WorkspaceID:   inv.WorkspaceID,
+		WorkspaceRole: inv.WorkspaceRole,
+		Status:        status,
+		ExpiresAt:     inv.ExpiresAt.Format(time.RFC3339),
+		AcceptedAt:    formatOptionalTime(inv.AcceptedAt),
+		CreatedAt:     inv.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/ldapauth"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ldapProviderName is the provider of directory users and their identities
+const ldapProviderName = "ldap"
+
+var (
+	errLDAPEmailTaken     = errors.New("email belongs to an account that isn't managed by LDAP")
+	errLDAPAccountDeleted = errors.New("email belongs to a deleted account")
+)
+
+// ldapLogin signs a user in with their directory password, creating their account on the first login
+func ldapLogin(c *gin.Context, username, password string) {
+	entry, err := ldapauth.Authenticate(config.LDAP, username, password)
+	if errors.Is(err, ldapauth.ErrInvalidCredentials) {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, nil, username, "ldap", "wrong_password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+	if err != nil {
+		log.Printf("LDAP login failed: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Directory service unavailable"})
+		return
+	}
+	if entry.Email == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your directory account has no email address"})
+		return
+	}
+
+	user, err := findOrCreateLDAPUser(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, errLDAPEmailTaken):
+			// Linking would hand the local account to whoever controls the directory entry
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+		case errors.Is(err, errLDAPAccountDeleted):
+			// It can still be restored with the link emailed when it was deleted
+			c.JSON(http.StatusForbidden, gin.H{"error": "The account with this email has been deleted"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusForbidden, gin.H{"error": "The account linked to this identity no longer exists"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		}
+		return
+	}
+
+	if err := database.PromoteAdminEmail(database.DB, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+		return
+	}
+
+	if len(config.LDAP.AdminGroups) > 0 {
+		if err := setAdminRole(c, user, entry.InGroup(config.LDAP.AdminGroups), "ldap"); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+			return
+		}
+	}
+
+	startSession(c, http.StatusOK, user, "ldap")
+}
+
+// findOrCreateLDAPUser returns the user of a directory entry, keyed by the LDAP URL and the entry's ID
+func findOrCreateLDAPUser(entry *ldapauth.Entry) (*models.User, error) {
+	now := time.Now()
+
+	var user models.User
+	var identity models.UserIdentity
+	if err := database.DB.Where("issuer = ? AND subject = ?", config.LDAP.URL, entry.ID).First(&identity).Error; err == nil {
+		if err := database.DB.First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		database.DB.Model(&identity).Updates(map[string]interface{}{
+			"email":         entry.Email,
+			"last_login_at": now,
+		})
+		// The directory owns the name
+		if entry.Name != "" && entry.Name != user.Name {
+			database.DB.Model(&user).Update("name", entry.Name)
+		}
+		return &user, nil
+	}
+
+	identity = models.UserIdentity{
+		Provider:    ldapProviderName,
+		Issuer:      config.LDAP.URL,
+		Subject:     entry.ID,
+		Email:       entry.Email,
+		LastLoginAt: &now,
+	}
+
+	// A directory user whose identity was unlinked gets it back; deleted accounts keep their
+	// email until they are purged
+	if err := database.DB.Unscoped().Where("email = ?", entry.Email).First(&user).Error; err == nil {
+		if user.DeletedAt.Valid {
+			return nil, errLDAPAccountDeleted
+		}
+		if user.AuthProvider != ldapProviderName {
+			return nil, errLDAPEmailTaken
+		}
+		identity.UserID = user.ID
+		if err := database.DB.Create(&identity).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+
+	// The password is checked by the directory; the stored one is random and never used
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(generateRandomPassword()), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user = models.User{
+		Email:        entry.Email,
+		Password:     string(hashedPassword),
+		Name:         entry.Name,
+		AuthProvider: ldapProviderName,
+		// The directory vouches for the email address
+		EmailVerifiedAt: &now,
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	identity.UserID = user.ID
+	if err := tx.Create(&identity).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
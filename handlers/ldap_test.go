@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+const (
+	ldapTestBaseDN          = "dc=example,dc=com"
+	ldapTestServiceDN       = "cn=service," + ldapTestBaseDN
+	ldapTestServicePassword = "service-secret"
+	ldapTestAdminGroupDN    = "cn=chartdb-admins,ou=groups," + ldapTestBaseDN
+)
+
+// ldapTestEntry is a user in the test directory
+type ldapTestEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// ldapTestServer is an in-process LDAP server that answers the simple binds and searches
+// ldapauth makes; filters support and, or, not, equality and presence
+type ldapTestServer struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries map[string]*ldapTestEntry // By DN
+}
+
+func newLDAPTestServer(t *testing.T) *ldapTestServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ldapTestServer{listener: listener, entries: make(map[string]*ldapTestEntry)}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *ldapTestServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapTestServer) addUser(uid, mail, cn, password string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries["uid="+uid+",ou=people,"+ldapTestBaseDN] = &ldapTestEntry{
+		password: password,
+		attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {uid},
+			"mail":        {mail},
+			"cn":          {cn},
+			"memberOf":    groups,
+		},
+	}
+}
+
+func (s *ldapTestServer) setGroups(uid string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries["uid="+uid+",ou=people,"+ldapTestBaseDN].attributes["memberOf"] = groups
+}
+
+func (s *ldapTestServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapTestServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = append(responses, s.bind(request))
+		case ldap.ApplicationSearchRequest:
+			responses = s.search(request)
+		default: // Unbind
+			return
+		}
+		for _, response := range responses {
+			envelope := ber.NewSequence("LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *ldapTestServer) bind(request *ber.Packet) *ber.Packet {
+	dn := request.Children[1].Value.(string)
+	password := request.Children[2].Data.String()
+
+	code := uint16(ldap.LDAPResultInvalidCredentials)
+	s.mu.Lock()
+	if entry, ok := s.entries[dn]; (ok && entry.password == password) || (dn == ldapTestServiceDN && password == ldapTestServicePassword) {
+		code = ldap.LDAPResultSuccess
+	}
+	s.mu.Unlock()
+	return ldapResult(ldap.ApplicationBindResponse, code)
+}
+
+func (s *ldapTestServer) search(request *ber.Packet) []*ber.Packet {
+	baseDN := request.Children[0].Value.(string)
+	filter := request.Children[6]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var responses []*ber.Packet
+	for dn, entry := range s.entries {
+		if !strings.HasSuffix(dn, baseDN) || !ldapFilterMatches(filter, entry.attributes) {
+			continue
+		}
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+		attributes := ber.NewSequence("Attributes")
+		for name, values := range entry.attributes {
+			attribute := ber.NewSequence("Attribute")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+			}
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+		result.AppendChild(attributes)
+		responses = append(responses, result)
+	}
+	return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func ldapResult(application ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func ldapFilterMatches(filter *ber.Packet, attributes map[string][]string) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !ldapFilterMatches(child, attributes) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if ldapFilterMatches(child, attributes) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !ldapFilterMatches(filter.Children[0], attributes)
+	case ldap.FilterEqualityMatch:
+		name, value := filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+		for attribute, values := range attributes {
+			if strings.EqualFold(attribute, name) {
+				for _, v := range values {
+					if strings.EqualFold(v, value) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		for attribute, values := range attributes {
+			if strings.EqualFold(attribute, filter.Data.String()) && len(values) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// enableTestLDAP points the LDAP configuration at the server until the test ends
+func enableTestLDAP(t *testing.T, server *ldapTestServer) {
+	t.Helper()
+
+	enabled, cfg := config.LDAPEnabled, config.LDAP
+	config.LDAPEnabled = true
+	config.LDAP = config.LDAPConfig{
+		URL:            server.URL(),
+		BindDN:         ldapTestServiceDN,
+		BindPassword:   ldapTestServicePassword,
+		BaseDN:         ldapTestBaseDN,
+		UserFilter:     "(&(objectClass=person)(|(uid={username})(mail={username})))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		GroupAttribute: "memberOf",
+		GroupBaseDN:    ldapTestBaseDN,
+		AdminGroups:    []string{"chartdb-admins"},
+	}
+	t.Cleanup(func() { config.LDAPEnabled, config.LDAP = enabled, cfg })
+}
+
+func login(t *testing.T, email, password string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := gin.New()
+	r.POST("/login", Login)
+
+	body, _ := json.Marshal(models.UserLoginRequest{Email: email, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func loginUser(t *testing.T, w *httptest.ResponseRecorder) models.UserResponse {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	var response models.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.User
+}
+
+func TestLDAPLogin(t *testing.T) {
+	server := newLDAPTestServer(t)
+	server.addUser("ada", "ada@ldap.example", "Ada Lovelace", "analytical-engine")
+	enableTestLDAP(t, server)
+
+	user := loginUser(t, login(t, "ada@ldap.example", "analytical-engine"))
+	if user.Email != "ada@ldap.example" || user.Name != "Ada Lovelace" {
+		t.Errorf("first login created %+v", user)
+	}
+
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	if stored.AuthProvider != ldapProviderName || !stored.IsEmailVerified() {
+		t.Errorf("directory user stored with provider %q, verified %v", stored.AuthProvider, stored.IsEmailVerified())
+	}
+
+	// The login name works as well, and finds the same account
+	if again := loginUser(t, login(t, "ada", "analytical-engine")); again.ID != user.ID {
+		t.Errorf("login by name signed in user %d, want %d", again.ID, user.ID)
+	}
+
+	if w := login(t, "ada", "wrong-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := login(t, "nobody", "analytical-engine"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown user: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLDAPAdminGroups(t *testing.T) {
+	server := newLDAPTestServer(t)
+	server.addUser("grace", "grace@ldap.example", "Grace Hopper", "cobol-rules", ldapTestAdminGroupDN)
+	enableTestLDAP(t, server)
+
+	isAdmin := func() bool {
+		t.Helper()
+		var user models.User
+		database.DB.First(&user, loginUser(t, login(t, "grace", "cobol-rules")).ID)
+		return user.IsAdmin
+	}
+
+	if !isAdmin() {
+		t.Error("member of the admin group is not an administrator")
+	}
+	server.setGroups("grace")
+	if isAdmin() {
+		t.Error("administrator role kept after leaving the admin group")
+	}
+}
+
+func TestLDAPLoginEmailHeldByAnotherAccount(t *testing.T) {
+	server := newLDAPTestServer(t)
+	server.addUser("local", "ldap-local@example.com", "Local", "directory-password")
+	server.addUser("deleted", "ldap-deleted@example.com", "Deleted", "directory-password")
+	enableTestLDAP(t, server)
+
+	createTestUser(t, "ldap-local@example.com")
+	if w := login(t, "local", "directory-password"); w.Code != http.StatusConflict {
+		t.Errorf("email of a local account: status %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+	}
+
+	deleted := createTestUser(t, "ldap-deleted@example.com")
+	if err := database.DB.Delete(deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if w := login(t, "deleted", "directory-password"); w.Code != http.StatusForbidden {
+		t.Errorf("email of a deleted account: status %d, want %d: %s", w.Code, http.StatusForbidden, w.Body.String())
+	}
+}
+
+func TestLoginRequiresEmailWithoutLDAP(t *testing.T) {
+	if w := login(t, "not-an-email", "some-password"); w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm/logger"
+)
+
+// testOrigin is the origin the app is served from in tests
+const testOrigin = "https://chartdb.example"
+
+// TestMain runs the handler tests against a fresh database in a temporary directory
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := os.MkdirTemp("", "chartdb-handlers")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("DATABASE_PATH", filepath.Join(dir, "test.db"))
+	os.Setenv("WEBAUTHN_ORIGINS", testOrigin)
+
+	database.InitDB()
+	database.DB.Logger = logger.Default.LogMode(logger.Silent)
+	if err := middleware.InitSigningKeys(); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.InitWebAuthn(); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// createTestUser creates a verified local user
+func createTestUser(t *testing.T, email string) *models.User {
+	t.Helper()
+
+	user := models.User{Email: email, Name: email}
+	if err := database.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := database.DB.Model(&user).Update("email_verified_at", user.CreatedAt).Error; err != nil {
+		t.Fatalf("verify user: %v", err)
+	}
+	return &user
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gin-gonic/gin"
 	"github.com/thorved/chartdb-backend/config"
 	"github.com/thorved/chartdb-backend/database"
@@ -18,34 +22,58 @@ import (
 )
 
 const (
-	cookieName   = "auth_token"
-	stateCookie  = "oidc_state"
-	cookieMaxAge = 7 * 24 * 60 * 60 // 7 days
+	stateCookie = "oidc_state"
 )
 
-// OIDCLogin initiates the OIDC authentication flow
+// OIDCLogin initiates the OIDC authentication flow with the provider named in the URL
+// The provider-less /oidc/login route uses the default provider, or else the first one
 func OIDCLogin(c *gin.Context) {
 	if !config.OIDCEnabled {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OIDC is not enabled"})
 		return
 	}
 
-	// Generate state parameter
+	provider := config.GetOIDCProvider(config.DefaultOIDCProviderName)
+	if provider == nil {
+		provider = config.OIDCProviders[0]
+	}
+	if name := c.Param("provider"); name != "" {
+		provider = config.GetOIDCProvider(name)
+		if provider == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown OIDC provider"})
+			return
+		}
+	}
+
+	// Generate state and nonce parameters
 	state, err := config.GenerateState()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
 		return
 	}
+	nonce, err := config.GenerateState()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate nonce"})
+		return
+	}
 
-	// Store state in cookie
-	c.SetCookie(stateCookie, state, 600, "/", "", false, true)
+	// PKCE binds the authorization code to this browser, and lets public clients work without a secret
+	verifier := oauth2.GenerateVerifier()
+
+	// Store state, nonce and PKCE verifier in cookie, together with the provider the callback belongs to
+	c.SetCookie(stateCookie, strings.Join([]string{provider.Name, state, nonce, verifier}, ":"), 600, "/", "", false, true)
 
 	// Redirect to OIDC provider
-	authURL := config.OAuth2Config.AuthCodeURL(state, oauth2.AccessTypeOnline)
+	authURL := oidcOAuth2Config(c, provider).AuthCodeURL(state,
+		oauth2.AccessTypeOnline,
+		oauth2.S256ChallengeOption(verifier),
+		oidc.Nonce(nonce),
+	)
 	c.Redirect(http.StatusTemporaryRedirect, authURL)
 }
 
 // OIDCCallback handles the OIDC callback
+// The provider-less /oidc/callback route takes the provider from the state cookie
 func OIDCCallback(c *gin.Context) {
 	if !config.OIDCEnabled {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OIDC is not enabled"})
@@ -53,19 +81,31 @@ func OIDCCallback(c *gin.Context) {
 	}
 
 	// Get state from cookie
-	expectedState, err := c.Cookie(stateCookie)
+	stateValue, err := c.Cookie(stateCookie)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "State cookie not found"})
 		return
 	}
+	parts := strings.Split(stateValue, ":")
+	if len(parts) != 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
+		return
+	}
+	providerName, expectedState, nonce, verifier := parts[0], parts[1], parts[2], parts[3]
 
 	// Verify state parameter
 	state := c.Query("state")
-	if state != expectedState {
+	if state != expectedState || (c.Param("provider") != "" && c.Param("provider") != providerName) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
 		return
 	}
 
+	provider := config.GetOIDCProvider(providerName)
+	if provider == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown OIDC provider"})
+		return
+	}
+
 	// Clear state cookie
 	c.SetCookie(stateCookie, "", -1, "/", "", false, true)
 
@@ -80,7 +120,7 @@ func OIDCCallback(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	oauth2Token, err := config.OAuth2Config.Exchange(ctx, code)
+	oauth2Token, err := oidcOAuth2Config(c, provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to exchange code: " + err.Error()})
 		return
@@ -94,40 +134,102 @@ func OIDCCallback(c *gin.Context) {
 	}
 
 	// Verify ID token
-	idToken, err := config.OIDCVerifier.Verify(ctx, rawIDToken)
+	idToken, err := provider.Verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ID token: " + err.Error()})
 		return
 	}
 
+	// The ID token must have been issued for this login, not replayed from another one
+	if idToken.Nonce != nonce {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID token nonce"})
+		return
+	}
+
 	// Extract claims
 	var claims struct {
-		Subject string `json:"sub"`
-		Email   string `json:"email"`
-		Name    string `json:"name"`
+		Subject       string      `json:"sub"`
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"` // Some providers send "true" as a string
+		Name          string      `json:"name"`
 	}
 	if err := idToken.Claims(&claims); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse claims: " + err.Error()})
 		return
 	}
 
-	// Find or create user
+	// Groups are read before any account is created, so a failure doesn't leave a half set up user
+	var groups []string
+	if provider.MapsGroups() {
+		groups, err = oidcGroups(ctx, provider, idToken, oauth2Token)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read groups: " + err.Error()})
+			return
+		}
+	}
+
+	// Unless the provider vouches for the address, anyone able to set it there could claim it
+	emailVerified := claims.EmailVerified == true || claims.EmailVerified == "true"
+
+	// Find the user by their identity at this issuer, or link or create one
+	now := time.Now()
 	var user models.User
-	result := database.DB.Where("oidc_subject = ?", claims.Subject).First(&user)
+	var identity models.UserIdentity
+	if err := database.DB.Where("issuer = ? AND subject = ?", idToken.Issuer, claims.Subject).First(&identity).Error; err == nil {
+		if err := database.DB.First(&user, identity.UserID).Error; err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The account linked to this identity no longer exists"})
+			return
+		}
+		database.DB.Model(&identity).Updates(map[string]interface{}{
+			"provider":      provider.Name,
+			"email":         claims.Email,
+			"last_login_at": now,
+		})
+	} else {
+		identity = models.UserIdentity{
+			Provider:    provider.Name,
+			Issuer:      idToken.Issuer,
+			Subject:     claims.Subject,
+			Email:       claims.Email,
+			LastLoginAt: &now,
+		}
 
-	if result.Error != nil {
-		// User doesn't exist, create new user
 		// Check if email already exists
 		var existingUser models.User
 		if err := database.DB.Where("email = ?", claims.Email).First(&existingUser).Error; err == nil {
-			// Email exists, link OIDC to existing account
-			existingUser.OIDCSubject = claims.Subject
-			existingUser.OIDCIssuer = config.OIDCIssuerURL
-			existingUser.AuthProvider = "oidc"
-			database.DB.Save(&existingUser)
+			// Email exists. Unless the provider vouches for the address, anyone able to set it
+			// there could take over the account, so the owner has to confirm with their password
+			if !emailVerified {
+				startIdentityLink(c, provider, &existingUser, &identity)
+				return
+			}
+
+			identity.UserID = existingUser.ID
+			if err := database.DB.Create(&identity).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+				return
+			}
 			user = existingUser
+
+			details := identityAuditDetails(&identity)
+			details["confirmed_with"] = "verified_email"
+			middleware.AuditUser(c, models.AuditIdentityLinked, &user, details)
 		} else {
-			// Create new user
+			// Create new user, unless registration is limited to invitations
+			// A pending invitation for the email lets the user in regardless, if the provider verified the address
+			var invitation *models.Invitation
+			if emailVerified {
+				invitation = findPendingInvitationForEmail(claims.Email)
+			}
+			if invitation == nil && config.RegistrationMode == config.RegistrationInviteOnly {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Registration is by invitation only"})
+				return
+			}
+			if !config.EmailDomainAllowed(claims.Email) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Registration is not allowed for this email domain"})
+				return
+			}
+
 			randomPassword := generateRandomPassword()
 			hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
 
@@ -135,35 +237,208 @@ func OIDCCallback(c *gin.Context) {
 				Email:        claims.Email,
 				Password:     string(hashedPassword),
 				Name:         claims.Name,
-				OIDCSubject:  claims.Subject,
-				OIDCIssuer:   config.OIDCIssuerURL,
 				AuthProvider: "oidc",
 			}
-			database.DB.Create(&user)
+			if emailVerified {
+				user.EmailVerifiedAt = &now
+			}
+
+			tx := database.DB.Begin()
+
+			if err := tx.Create(&user).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+				return
+			}
+
+			identity.UserID = user.ID
+			if err := tx.Create(&identity).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+				return
+			}
+
+			if err := tx.Commit().Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+				return
+			}
+
+			if invitation != nil {
+				if err := acceptInvitation(database.DB, invitation, user.ID); err != nil {
+					log.Printf("Failed to accept invitation %d: %v", invitation.ID, err)
+				}
+			}
 		}
 	}
 
-	// Generate JWT token
-	token, err := middleware.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+	// The identity provider vouches for the email address of the account
+	if emailVerified && user.EmailVerifiedAt == nil && strings.EqualFold(user.Email, claims.Email) {
+		if err := database.DB.Model(&user).Update("email_verified_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+	}
+	if err := database.PromoteAdminEmail(database.DB, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
 		return
 	}
 
-	// Store token for single-session enforcement
-	user.CurrentToken = token
-	if err := database.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session"})
+	if provider.MapsGroups() {
+		if err := applyOIDCGroups(c, provider, &user, groups); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+			return
+		}
+	}
+
+	// Start a new session (other sessions of the user stay signed in)
+	tokens, err := middleware.CreateSession(c, &user)
+	if err == middleware.ErrAccountDeactivated {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account has been deactivated"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
 
-	// Set auth cookie
-	SetAuthCookie(c, token)
+	middleware.AuditUser(c, models.AuditLoginSucceeded, &user, map[string]interface{}{"method": "oidc", "provider": provider.Name})
+
+	// Remember the provider and ID token, to end the provider session on logout
+	database.DB.Model(&models.Session{}).Where("id = ?", tokens.SessionID).Updates(map[string]interface{}{
+		"oidc_provider": provider.Name,
+		"id_token":      rawIDToken,
+	})
+
+	// Set auth cookies
+	middleware.SetSessionCookies(c, tokens)
 
 	// Redirect to sync page to pull cloud data
 	c.Redirect(http.StatusTemporaryRedirect, "/sync/sync")
 }
 
+// oidcGroups returns the groups in the provider's groups claim
+// The claim is looked up in the ID token, then in the userinfo response, as some providers
+// only include groups there
+func oidcGroups(ctx context.Context, provider *config.OIDCProvider, idToken *oidc.IDToken, oauth2Token *oauth2.Token) ([]string, error) {
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	if value, ok := lookupClaim(claims, provider.GroupsClaim); ok {
+		return claimStrings(value), nil
+	}
+	if provider.Provider.UserInfoEndpoint() == "" {
+		return nil, nil
+	}
+
+	userInfo, err := provider.Provider.UserInfo(ctx, oauth2.StaticTokenSource(oauth2Token))
+	if err != nil {
+		return nil, err
+	}
+	claims = nil
+	if err := userInfo.Claims(&claims); err != nil {
+		return nil, err
+	}
+	value, _ := lookupClaim(claims, provider.GroupsClaim)
+	return claimStrings(value), nil
+}
+
+// lookupClaim finds a claim by its dotted path, e.g. "realm_access.roles"
+func lookupClaim(claims map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// claimStrings converts a claim holding a list of strings or a single string
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// applyOIDCGroups grants or revokes the administrator role and workspace memberships according to the user's groups
+func applyOIDCGroups(c *gin.Context, provider *config.OIDCProvider, user *models.User, groups []string) error {
+	source := "oidc:" + provider.Name
+
+	var workspaceNames []string
+	for _, group := range groups {
+		if name, ok := provider.WorkspaceGroups[group]; ok {
+			workspaceNames = append(workspaceNames, name)
+		}
+	}
+	if err := syncDirectoryWorkspaces(user.ID, source, workspaceNames); err != nil {
+		return err
+	}
+
+	if len(provider.AdminGroups) == 0 {
+		return nil
+	}
+	isAdmin := false
+	for _, group := range groups {
+		if slices.Contains(provider.AdminGroups, group) {
+			isAdmin = true
+			break
+		}
+	}
+
+	return setAdminRole(c, user, isAdmin, source)
+}
+
+// oidcLogoutURL returns the provider's end_session_endpoint URL that ends the provider session of
+// a user signed in with the given ID token, or "" when the provider doesn't support RP-initiated logout
+func oidcLogoutURL(c *gin.Context, provider *config.OIDCProvider, idToken string) string {
+	if provider.EndSessionURL == "" {
+		return ""
+	}
+
+	logoutURL, err := url.Parse(provider.EndSessionURL)
+	if err != nil {
+		return ""
+	}
+
+	redirectURL := provider.PostLogoutRedirectURL
+	if redirectURL == "" {
+		redirectURL = appBaseURL(c) + "/sync/login"
+	}
+
+	query := logoutURL.Query()
+	query.Set("client_id", provider.ClientID)
+	query.Set("post_logout_redirect_uri", redirectURL)
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	logoutURL.RawQuery = query.Encode()
+	return logoutURL.String()
+}
+
+// oidcOAuth2Config returns the OAuth2 config of a provider, defaulting the redirect URL to its callback route
+func oidcOAuth2Config(c *gin.Context, provider *config.OIDCProvider) *oauth2.Config {
+	oauthConfig := *provider.OAuth2Config
+	if oauthConfig.RedirectURL == "" {
+		oauthConfig.RedirectURL = appBaseURL(c) + "/sync/api/auth/oidc/" + provider.Name + "/callback"
+	}
+	return &oauthConfig
+}
+
 // generateRandomPassword generates a random password for OIDC users
 func generateRandomPassword() string {
 	b := make([]byte, 32)
@@ -171,19 +446,16 @@ func generateRandomPassword() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-// SetAuthCookie sets the authentication cookie
-func SetAuthCookie(c *gin.Context, token string) {
-	// In production, set Secure: true and SameSite: http.SameSiteStrictMode
-	secure := os.Getenv("GIN_MODE") == "release"
-	c.SetCookie(cookieName, token, cookieMaxAge, "/", "", secure, true)
-}
-
-// ClearAuthCookie clears the authentication cookie
-func ClearAuthCookie(c *gin.Context) {
-	c.SetCookie(cookieName, "", -1, "/", "", false, true)
-}
-
-// GetOIDCEnabled returns whether OIDC is enabled
+// GetOIDCEnabled returns whether OIDC is enabled and the providers to show on the login page
 func GetOIDCEnabled(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"enabled": config.OIDCEnabled})
+	providers := make([]models.OIDCProviderResponse, 0, len(config.OIDCProviders))
+	for _, provider := range config.OIDCProviders {
+		providers = append(providers, models.OIDCProviderResponse{
+			Name:     provider.Name,
+			Label:    provider.Label,
+			LoginURL: "/sync/api/auth/oidc/" + provider.Name + "/login",
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"enabled": config.OIDCEnabled, "providers": providers})
 }
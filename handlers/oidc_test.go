@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+// userWorkspaces returns the names of a user's workspaces with the source of each membership
+func userWorkspaces(t *testing.T, userID uint) map[string]string {
+	t.Helper()
+
+	var members []models.WorkspaceMember
+	database.DB.Where("user_id = ?", userID).Find(&members)
+	workspaces := make(map[string]string, len(members))
+	for _, member := range members {
+		var workspace models.Workspace
+		if err := database.DB.First(&workspace, member.WorkspaceID).Error; err != nil {
+			t.Fatal(err)
+		}
+		workspaces[workspace.Name] = member.Source
+	}
+	return workspaces
+}
+
+func TestOIDCWorkspaceGroups(t *testing.T) {
+	user := createTestUser(t, "oidc-workspaces@example.com")
+	provider := &config.OIDCProvider{OIDCProviderConfig: config.OIDCProviderConfig{
+		Name:            "staff",
+		WorkspaceGroups: map[string]string{"team-payments": "OIDC Payments", "team-data": "OIDC Data"},
+	}}
+
+	data := models.Workspace{Name: "OIDC Data"}
+	database.DB.Create(&data)
+	if err := setWorkspaceMember(database.DB, data.ID, user.ID, models.WorkspaceRoleAdmin, models.WorkspaceSourceManual); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/callback", nil)
+	apply := func(groups ...string) map[string]string {
+		t.Helper()
+		if err := applyOIDCGroups(c, provider, user, groups); err != nil {
+			t.Fatal(err)
+		}
+		return userWorkspaces(t, user.ID)
+	}
+
+	// The Payments workspace doesn't exist yet and is created
+	workspaces := apply("team-payments", "team-data", "unmapped")
+	if workspaces["OIDC Payments"] != "oidc:staff" || workspaces["OIDC Data"] != models.WorkspaceSourceManual {
+		t.Errorf("workspaces after login = %v, want Payments from the provider and Data kept as set by an administrator", workspaces)
+	}
+
+	// Leaving the groups only removes the membership the provider added
+	workspaces = apply()
+	if _, ok := workspaces["OIDC Payments"]; ok || len(workspaces) != 1 {
+		t.Errorf("workspaces after leaving the groups = %v, want only Data", workspaces)
+	}
+
+	if role := workspaceRole(t, data.ID, user.ID); role != models.WorkspaceRoleAdmin {
+		t.Errorf("role set by an administrator changed to %s by the provider", role)
+	}
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	if stored.IsAdmin != user.IsAdmin {
+		t.Error("provider without admin groups changed the administrator role")
+	}
+}
+
+func workspaceRole(t *testing.T, workspaceID, userID uint) string {
+	t.Helper()
+
+	var member models.WorkspaceMember
+	if err := database.DB.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).First(&member).Error; err != nil {
+		t.Fatal(err)
+	}
+	return member.Role
+}
+
+const oidcTestClientID = "chartdb-test"
+
+// oidcTestProvider is an in-process OpenID Connect provider: discovery, JWKS and a token endpoint
+// that checks the PKCE verifier. Tests approve logins with authorize instead of a login page
+type oidcTestProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]oidcTestGrant // Pending authorization codes
+	tokens []oidcTestTokenRequest   // Token requests received
+}
+
+// oidcTestGrant is an authorization code waiting to be exchanged
+type oidcTestGrant struct {
+	claims    map[string]interface{}
+	challenge string
+}
+
+// oidcTestTokenRequest is what the provider saw of a code exchange
+type oidcTestTokenRequest struct {
+	verifier  string
+	clientID  string
+	basicAuth bool
+}
+
+// newOIDCTestProvider starts a provider and configures it as the only OIDC provider, named "test"
+// Without a client secret it is configured as a public client
+func newOIDCTestProvider(t *testing.T, clientSecret string) *oidcTestProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &oidcTestProvider{key: key, codes: make(map[string]oidcTestGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"end_session_endpoint":                  p.server.URL + "/logout",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	enabled, providers := config.OIDCEnabled, config.OIDCProviders
+	t.Cleanup(func() { config.OIDCEnabled, config.OIDCProviders = enabled, providers })
+	t.Setenv("OIDC_ENABLED", "false")
+	t.Setenv("OIDC_CONFIG_FILE", "")
+	t.Setenv("OIDC_PROVIDERS", "test")
+	t.Setenv("OIDC_TEST_ISSUER_URL", p.server.URL)
+	t.Setenv("OIDC_TEST_CLIENT_ID", oidcTestClientID)
+	t.Setenv("OIDC_TEST_CLIENT_SECRET", clientSecret)
+	if err := config.InitOIDC(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// token exchanges an authorization code for an ID token with the claims it was approved with
+func (p *oidcTestProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, _, basicAuth := r.BasicAuth()
+	if !basicAuth {
+		clientID = r.PostForm.Get("client_id")
+	}
+	verifier := r.PostForm.Get("code_verifier")
+
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.tokens = append(p.tokens, oidcTestTokenRequest{verifier: verifier, clientID: clientID, basicAuth: basicAuth})
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(verifier))
+	if !ok || clientID != oidcTestClientID || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss": p.server.URL,
+		"aud": oidcTestClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range grant.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "test-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// authorize approves the login of an authorization URL with an ID token holding the given claims
+// and returns the authorization code; the nonce of the request is added unless claims has one
+func (p *oidcTestProvider) authorize(t *testing.T, authURL *url.URL, claims map[string]interface{}) string {
+	t.Helper()
+
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without an S256 PKCE challenge: %s", authURL)
+	}
+	grant := oidcTestGrant{claims: map[string]interface{}{"nonce": query.Get("nonce")}, challenge: query.Get("code_challenge")}
+	for name, value := range claims {
+		grant.claims[name] = value
+	}
+
+	code := make([]byte, 16)
+	rand.Read(code)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[base64.RawURLEncoding.EncodeToString(code)] = grant
+	return base64.RawURLEncoding.EncodeToString(code)
+}
+
+// oidcLogin runs a login through the test provider, which signs the user in with the given claims,
+// and returns the response of the callback
+func oidcLogin(t *testing.T, p *oidcTestProvider, claims map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := gin.New()
+	r.GET("/oidc/:provider/login", OIDCLogin)
+	r.GET("/oidc/:provider/callback", OIDCCallback)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oidc/test/login", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("OIDC login: status %d: %s", w.Code, w.Body.String())
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := p.authorize(t, authURL, claims)
+
+	callback := url.Values{"state": {authURL.Query().Get("state")}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/oidc/test/callback?"+callback.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	email := "oidc-nonce@example.com"
+
+	w := oidcLogin(t, provider, map[string]interface{}{"sub": "nonce", "email": email, "email_verified": true, "nonce": "replayed"})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid ID token nonce") {
+		t.Errorf("ID token with another nonce: status %d: %s", w.Code, w.Body.String())
+	}
+	var count int64
+	database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count != 0 {
+		t.Error("account created from an ID token with another nonce")
+	}
+}
+
+func TestOIDCTokenExchange(t *testing.T) {
+	for _, clientSecret := range []string{"client-secret", ""} {
+		provider := newOIDCTestProvider(t, clientSecret)
+
+		// The provider refuses the exchange unless the verifier matches the challenge of the login
+		w := oidcLogin(t, provider, map[string]interface{}{"sub": "exchange", "email": "oidc-exchange@example.com", "email_verified": true})
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/sync/sync" {
+			t.Fatalf("login with client secret %q: status %d: %s", clientSecret, w.Code, w.Body.String())
+		}
+
+		provider.mu.Lock()
+		tokens := provider.tokens
+		provider.mu.Unlock()
+		if len(tokens) != 1 {
+			t.Fatalf("%d token requests, want 1", len(tokens))
+		}
+		request := tokens[0]
+		if request.verifier == "" {
+			t.Error("token request without a PKCE verifier")
+		}
+		// Confidential clients authenticate with HTTP basic auth, public clients only send their client_id
+		if request.basicAuth != (clientSecret != "") {
+			t.Errorf("client secret %q: token request with basic auth %v", clientSecret, request.basicAuth)
+		}
+	}
+}
+
+func TestOIDCLogoutURL(t *testing.T) {
+	provider := newOIDCTestProvider(t, "client-secret")
+	w := oidcLogin(t, provider, map[string]interface{}{"sub": "logout", "email": "oidc-logout@example.com", "email_verified": true})
+	if w.Header().Get("Location") != "/sync/sync" {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+
+	r := gin.New()
+	r.POST("/logout", Logout)
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response struct {
+		LogoutURL string `json:"logout_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	logoutURL, err := url.Parse(response.LogoutURL)
+	if err != nil || !strings.HasPrefix(response.LogoutURL, provider.server.URL+"/logout?") {
+		t.Fatalf("logout: status %d: %s", w.Code, w.Body.String())
+	}
+	query := logoutURL.Query()
+	if query.Get("id_token_hint") == "" || query.Get("client_id") != oidcTestClientID {
+		t.Errorf("logout URL without the ID token hint or client_id: %s", response.LogoutURL)
+	}
+}
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	webauthnCookie     = "webauthn_session"
+	webauthnCookiePath = "/sync/api/auth/passkeys"
+	webauthnSessionTTL = 5 * time.Minute
+
+	webauthnPurposeRegistration = "registration"
+	webauthnPurposeLogin        = "login"
+)
+
+// passkeyUser adapts a User and their passkeys to the webauthn.User interface
+type passkeyUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID is the user handle stored in discoverable passkeys; it maps back to the user ID
+func (u *passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(u.user.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// ListPasskeys returns the passkeys of the current user
+func ListPasskeys(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var passkeys []models.Passkey
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at desc").Find(&passkeys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passkeys"})
+		return
+	}
+
+	response := make([]models.PasskeyResponse, len(passkeys))
+	for i, p := range passkeys {
+		response[i] = models.PasskeyResponse{
+			ID:         p.ID,
+			Name:       p.Name,
+			LastUsedAt: formatOptionalTime(p.LastUsedAt),
+			CreatedAt:  p.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// BeginPasskeyRegistration returns the options for navigator.credentials.create()
+func BeginPasskeyRegistration(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.BeginPasskeyRegistrationRequest
+	c.ShouldBindJSON(&req)
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	pkUser, err := loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Passkeys must be discoverable so they can sign in without an email, and verify the user (PIN or biometrics)
+	options, session, err := config.WebAuthn.BeginRegistration(pkUser,
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+		webauthn.WithExclusions(webauthn.Credentials(pkUser.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	if err := storeWebAuthnSession(c, session, webauthnPurposeRegistration, userID, name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyRegistration verifies the new credential and stores it
+// The request body is the PublicKeyCredential returned by navigator.credentials.create()
+func FinishPasskeyRegistration(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	stored, session, err := takeWebAuthnSession(c, webauthnPurposeRegistration)
+	if err != nil || stored.UserID != userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey registration expired, please try again"})
+		return
+	}
+
+	pkUser, err := loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	credential, err := config.WebAuthn.FinishRegistration(pkUser, *session, c.Request)
+	if err != nil {
+		log.Printf("Passkey registration failed for user %d: %v", userID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+		return
+	}
+
+	passkey := models.Passkey{
+		UserID:       userID,
+		Name:         stored.Name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(credential.ID),
+		Credential:   string(data),
+	}
+	if err := database.DB.Create(&passkey).Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This passkey is already registered"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.PasskeyResponse{
+		ID:        passkey.ID,
+		Name:      passkey.Name,
+		CreatedAt: passkey.CreatedAt.Format(time.RFC3339),
+	})
+}
+
+// DeletePasskey removes a passkey of the current user
+func DeletePasskey(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	passkeyID := c.Param("passkeyId")
+
+	var passkey models.Passkey
+	if err := database.DB.Where("id = ? AND user_id = ?", passkeyID, userID).First(&passkey).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := database.DB.Delete(&passkey).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted successfully"})
+}
+
+// BeginPasskeyLogin returns the options for navigator.credentials.get()
+// No email is needed: the authenticator offers the passkeys it holds for this site
+func BeginPasskeyLogin(c *gin.Context) {
+	options, session, err := config.WebAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	if err := storeWebAuthnSession(c, session, webauthnPurposeLogin, 0, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session
+// A passkey with user verification counts as two factors, so no TOTP code is asked for
+func FinishPasskeyLogin(c *gin.Context) {
+	_, session, err := takeWebAuthnSession(c, webauthnPurposeLogin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey login expired, please try again"})
+		return
+	}
+
+	var passkey models.Passkey
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, ok := userIDFromPasskeyHandle(userHandle)
+		if !ok {
+			return nil, errors.New("invalid user handle")
+		}
+		credentialID := base64.RawURLEncoding.EncodeToString(rawID)
+		if err := database.DB.Where("credential_id = ? AND user_id = ?", credentialID, userID).First(&passkey).Error; err != nil {
+			return nil, err
+		}
+		return loadPasskeyUser(userID)
+	}
+
+	user, credential, err := config.WebAuthn.FinishPasskeyLogin(handler, *session, c.Request)
+	if err != nil {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, nil, "", "passkey", "invalid_assertion")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	// A sign counter that went backwards suggests a cloned authenticator
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey %d of user %d reported a lower sign count, possible clone", passkey.ID, passkey.UserID)
+		auditLoginFailure(c, user.(*passkeyUser).user, user.(*passkeyUser).user.Email, "passkey", "clone_warning")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey could not be verified"})
+		return
+	}
+
+	if data, err := json.Marshal(credential); err == nil {
+		database.DB.Model(&passkey).Updates(map[string]interface{}{
+			"credential":   string(data),
+			"last_used_at": time.Now(),
+		})
+	}
+
+	startSession(c, http.StatusOK, user.(*passkeyUser).user, "passkey")
+}
+
+// loadPasskeyUser loads a user together with their passkeys
+func loadPasskeyUser(userID uint) (*passkeyUser, error) {
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	var passkeys []models.Passkey
+	if err := database.DB.Where("user_id = ?", userID).Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(passkeys))
+	for _, p := range passkeys {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(p.Credential), &credential); err != nil {
+			log.Printf("Skipping unreadable passkey %d: %v", p.ID, err)
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &passkeyUser{user: &user, credentials: credentials}, nil
+}
+
+// storeWebAuthnSession saves the ceremony state and hands the browser a cookie referencing it
+func storeWebAuthnSession(c *gin.Context, session *webauthn.SessionData, purpose string, userID uint, name string) error {
+	token, err := middleware.GenerateOpaqueToken("")
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// Clean up abandoned ceremonies while we're here
+	database.DB.Where("expires_at <= ?", now).Delete(&models.WebAuthnSession{})
+
+	stored := models.WebAuthnSession{
+		TokenHash: middleware.HashOpaqueToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Name:      name,
+		Data:      string(data),
+		ExpiresAt: now.Add(webauthnSessionTTL),
+	}
+	if err := database.DB.Create(&stored).Error; err != nil {
+		return err
+	}
+
+	c.SetCookie(webauthnCookie, token, int(webauthnSessionTTL.Seconds()), webauthnCookiePath, "", middleware.SecureRequest(c), true)
+	return nil
+}
+
+// takeWebAuthnSession loads and deletes the ceremony state referenced by the cookie, so each challenge is used once
+func takeWebAuthnSession(c *gin.Context, purpose string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	token, err := c.Cookie(webauthnCookie)
+	if err != nil || token == "" {
+		return nil, nil, errors.New("missing ceremony cookie")
+	}
+	c.SetCookie(webauthnCookie, "", -1, webauthnCookiePath, "", middleware.SecureRequest(c), true)
+
+	var stored models.WebAuthnSession
+	if err := database.DB.Where("token_hash = ? AND purpose = ?", middleware.HashOpaqueToken(token), purpose).First(&stored).Error; err != nil {
+		return nil, nil, err
+	}
+	if result := database.DB.Delete(&stored); result.Error != nil || result.RowsAffected == 0 {
+		return nil, nil, errors.New("ceremony already finished")
+	}
+	if !stored.ExpiresAt.After(time.Now()) {
+		return nil, nil, errors.New("ceremony expired")
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(stored.Data), &session); err != nil {
+		return nil, nil, err
+	}
+
+	return &stored, &session, nil
+}
+
+// passkeyUserHandle encodes a user ID as a WebAuthn user handle
+func passkeyUserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// userIDFromPasskeyHandle decodes a user handle created by passkeyUserHandle
+func userIDFromPasskeyHandle(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return uint(binary.BigEndian.Uint64(handle)), true
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softAuthenticator is a software passkey that answers WebAuthn ceremonies like a platform authenticator
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+// ceremonyOptions holds the parts of the creation and request options an authenticator needs
+type ceremonyOptions struct {
+	PublicKey struct {
+		Challenge string `json:"challenge"`
+		RPID      string `json:"rpId"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	} `json:"publicKey"`
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, options ceremonyOptions) []byte {
+	t.Helper()
+
+	userHandle, err := base64.RawURLEncoding.DecodeString(options.PublicKey.User.ID)
+	if err != nil {
+		t.Fatalf("decode user handle: %v", err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authenticatorData(options.PublicKey.RP.ID, flagUserPresent|flagUserVerified|flagAttestedData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credentialJSON(t, map[string]string{
+		"clientDataJSON":    b64(a.clientData(t, "webauthn.create", options.PublicKey.Challenge)),
+		"attestationObject": b64(attestation),
+	})
+}
+
+// get answers navigator.credentials.get() with a signed assertion
+func (a *softAuthenticator) get(t *testing.T, options ceremonyOptions) []byte {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authenticatorData(options.PublicKey.RPID, flagUserPresent|flagUserVerified)
+	clientData := a.clientData(t, "webauthn.get", options.PublicKey.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credentialJSON(t, map[string]string{
+		"clientDataJSON":    b64(clientData),
+		"authenticatorData": b64(authData),
+		"signature":         b64(signature),
+		"userHandle":        b64(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credentialJSON(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       b64(a.credentialID),
+		"rawId":    b64(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// passkeyTestServer serves the passkey routes, signing requests in as userID where a user is needed
+func passkeyTestServer(userID uint) *gin.Engine {
+	r := gin.New()
+	signedIn := func(c *gin.Context) {
+		c.Set("user_id", userID)
+	}
+	r.POST("/register/begin", signedIn, BeginPasskeyRegistration)
+	r.POST("/register/finish", signedIn, FinishPasskeyRegistration)
+	r.POST("/login/begin", BeginPasskeyLogin)
+	r.POST("/login/finish", FinishPasskeyLogin)
+	return r
+}
+
+// ceremonyClient calls the passkey routes, carrying the ceremony cookie like a browser
+type ceremonyClient struct {
+	t       *testing.T
+	server  *gin.Engine
+	cookies []*http.Cookie
+}
+
+func (cc *ceremonyClient) post(path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cc.cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	cc.server.ServeHTTP(w, req)
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		cc.cookies = cookies
+	}
+	return w
+}
+
+func (cc *ceremonyClient) begin(path string) ceremonyOptions {
+	cc.t.Helper()
+
+	w := cc.post(path, []byte(`{"name":"Test key"}`))
+	if w.Code != http.StatusOK {
+		cc.t.Fatalf("%s: status %d: %s", path, w.Code, w.Body.String())
+	}
+	var options ceremonyOptions
+	if err := json.Unmarshal(w.Body.Bytes(), &options); err != nil {
+		cc.t.Fatalf("%s: %v", path, err)
+	}
+	return options
+}
+
+// registerPasskey runs a registration ceremony for the user with a new software authenticator
+func registerPasskey(t *testing.T, user *models.User) *softAuthenticator {
+	t.Helper()
+
+	authenticator := newSoftAuthenticator(t)
+	client := &ceremonyClient{t: t, server: passkeyTestServer(user.ID)}
+
+	options := client.begin("/register/begin")
+	if w := client.post("/register/finish", authenticator.create(t, options)); w.Code != http.StatusCreated {
+		t.Fatalf("finish registration: status %d: %s", w.Code, w.Body.String())
+	}
+	return authenticator
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	user := createTestUser(t, "passkey@example.com")
+	authenticator := registerPasskey(t, user)
+
+	var passkey models.Passkey
+	if err := database.DB.Where("user_id = ?", user.ID).First(&passkey).Error; err != nil {
+		t.Fatalf("passkey not stored: %v", err)
+	}
+	if passkey.Name != "Test key" || passkey.CredentialID != b64(authenticator.credentialID) {
+		t.Errorf("stored passkey = %q %q", passkey.Name, passkey.CredentialID)
+	}
+
+	client := &ceremonyClient{t: t, server: passkeyTestServer(0)}
+	options := client.begin("/login/begin")
+	w := client.post("/login/finish", authenticator.get(t, options))
+	if w.Code != http.StatusOK {
+		t.Fatalf("finish login: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var response models.AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.User.ID != user.ID || response.Token == "" {
+		t.Errorf("login response = %+v, want a session for user %d", response.User, user.ID)
+	}
+
+	database.DB.First(&passkey, passkey.ID)
+	if passkey.LastUsedAt == nil {
+		t.Error("LastUsedAt was not updated")
+	}
+}
+
+func TestPasskeyLoginRejectsBadAssertions(t *testing.T) {
+	user := createTestUser(t, "passkey-rejects@example.com")
+	authenticator := registerPasskey(t, user)
+
+	t.Run("wrong key", func(t *testing.T) {
+		impostor := newSoftAuthenticator(t)
+		impostor.credentialID = authenticator.credentialID
+		impostor.userHandle = authenticator.userHandle
+
+		client := &ceremonyClient{t: t, server: passkeyTestServer(0)}
+		options := client.begin("/login/begin")
+		if w := client.post("/login/finish", impostor.get(t, options)); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		client := &ceremonyClient{t: t, server: passkeyTestServer(0)}
+		options := client.begin("/login/begin")
+		cookies := client.cookies
+		if w := client.post("/login/finish", authenticator.get(t, options)); w.Code != http.StatusOK {
+			t.Fatalf("first login: status %d: %s", w.Code, w.Body.String())
+		}
+
+		client.cookies = cookies
+		if w := client.post("/login/finish", authenticator.get(t, options)); w.Code != http.StatusBadRequest {
+			t.Errorf("replay: status %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("sign count went backwards", func(t *testing.T) {
+		authenticator.signCount = 0
+
+		client := &ceremonyClient{t: t, server: passkeyTestServer(0)}
+		options := client.begin("/login/begin")
+		if w := client.post("/login/finish", authenticator.get(t, options)); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestWebAuthnCookieSecureOverTLS(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		proto string
+		want  bool
+	}{
+		{"http", "", false},
+		{"https proxy", "https", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login/begin", nil)
+			if tc.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			w := httptest.NewRecorder()
+			passkeyTestServer(0).ServeHTTP(w, req)
+
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != webauthnCookie {
+				t.Fatalf("cookies = %v", cookies)
+			}
+			if cookies[0].Secure != tc.want {
+				t.Errorf("Secure = %v, want %v", cookies[0].Secure, tc.want)
+			}
+		})
+	}
+}
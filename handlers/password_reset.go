@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/mailer"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTokenPrefix = "prt_"
+	passwordResetTokenTTL    = time.Hour
+)
+
+// RequestPasswordReset emails a password reset link
+// The response is the same whether or not the email belongs to an account
+func RequestPasswordReset(c *gin.Context) {
+	var req models.PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If an account exists for this email, a password reset link has been sent"}
+
+	var user models.User
+	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Only local accounts sign in with a password: SSO and directory users sign in with their provider,
+	// which also decides whether they need a second factor, and disabled users can't sign in anyway
+	if !user.IsLocal() || !user.IsActive() {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// A failure is only logged: answering differently would reveal that the account exists
+	if err := sendPasswordResetEmail(c, &user); err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword sets a new password using a reset token and signs out all sessions
+func ResetPassword(c *gin.Context) {
+	var req models.PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var resetToken models.PasswordResetToken
+	err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(req.Token)).First(&resetToken).Error
+	if err != nil || resetToken.UsedAt != nil || !resetToken.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, resetToken.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	// Mark the token as used; the condition guards against concurrent use
+	result := tx.Model(&resetToken).Where("used_at IS NULL").Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset link"})
+		return
+	}
+
+	if err := tx.Model(&user).Update("password", string(hashedPassword)).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	// Whoever knew the old password must not stay signed in
+	if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+		log.Printf("Failed to revoke sessions of user %d after password reset: %v", user.ID, err)
+	}
+
+	middleware.AuditUser(c, models.AuditPasswordReset, &user, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, please sign in with your new password"})
+}
+
+// sendPasswordResetEmail emails the user a link to choose a new password
+// Earlier links of the user stop working
+func sendPasswordResetEmail(c *gin.Context, user *models.User) error {
+	token, err := middleware.GenerateOpaqueToken(passwordResetTokenPrefix)
+	if err != nil {
+		return err
+	}
+
+	tx := database.DB.Begin()
+
+	// Only the most recent link stays valid
+	if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	}
+	if err := tx.Create(&resetToken).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/sync/reset-password?token=%s", appBaseURL(c), url.QueryEscape(token))
+	return mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your ChartDB Sync password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your ChartDB Sync account.\n"+
+			"Open this link within %d minutes to choose a new password:\n\n%s\n\n"+
+			"If you didn't ask for this, you can ignore this email.\n",
+			user.Name, int(passwordResetTokenTTL.Minutes()), link),
+	})
+}
+
+// appBaseURL returns the public URL of the server for links in emails and redirects
+// APP_BASE_URL is required to send real emails, since the request's Host header is client-controlled;
+// without it (development, log mailer) the URL is derived from the request
+func appBaseURL(c *gin.Context) string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/mailer"
+)
+
+var resetLinkPattern = regexp.MustCompile(`\S+/sync/reset-password\?token=\S+`)
+
+func TestPasswordResetRoundTrip(t *testing.T) {
+	user := createTestUser(t, "reset-round-trip@example.com")
+	setTestPassword(t, user, "forgotten-password")
+	session := createTestSession(t, user)
+
+	dir := t.TempDir()
+	previous := mailer.Default
+	mailer.Default = &mailer.FileMailer{Dir: dir, From: "ChartDB Sync <noreply@example.com>"}
+	defer func() { mailer.Default = previous }()
+
+	r := gin.New()
+	r.POST("/password-reset", RequestPasswordReset)
+	r.POST("/password-reset/confirm", ResetPassword)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post("/password-reset", `{"email": "reset-round-trip@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("request reset: status %d: %s", w.Code, w.Body.String())
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("%d emails written, want 1", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: reset-round-trip@example.com") {
+		t.Errorf("email not addressed to the user:\n%s", data)
+	}
+	link, err := url.Parse(resetLinkPattern.FindString(string(data)))
+	if err != nil || link.Query().Get("token") == "" {
+		t.Fatalf("no reset link in the email:\n%s", data)
+	}
+	token := link.Query().Get("token")
+
+	if w := post("/password-reset/confirm", `{"token": "`+token+`", "new_password": "remembered-password"}`); w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d: %s", w.Code, w.Body.String())
+	}
+	if sessionExists(session.SessionID) {
+		t.Error("session still active after resetting the password")
+	}
+	if w := login(t, user.Email, "forgotten-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d", w.Code)
+	}
+	loginUser(t, login(t, user.Email, "remembered-password"))
+
+	if w := post("/password-reset/confirm", `{"token": "`+token+`", "new_password": "another-password"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("reused reset link: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestNoPasswordForSSOAccounts(t *testing.T) {
+	user := createTestUser(t, "reset-sso@example.com")
+	setTestPassword(t, user, "sso-password")
+	database.DB.Model(user).Update("auth_provider", "oidc")
+
+	dir := t.TempDir()
+	previous := mailer.Default
+	mailer.Default = &mailer.FileMailer{Dir: dir, From: "ChartDB Sync <noreply@example.com>"}
+	defer func() { mailer.Default = previous }()
+
+	r := gin.New()
+	r.POST("/password-reset", RequestPasswordReset)
+	req := httptest.NewRequest(http.MethodPost, "/password-reset", strings.NewReader(`{"email": "reset-sso@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request reset: status %d: %s", w.Code, w.Body.String())
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.eml")); len(files) != 0 {
+		t.Errorf("reset link emailed to an OIDC account")
+	}
+
+	// Even with a password set, the account can't skip its provider
+	if w := login(t, user.Email, "sso-password"); w.Code != http.StatusUnauthorized {
+		t.Errorf("password login of an OIDC account: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,1168 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 500
+)
+
+// scimFilterPattern matches the filters identity providers send to look up a resource,
+// e.g. userName eq "jane@example.com" or active eq true
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9.:]*)\s+eq\s+(?:"((?:[^"\\]|\\.)*)"|(true|false))\s*$`)
+
+var errSCIMInvalidValue = errors.New("invalid value")
+
+// scimUserChanges collects the attributes a create, replace or patch request sets on a user
+type scimUserChanges struct {
+	userName    *string
+	email       *string
+	externalID  *string
+	displayName *string
+	formatted   *string
+	givenName   *string
+	familyName  *string
+	active      *bool
+	password    *string
+}
+
+// SCIMServiceProviderConfig describes the supported SCIM features
+func SCIMServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{models.SCIMSchemaSPConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token configured in SCIM_TOKEN",
+		}},
+	})
+}
+
+// SCIMListUsers lists users, optionally filtered by userName, externalId, emails.value, id or active
+func SCIMListUsers(c *gin.Context) {
+	startIndex, count := scimPage(c)
+
+	query := database.DB.Model(&models.User{})
+	if filter := c.Query("filter"); filter != "" {
+		attribute, value, ok := parseSCIMFilter(filter)
+		if !ok {
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter: "+filter)
+			return
+		}
+		switch attribute {
+		case "username", "emails", "emails.value":
+			query = query.Where("LOWER(email) = LOWER(?)", value)
+		case "externalid":
+			query = query.Where("external_id = ?", value)
+		case "id":
+			query = query.Where("id = ?", value)
+		case "active":
+			if strings.EqualFold(value, "true") {
+				query = query.Where("deactivated_at IS NULL")
+			} else {
+				query = query.Where("deactivated_at IS NOT NULL")
+			}
+		default:
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter attribute: "+attribute)
+			return
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to fetch users")
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("id").Offset(startIndex - 1).Limit(count).Find(&users).Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to fetch users")
+		return
+	}
+
+	resources := make([]models.SCIMUser, 0, len(users))
+	for i := range users {
+		resources = append(resources, toSCIMUser(c, &users[i]))
+	}
+
+	scimJSON(c, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// SCIMGetUser returns a user
+func SCIMGetUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMUser(c, user))
+}
+
+// SCIMCreateUser provisions a user
+// Users sign in through OIDC or LDAP when one is configured, else with a password they set
+// through a password reset (or the password in the request)
+func SCIMCreateUser(c *gin.Context) {
+	var req models.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	changes := scimUserChangesFrom(&req)
+	email := changes.resolveEmail()
+	if email == "" {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "userName or emails must hold an email address")
+		return
+	}
+
+	var existing models.User
+	if err := database.DB.Unscoped().Where("LOWER(email) = LOWER(?)", email).First(&existing).Error; err == nil {
+		middleware.SCIMError(c, http.StatusConflict, "uniqueness", "A user with this userName already exists")
+		return
+	}
+
+	password := generateRandomPassword()
+	authProvider := scimAuthProvider()
+	if changes.password != nil && *changes.password != "" {
+		password = *changes.password
+		authProvider = "local"
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to hash password")
+		return
+	}
+
+	now := time.Now()
+	user := models.User{
+		Email:        email,
+		Password:     string(hashedPassword),
+		Name:         changes.resolveName(""),
+		AuthProvider: authProvider,
+		// The identity provider vouches for the email address
+		EmailVerifiedAt: &now,
+	}
+	if changes.externalID != nil {
+		user.ExternalID = *changes.externalID
+	}
+	if changes.active != nil && !*changes.active {
+		user.DeactivatedAt = &now
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to create user")
+		return
+	}
+	if err := database.PromoteAdminEmail(database.DB, &user); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update user role")
+		return
+	}
+
+	c.Header("Location", scimLocation(c, "Users", user.ID))
+	scimJSON(c, http.StatusCreated, toSCIMUser(c, &user))
+}
+
+// SCIMReplaceUser replaces the attributes of a user (PUT)
+func SCIMReplaceUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	changes := scimUserChangesFrom(&req)
+	if changes.externalID == nil {
+		empty := ""
+		changes.externalID = &empty
+	}
+	applySCIMUserChanges(c, user, changes)
+}
+
+// SCIMPatchUser changes some attributes of a user; setting active to false deactivates the user,
+// ending their sessions and revoking their personal access tokens
+func SCIMPatchUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+
+	var req models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	var changes scimUserChanges
+	for _, op := range req.Operations {
+		if err := changes.applyPatch(op); err != nil {
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	applySCIMUserChanges(c, user, &changes)
+}
+
+// SCIMDeleteUser deletes a user and erases their data
+// The account is removed for good, so the identity provider can provision the same email again
+func SCIMDeleteUser(c *gin.Context) {
+	user, ok := findSCIMUser(c)
+	if !ok {
+		return
+	}
+
+	if _, err := middleware.RevokeUserSessions(user.ID, 0); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete user")
+		return
+	}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeUser(tx, user.ID)
+	}); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete user")
+		return
+	}
+
+	// The email is erased with the account, so only the ID is recorded
+	middleware.Audit(c, models.AuditEvent{
+		Action:  models.AuditAccountDeleted,
+		UserID:  &user.ID,
+		Details: map[string]interface{}{"by": "scim"},
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// SCIMListGroups lists groups, optionally filtered by displayName, externalId or id
+func SCIMListGroups(c *gin.Context) {
+	startIndex, count := scimPage(c)
+
+	query := database.DB.Model(&models.SCIMGroup{})
+	if filter := c.Query("filter"); filter != "" {
+		attribute, value, ok := parseSCIMFilter(filter)
+		if !ok {
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter: "+filter)
+			return
+		}
+		switch attribute {
+		case "displayname":
+			query = query.Where("LOWER(display_name) = LOWER(?)", value)
+		case "externalid":
+			query = query.Where("external_id = ?", value)
+		case "id":
+			query = query.Where("id = ?", value)
+		default:
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter attribute: "+attribute)
+			return
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to fetch groups")
+		return
+	}
+
+	var groups []models.SCIMGroup
+	if err := query.Order("id").Offset(startIndex - 1).Limit(count).Find(&groups).Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to fetch groups")
+		return
+	}
+
+	// Identity providers ask to leave out members when they only look for the group
+	withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+
+	resources := make([]models.SCIMGroupResource, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, toSCIMGroup(c, &groups[i], withMembers))
+	}
+
+	scimJSON(c, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// SCIMGetGroup returns a group with its members
+func SCIMGetGroup(c *gin.Context) {
+	group, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+	withMembers := !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members")
+	scimJSON(c, http.StatusOK, toSCIMGroup(c, group, withMembers))
+}
+
+// SCIMCreateGroup creates a group with its initial members
+func SCIMCreateGroup(c *gin.Context) {
+	var req models.SCIMGroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	if req.DisplayName == "" {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+
+	var existing models.SCIMGroup
+	if err := database.DB.Where("LOWER(display_name) = LOWER(?)", req.DisplayName).First(&existing).Error; err == nil {
+		middleware.SCIMError(c, http.StatusConflict, "uniqueness", "A group with this displayName already exists")
+		return
+	}
+
+	memberIDs, err := scimMemberIDs(req.Members)
+	if err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	group := models.SCIMGroup{DisplayName: req.DisplayName, ExternalID: req.ExternalID}
+
+	tx := database.DB.Begin()
+	if err := tx.Create(&group).Error; err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to create group")
+		return
+	}
+	if err := setSCIMGroupMembers(tx, group.ID, memberIDs); err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to create group")
+		return
+	}
+	if err := syncSCIMGroupWorkspace(tx, group.ID); err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to create group")
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to create group")
+		return
+	}
+
+	if err := syncSCIMAdminRoles(c, memberIDs); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update user roles")
+		return
+	}
+
+	c.Header("Location", scimLocation(c, "Groups", group.ID))
+	database.DB.First(&group, group.ID)
+	scimJSON(c, http.StatusCreated, toSCIMGroup(c, &group, true))
+}
+
+// SCIMReplaceGroup replaces the name and members of a group (PUT)
+func SCIMReplaceGroup(c *gin.Context) {
+	group, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+
+	var req models.SCIMGroupResource
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	req.DisplayName = strings.TrimSpace(req.DisplayName)
+	if req.DisplayName == "" {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+
+	memberIDs, err := scimMemberIDs(req.Members)
+	if err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	name, externalID := req.DisplayName, req.ExternalID
+	applySCIMGroupChanges(c, group, &name, &externalID, func(tx *gorm.DB) error {
+		return setSCIMGroupMembers(tx, group.ID, memberIDs)
+	})
+}
+
+// SCIMPatchGroup renames a group or adds and removes members
+func SCIMPatchGroup(c *gin.Context) {
+	group, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+
+	var req models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		middleware.SCIMError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	var name, externalID *string
+	var memberOps []func(tx *gorm.DB) error
+	for _, op := range req.Operations {
+		opName := strings.ToLower(op.Op)
+		values := map[string]interface{}{scimAttributePath(op.Path): op.Value}
+		if op.Path == "" {
+			object, ok := op.Value.(map[string]interface{})
+			if !ok {
+				middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+				return
+			}
+			values = make(map[string]interface{}, len(object))
+			for key, value := range object {
+				values[scimAttributePath(key)] = value
+			}
+		}
+
+		for path, value := range values {
+			switch {
+			case path == "displayname":
+				s, ok := value.(string)
+				if !ok || strings.TrimSpace(s) == "" {
+					middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "displayName must be a non-empty string")
+					return
+				}
+				s = strings.TrimSpace(s)
+				name = &s
+			case path == "externalid":
+				s, _ := value.(string)
+				externalID = &s
+			case path == "members":
+				memberIDs, err := scimMemberIDs(value)
+				if err != nil {
+					middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", err.Error())
+					return
+				}
+				memberOps = append(memberOps, scimMemberOperation(group.ID, opName, memberIDs))
+			case strings.HasPrefix(path, "members["):
+				// members[value eq "42"] addresses a single member
+				memberID, ok := scimMemberFilterID(path)
+				if !ok || opName != "remove" {
+					middleware.SCIMError(c, http.StatusBadRequest, "invalidPath", "Unsupported path: "+op.Path)
+					return
+				}
+				memberOps = append(memberOps, scimMemberOperation(group.ID, opName, []uint{memberID}))
+			}
+		}
+	}
+
+	applySCIMGroupChanges(c, group, name, externalID, func(tx *gorm.DB) error {
+		for _, apply := range memberOps {
+			if err := apply(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SCIMDeleteGroup deletes a group; its members leave its workspace and lose the administrator role it granted
+// The workspace itself is kept with the members added otherwise
+func SCIMDeleteGroup(c *gin.Context) {
+	group, ok := findSCIMGroup(c)
+	if !ok {
+		return
+	}
+
+	memberIDs := scimGroupMemberIDs(group.ID)
+
+	tx := database.DB.Begin()
+	if err := tx.Where("group_id = ?", group.ID).Delete(&models.SCIMGroupMember{}).Error; err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete group")
+		return
+	}
+	if err := tx.Where("workspace_id = ? AND source = ?", group.WorkspaceID, models.WorkspaceSourceSCIM).Delete(&models.WorkspaceMember{}).Error; err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete group")
+		return
+	}
+	if err := tx.Delete(group).Error; err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete group")
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to delete group")
+		return
+	}
+
+	if err := syncSCIMAdminRoles(c, memberIDs); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update user roles")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// scimAuthProvider returns how provisioned users without a password sign in
+func scimAuthProvider() string {
+	switch {
+	case config.OIDCEnabled:
+		return "oidc"
+	case config.LDAPEnabled:
+		return ldapProviderName
+	default:
+		return "local"
+	}
+}
+
+// applySCIMUserChanges saves the changes of a replace or patch request and responds with the user
+func applySCIMUserChanges(c *gin.Context, user *models.User, changes *scimUserChanges) {
+	updates := make(map[string]interface{})
+
+	if changes.userName != nil || changes.email != nil {
+		email := changes.resolveEmail()
+		if email == "" {
+			middleware.SCIMError(c, http.StatusBadRequest, "invalidValue", "userName or emails must hold an email address")
+			return
+		}
+		if !strings.EqualFold(email, user.Email) {
+			var existing models.User
+			if err := database.DB.Unscoped().Where("LOWER(email) = LOWER(?) AND id <> ?", email, user.ID).First(&existing).Error; err == nil {
+				middleware.SCIMError(c, http.StatusConflict, "uniqueness", "A user with this userName already exists")
+				return
+			}
+			updates["email"] = email
+		}
+	}
+	if name := changes.resolveName(user.Name); name != user.Name {
+		updates["name"] = name
+	}
+	if changes.externalID != nil && *changes.externalID != user.ExternalID {
+		updates["external_id"] = *changes.externalID
+	}
+	if changes.password != nil && *changes.password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(*changes.password), bcrypt.DefaultCost)
+		if err != nil {
+			middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to hash password")
+			return
+		}
+		updates["password"] = string(hashedPassword)
+	}
+	if changes.active != nil && *changes.active && !user.IsActive() {
+		updates["deactivated_at"] = nil
+	}
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+			middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update user")
+			return
+		}
+	}
+
+	if changes.active != nil && !*changes.active && user.IsActive() {
+		if err := deactivateUser(user); err != nil {
+			middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to deactivate user")
+			return
+		}
+	}
+
+	database.DB.First(user, user.ID)
+	scimJSON(c, http.StatusOK, toSCIMUser(c, user))
+}
+
+// applySCIMGroupChanges renames a group and changes its members in one transaction, together with
+// its workspace, then updates the administrator role of everyone affected and responds with the group
+func applySCIMGroupChanges(c *gin.Context, group *models.SCIMGroup, name, externalID *string, changeMembers func(tx *gorm.DB) error) {
+	if name != nil && !strings.EqualFold(*name, group.DisplayName) {
+		var existing models.SCIMGroup
+		if err := database.DB.Where("LOWER(display_name) = LOWER(?) AND id <> ?", *name, group.ID).First(&existing).Error; err == nil {
+			middleware.SCIMError(c, http.StatusConflict, "uniqueness", "A group with this displayName already exists")
+			return
+		}
+		if group.WorkspaceID != 0 && workspaceNameTaken(*name, group.WorkspaceID) {
+			middleware.SCIMError(c, http.StatusConflict, "uniqueness", "A workspace with this name already exists")
+			return
+		}
+	}
+
+	// Members before and after the change may both gain or lose the role
+	affected := scimGroupMemberIDs(group.ID)
+
+	tx := database.DB.Begin()
+
+	updates := make(map[string]interface{})
+	if name != nil {
+		updates["display_name"] = *name
+	}
+	if externalID != nil {
+		updates["external_id"] = *externalID
+	}
+	if len(updates) > 0 {
+		if err := tx.Model(group).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update group")
+			return
+		}
+	}
+	if err := changeMembers(tx); err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update group members")
+		return
+	}
+	if err := syncSCIMGroupWorkspace(tx, group.ID); err != nil {
+		tx.Rollback()
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update group members")
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update group")
+		return
+	}
+
+	affected = append(affected, scimGroupMemberIDs(group.ID)...)
+	if err := syncSCIMAdminRoles(c, affected); err != nil {
+		middleware.SCIMError(c, http.StatusInternalServerError, "", "Failed to update user roles")
+		return
+	}
+
+	database.DB.First(group, group.ID)
+	scimJSON(c, http.StatusOK, toSCIMGroup(c, group, true))
+}
+
+// syncSCIMAdminRoles makes the given users administrators if they are in a SCIM admin group,
+// and takes the role away otherwise. Does nothing without SCIM_ADMIN_GROUPS
+func syncSCIMAdminRoles(c *gin.Context, userIDs []uint) error {
+	if len(config.SCIMAdminGroups) == 0 || len(userIDs) == 0 {
+		return nil
+	}
+
+	adminGroupIDs := []uint{}
+	var groups []models.SCIMGroup
+	if err := database.DB.Find(&groups).Error; err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if config.IsSCIMAdminGroup(group.DisplayName) {
+			adminGroupIDs = append(adminGroupIDs, group.ID)
+		}
+	}
+
+	var users []models.User
+	if err := database.DB.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+	for i := range users {
+		var count int64
+		if len(adminGroupIDs) > 0 {
+			if err := database.DB.Model(&models.SCIMGroupMember{}).
+				Where("user_id = ? AND group_id IN ?", users[i].ID, adminGroupIDs).
+				Count(&count).Error; err != nil {
+				return err
+			}
+		}
+		if err := setAdminRole(c, &users[i], count > 0, "scim"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSCIMGroupMembers replaces the members of a group
+func setSCIMGroupMembers(tx *gorm.DB, groupID uint, userIDs []uint) error {
+	if err := tx.Where("group_id = ?", groupID).Delete(&models.SCIMGroupMember{}).Error; err != nil {
+		return err
+	}
+	return addSCIMGroupMembers(tx, groupID, userIDs)
+}
+
+// addSCIMGroupMembers adds users to a group, skipping unknown users and existing members
+func addSCIMGroupMembers(tx *gorm.DB, groupID uint, userIDs []uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	var existingUsers []uint
+	if err := tx.Model(&models.User{}).Where("id IN ?", userIDs).Pluck("id", &existingUsers).Error; err != nil {
+		return err
+	}
+	for _, userID := range existingUsers {
+		member := models.SCIMGroupMember{GroupID: groupID, UserID: userID}
+		if err := tx.Where(&member).FirstOrCreate(&member).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scimMemberOperation returns the change a PATCH operation on the members attribute makes
+func scimMemberOperation(groupID uint, op string, userIDs []uint) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		switch op {
+		case "add":
+			return addSCIMGroupMembers(tx, groupID, userIDs)
+		case "replace":
+			return setSCIMGroupMembers(tx, groupID, userIDs)
+		case "remove":
+			// Removing members without a value removes all of them
+			query := tx.Where("group_id = ?", groupID)
+			if len(userIDs) > 0 {
+				query = query.Where("user_id IN ?", userIDs)
+			}
+			return query.Delete(&models.SCIMGroupMember{}).Error
+		default:
+			return fmt.Errorf("unsupported operation %q", op)
+		}
+	}
+}
+
+// syncSCIMGroupWorkspace makes the members of a group the SCIM members of its workspace, and gives the
+// workspace the group's name. The workspace is created, or found by name, when the group has none yet.
+// Members added to the workspace otherwise are kept, with the role they were given
+func syncSCIMGroupWorkspace(tx *gorm.DB, groupID uint) error {
+	var group models.SCIMGroup
+	if err := tx.First(&group, groupID).Error; err != nil {
+		return err
+	}
+
+	var workspace models.Workspace
+	err := gorm.ErrRecordNotFound
+	if group.WorkspaceID != 0 {
+		err = tx.First(&workspace, group.WorkspaceID).Error
+	}
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		// New group, or its workspace was deleted by an administrator
+		created, err := findOrCreateWorkspace(tx, group.DisplayName)
+		if err != nil {
+			return err
+		}
+		workspace = *created
+		if err := tx.Model(&group).Update("workspace_id", workspace.ID).Error; err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case workspace.Name != group.DisplayName:
+		if err := tx.Model(&workspace).Update("name", group.DisplayName).Error; err != nil {
+			return err
+		}
+	}
+
+	var memberIDs []uint
+	if err := tx.Model(&models.SCIMGroupMember{}).Where("group_id = ?", group.ID).Pluck("user_id", &memberIDs).Error; err != nil {
+		return err
+	}
+	for _, userID := range memberIDs {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      userID,
+			Role:        models.WorkspaceRoleMember,
+			Source:      models.WorkspaceSourceSCIM,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	query := tx.Where("workspace_id = ? AND source = ?", workspace.ID, models.WorkspaceSourceSCIM)
+	if len(memberIDs) > 0 {
+		query = query.Where("user_id NOT IN ?", memberIDs)
+	}
+	return query.Delete(&models.WorkspaceMember{}).Error
+}
+
+// scimGroupMemberIDs returns the user IDs of a group's members
+func scimGroupMemberIDs(groupID uint) []uint {
+	var userIDs []uint
+	database.DB.Model(&models.SCIMGroupMember{}).Where("group_id = ?", groupID).Pluck("user_id", &userIDs)
+	return userIDs
+}
+
+// scimMemberIDs reads the user IDs from a members value: a list of {"value": "<id>"} objects
+func scimMemberIDs(value interface{}) ([]uint, error) {
+	var refs []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		refs = v
+	case []models.SCIMMemberRef:
+		for _, ref := range v {
+			refs = append(refs, map[string]interface{}{"value": ref.Value})
+		}
+	case map[string]interface{}:
+		refs = []interface{}{v}
+	default:
+		return nil, fmt.Errorf("members must be a list: %w", errSCIMInvalidValue)
+	}
+
+	userIDs := make([]uint, 0, len(refs))
+	for _, ref := range refs {
+		object, _ := ref.(map[string]interface{})
+		id, ok := object["value"].(string)
+		if !ok {
+			return nil, fmt.Errorf("member value must be a user id: %w", errSCIMInvalidValue)
+		}
+		userID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unknown member %q: %w", id, errSCIMInvalidValue)
+		}
+		userIDs = append(userIDs, uint(userID))
+	}
+	return userIDs, nil
+}
+
+// scimMemberFilterID reads the user ID from a path such as members[value eq "42"]
+func scimMemberFilterID(path string) (uint, bool) {
+	if !strings.HasSuffix(path, "]") {
+		return 0, false
+	}
+	attribute, value, ok := parseSCIMFilter(strings.TrimSuffix(strings.TrimPrefix(path, "members["), "]"))
+	if !ok || attribute != "value" {
+		return 0, false
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// scimUserChangesFrom reads the attributes of a create or replace request
+func scimUserChangesFrom(req *models.SCIMUser) *scimUserChanges {
+	changes := &scimUserChanges{
+		userName:    &req.UserName,
+		displayName: &req.DisplayName,
+		active:      req.Active,
+	}
+	if req.ExternalID != "" {
+		changes.externalID = &req.ExternalID
+	}
+	if req.Name != nil {
+		changes.formatted = &req.Name.Formatted
+		changes.givenName = &req.Name.GivenName
+		changes.familyName = &req.Name.FamilyName
+	}
+	if email := primarySCIMEmail(req.Emails); email != "" {
+		changes.email = &email
+	}
+	if req.Password != "" {
+		changes.password = &req.Password
+	}
+	return changes
+}
+
+// applyPatch records the changes of one PATCH operation
+// Attributes we don't store (titles, phone numbers, extensions, ...) are ignored
+func (changes *scimUserChanges) applyPatch(op models.SCIMPatchOperation) error {
+	opName := strings.ToLower(op.Op)
+	if opName != "add" && opName != "replace" && opName != "remove" {
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+
+	if op.Path == "" {
+		object, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return errors.New("value must be an object when path is omitted")
+		}
+		for key, value := range object {
+			if err := changes.set(opName, scimAttributePath(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return changes.set(opName, scimAttributePath(op.Path), op.Value)
+}
+
+func (changes *scimUserChanges) set(op, path string, value interface{}) error {
+	if op == "remove" {
+		value = ""
+	}
+	str := func() (*string, error) {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a string", path)
+		}
+		return &s, nil
+	}
+
+	var err error
+	switch {
+	case path == "active":
+		if op == "remove" {
+			return nil
+		}
+		active, ok := scimBool(value)
+		if !ok {
+			return errors.New("active must be a boolean")
+		}
+		changes.active = &active
+	case path == "username":
+		if op == "remove" {
+			return errors.New("userName can't be removed")
+		}
+		changes.userName, err = str()
+	case path == "displayname":
+		changes.displayName, err = str()
+	case path == "externalid":
+		changes.externalID, err = str()
+	case path == "password":
+		changes.password, err = str()
+	case path == "name.formatted":
+		changes.formatted, err = str()
+	case path == "name.givenname":
+		changes.givenName, err = str()
+	case path == "name.familyname":
+		changes.familyName, err = str()
+	case path == "name":
+		object, _ := value.(map[string]interface{})
+		for key, v := range object {
+			if err := changes.set(op, "name."+strings.ToLower(key), v); err != nil {
+				return err
+			}
+		}
+	case path == "emails":
+		if op == "remove" {
+			return nil
+		}
+		list, _ := value.([]interface{})
+		var emails []models.SCIMEmail
+		for _, item := range list {
+			object, _ := item.(map[string]interface{})
+			address, _ := object["value"].(string)
+			primary, _ := scimBool(object["primary"])
+			emails = append(emails, models.SCIMEmail{Value: address, Primary: primary})
+		}
+		if email := primarySCIMEmail(emails); email != "" {
+			changes.email = &email
+		}
+	case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		// emails[type eq "work"].value; there is only one address
+		if op == "remove" {
+			return nil
+		}
+		changes.email, err = str()
+	}
+	return err
+}
+
+// resolveEmail returns the user's email: the userName when it is an email address, else the
+// primary email
+func (changes *scimUserChanges) resolveEmail() string {
+	if changes.userName != nil && strings.Contains(*changes.userName, "@") {
+		return strings.TrimSpace(*changes.userName)
+	}
+	if changes.email != nil && strings.Contains(*changes.email, "@") {
+		return strings.TrimSpace(*changes.email)
+	}
+	return ""
+}
+
+// resolveName returns the user's name: the display name, the formatted name or the given and
+// family names, falling back to current
+func (changes *scimUserChanges) resolveName(current string) string {
+	if changes.displayName != nil && strings.TrimSpace(*changes.displayName) != "" {
+		return strings.TrimSpace(*changes.displayName)
+	}
+	if changes.formatted != nil && strings.TrimSpace(*changes.formatted) != "" {
+		return strings.TrimSpace(*changes.formatted)
+	}
+	var parts []string
+	for _, part := range []*string{changes.givenName, changes.familyName} {
+		if part != nil && strings.TrimSpace(*part) != "" {
+			parts = append(parts, strings.TrimSpace(*part))
+		}
+	}
+	if len(parts) > 0 {
+		return strings.Join(parts, " ")
+	}
+	return current
+}
+
+// primarySCIMEmail returns the primary email address, or the first one
+func primarySCIMEmail(emails []models.SCIMEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// scimBool reads a boolean; some identity providers send "True" and "False" as strings
+func scimBool(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case string:
+		b, err := strconv.ParseBool(strings.ToLower(v))
+		return b, err == nil
+	}
+	return false, false
+}
+
+// scimAttributePath lowercases an attribute path and strips the core schema URN from it
+func scimAttributePath(path string) string {
+	path = strings.ToLower(strings.TrimSpace(path))
+	for _, schema := range []string{models.SCIMSchemaUser, models.SCIMSchemaGroup} {
+		path = strings.TrimPrefix(path, strings.ToLower(schema)+":")
+	}
+	return path
+}
+
+// parseSCIMFilter parses an "<attribute> eq <value>" filter, the only kind we support
+// The attribute is returned in lowercase
+func parseSCIMFilter(filter string) (string, string, bool) {
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", false
+	}
+	attribute := scimAttributePath(match[1])
+	if match[3] != "" {
+		return attribute, strings.ToLower(match[3]), true
+	}
+	value, err := strconv.Unquote(`"` + match[2] + `"`)
+	if err != nil {
+		return "", "", false
+	}
+	return attribute, value, true
+}
+
+// scimPage reads the 1-based startIndex and count query parameters
+func scimPage(c *gin.Context) (int, int) {
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.Query("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return startIndex, count
+}
+
+// findSCIMUser loads the user in the id parameter, responding with 404 if there is none
+func findSCIMUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || database.DB.First(&user, id).Error != nil {
+		middleware.SCIMError(c, http.StatusNotFound, "", "User not found")
+		return nil, false
+	}
+	return &user, true
+}
+
+// findSCIMGroup loads the group in the id parameter, responding with 404 if there is none
+func findSCIMGroup(c *gin.Context) (*models.SCIMGroup, bool) {
+	var group models.SCIMGroup
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || database.DB.First(&group, id).Error != nil {
+		middleware.SCIMError(c, http.StatusNotFound, "", "Group not found")
+		return nil, false
+	}
+	return &group, true
+}
+
+func toSCIMUser(c *gin.Context, user *models.User) models.SCIMUser {
+	active := user.IsActive()
+
+	var groups []models.SCIMGroup
+	database.DB.Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+		Where("scim_group_members.user_id = ?", user.ID).Order("scim_groups.id").Find(&groups)
+	groupRefs := make([]models.SCIMMemberRef, 0, len(groups))
+	for _, group := range groups {
+		groupRefs = append(groupRefs, models.SCIMMemberRef{
+			Value:   strconv.FormatUint(uint64(group.ID), 10),
+			Display: group.DisplayName,
+			Ref:     scimLocation(c, "Groups", group.ID),
+		})
+	}
+
+	return models.SCIMUser{
+		Schemas:     []string{models.SCIMSchemaUser},
+		ID:          strconv.FormatUint(uint64(user.ID), 10),
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &models.SCIMName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []models.SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      groupRefs,
+		Meta: &models.SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt.Format(time.RFC3339),
+			LastModified: user.UpdatedAt.Format(time.RFC3339),
+			Location:     scimLocation(c, "Users", user.ID),
+		},
+	}
+}
+
+func toSCIMGroup(c *gin.Context, group *models.SCIMGroup, withMembers bool) models.SCIMGroupResource {
+	resource := models.SCIMGroupResource{
+		Schemas:     []string{models.SCIMSchemaGroup},
+		ID:          strconv.FormatUint(uint64(group.ID), 10),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Meta: &models.SCIMMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt.Format(time.RFC3339),
+			LastModified: group.UpdatedAt.Format(time.RFC3339),
+			Location:     scimLocation(c, "Groups", group.ID),
+		},
+	}
+
+	if withMembers {
+		var users []models.User
+		database.DB.Joins("JOIN scim_group_members ON scim_group_members.user_id = users.id").
+			Where("scim_group_members.group_id = ?", group.ID).Order("users.id").Find(&users)
+		resource.Members = make([]models.SCIMMemberRef, 0, len(users))
+		for _, user := range users {
+			resource.Members = append(resource.Members, models.SCIMMemberRef{
+				Value:   strconv.FormatUint(uint64(user.ID), 10),
+				Display: user.Email,
+				Ref:     scimLocation(c, "Users", user.ID),
+			})
+		}
+	}
+
+	return resource
+}
+
+// scimLocation returns the URL of a SCIM resource
+func scimLocation(c *gin.Context, resourceType string, id uint) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%d", appBaseURL(c), resourceType, id)
+}
+
+// scimJSON responds with a SCIM resource, using the SCIM media type
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", "application/scim+json; charset=utf-8")
+	c.JSON(status, body)
+}
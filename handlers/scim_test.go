@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter    string
+		attribute string
+		value     string
+		ok        bool
+	}{
+		{`userName eq "alice@example.com"`, "username", "alice@example.com", true},
+		{`  userName   EQ   "alice@example.com"  `, "username", "alice@example.com", true},
+		{`externalId eq "00u1"`, "externalid", "00u1", true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bob"`, "username", "bob", true},
+		{`displayName eq "Team \"A\""`, "displayname", `Team "A"`, true},
+		{`active eq True`, "active", "true", true},
+		{`active eq false`, "active", "false", true},
+		{`userName eq ""`, "username", "", true},
+		{`userName co "alice"`, "", "", false},
+		{`userName eq alice`, "", "", false},
+		{`userName eq "a" and active eq true`, "", "", false},
+		{`userName eq "unterminated`, "", "", false},
+		{``, "", "", false},
+	} {
+		attribute, value, ok := parseSCIMFilter(tc.filter)
+		if ok != tc.ok || attribute != tc.attribute || value != tc.value {
+			t.Errorf("parseSCIMFilter(%q) = %q, %q, %v; want %q, %q, %v",
+				tc.filter, attribute, value, ok, tc.attribute, tc.value, tc.ok)
+		}
+	}
+}
+
+func TestSCIMMemberFilterID(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		id   uint
+		ok   bool
+	}{
+		{`members[value eq "42"]`, 42, true},
+		{`members[value eq "abc"]`, 0, false},
+		{`members[display eq "42"]`, 0, false},
+		{`members[value eq "42"`, 0, false},
+		{`members`, 0, false},
+	} {
+		id, ok := scimMemberFilterID(tc.path)
+		if id != tc.id || ok != tc.ok {
+			t.Errorf("scimMemberFilterID(%q) = %d, %v; want %d, %v", tc.path, id, ok, tc.id, tc.ok)
+		}
+	}
+}
+
+func TestSCIMUserPatchPaths(t *testing.T) {
+	str := func(s *string) string {
+		if s == nil {
+			return "<nil>"
+		}
+		return *s
+	}
+
+	for _, tc := range []struct {
+		name    string
+		op      models.SCIMPatchOperation
+		check   func(*scimUserChanges) bool
+		wantErr bool
+	}{
+		{
+			name:  "replace active with a string",
+			op:    models.SCIMPatchOperation{Op: "Replace", Path: "active", Value: "False"},
+			check: func(c *scimUserChanges) bool { return c.active != nil && !*c.active },
+		},
+		{
+			name: "replace without path",
+			op: models.SCIMPatchOperation{Op: "replace", Value: map[string]interface{}{
+				"active":      true,
+				"displayName": "Alice Doe",
+			}},
+			check: func(c *scimUserChanges) bool {
+				return c.active != nil && *c.active && str(c.displayName) == "Alice Doe"
+			},
+		},
+		{
+			name:  "schema-qualified path",
+			op:    models.SCIMPatchOperation{Op: "add", Path: models.SCIMSchemaUser + ":name.givenName", Value: "Alice"},
+			check: func(c *scimUserChanges) bool { return str(c.givenName) == "Alice" },
+		},
+		{
+			name: "name object",
+			op: models.SCIMPatchOperation{Op: "replace", Path: "name", Value: map[string]interface{}{
+				"givenName":  "Alice",
+				"familyName": "Doe",
+			}},
+			check: func(c *scimUserChanges) bool {
+				return str(c.givenName) == "Alice" && str(c.familyName) == "Doe"
+			},
+		},
+		{
+			name:  "email value filter",
+			op:    models.SCIMPatchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: "alice@example.com"},
+			check: func(c *scimUserChanges) bool { return str(c.email) == "alice@example.com" },
+		},
+		{
+			name: "emails list takes the primary address",
+			op: models.SCIMPatchOperation{Op: "replace", Path: "emails", Value: []interface{}{
+				map[string]interface{}{"value": "home@example.com"},
+				map[string]interface{}{"value": "work@example.com", "primary": "true"},
+			}},
+			check: func(c *scimUserChanges) bool { return str(c.email) == "work@example.com" },
+		},
+		{
+			name:  "remove display name",
+			op:    models.SCIMPatchOperation{Op: "remove", Path: "displayName"},
+			check: func(c *scimUserChanges) bool { return str(c.displayName) == "" },
+		},
+		{
+			name:  "unknown attributes are ignored",
+			op:    models.SCIMPatchOperation{Op: "replace", Path: "title", Value: "Engineer"},
+			check: func(c *scimUserChanges) bool { return *c == scimUserChanges{} },
+		},
+		{
+			name:    "userName can't be removed",
+			op:      models.SCIMPatchOperation{Op: "remove", Path: "userName"},
+			wantErr: true,
+		},
+		{
+			name:    "active must be a boolean",
+			op:      models.SCIMPatchOperation{Op: "replace", Path: "active", Value: "maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "object value needs an object",
+			op:      models.SCIMPatchOperation{Op: "replace", Value: "Alice"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported operation",
+			op:      models.SCIMPatchOperation{Op: "move", Path: "displayName", Value: "Alice"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var changes scimUserChanges
+			err := changes.applyPatch(tc.op)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("applyPatch error = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.check != nil && !tc.check(&changes) {
+				t.Errorf("applyPatch(%+v) gave %+v", tc.op, changes)
+			}
+		})
+	}
+}
+
+func TestSCIMDeleteUserAllowsReprovisioning(t *testing.T) {
+	r := gin.New()
+	r.POST("/Users", SCIMCreateUser)
+	r.DELETE("/Users/:id", SCIMDeleteUser)
+
+	create := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.SCIMUser{
+			Schemas:    []string{models.SCIMSchemaUser},
+			UserName:   "scim-reprovisioned@example.com",
+			ExternalID: "00u-reprovisioned",
+		})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/Users", bytes.NewReader(body)))
+		return w
+	}
+
+	w := create()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body.String())
+	}
+	var created models.SCIMUser
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/Users/"+created.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var remaining int64
+	database.DB.Unscoped().Model(&models.User{}).Where("id = ?", created.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("the deleted user is still stored")
+	}
+
+	if w := create(); w.Code != http.StatusCreated {
+		t.Errorf("provisioning the same user again: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSCIMGroupWorkspace(t *testing.T) {
+	alice := createTestUser(t, "scim-group-alice@example.com")
+	bob := createTestUser(t, "scim-group-bob@example.com")
+	carol := createTestUser(t, "scim-group-carol@example.com")
+
+	r := gin.New()
+	r.POST("/Groups", SCIMCreateGroup)
+	r.PATCH("/Groups/:id", SCIMPatchGroup)
+	r.DELETE("/Groups/:id", SCIMDeleteGroup)
+	members := func(users ...*models.User) string {
+		values := make([]string, len(users))
+		for i, user := range users {
+			values[i] = fmt.Sprintf(`{"value": "%d"}`, user.ID)
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+
+	w := serveJSON(r, http.MethodPost, "/Groups", `{"displayName": "SCIM Platform", "members": `+members(alice, bob)+`}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create group: status %d: %s", w.Code, w.Body.String())
+	}
+	var group models.SCIMGroup
+	database.DB.Where("display_name = ?", "SCIM Platform").First(&group)
+	if group.WorkspaceID == 0 {
+		t.Fatal("group created without a workspace")
+	}
+	if workspaces := userWorkspaces(t, alice.ID); workspaces["SCIM Platform"] != models.WorkspaceSourceSCIM {
+		t.Errorf("workspaces of a group member = %v", workspaces)
+	}
+
+	// An administrator also adds Carol, who stays when the group leaves her out
+	if err := setWorkspaceMember(database.DB, group.WorkspaceID, carol.ID, models.WorkspaceRoleAdmin, models.WorkspaceSourceManual); err != nil {
+		t.Fatal(err)
+	}
+	patch := func(operations string) {
+		t.Helper()
+		w := serveJSON(r, http.MethodPatch, fmt.Sprintf("/Groups/%d", group.ID), `{"schemas": ["`+models.SCIMSchemaPatchOp+`"], "Operations": `+operations+`}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("patch group: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	patch(`[{"op": "replace", "path": "displayName", "value": "SCIM Platform Team"}, {"op": "remove", "path": "members[value eq \"` + fmt.Sprint(bob.ID) + `\"]"}]`)
+
+	var workspace models.Workspace
+	database.DB.First(&workspace, group.WorkspaceID)
+	if workspace.Name != "SCIM Platform Team" {
+		t.Errorf("workspace name = %q after renaming the group", workspace.Name)
+	}
+	if workspaces := userWorkspaces(t, bob.ID); len(workspaces) != 0 {
+		t.Errorf("workspaces of a removed member = %v", workspaces)
+	}
+	if role := workspaceRole(t, group.WorkspaceID, carol.ID); role != models.WorkspaceRoleAdmin {
+		t.Errorf("role set by an administrator changed to %s", role)
+	}
+
+	// Adding a member the administrator already added keeps their role and source
+	patch(`[{"op": "add", "path": "members", "value": ` + members(carol) + `}]`)
+	if workspaces := userWorkspaces(t, carol.ID); workspaces["SCIM Platform Team"] != models.WorkspaceSourceManual {
+		t.Errorf("workspaces of a member added by an administrator = %v", workspaces)
+	}
+
+	if w := serveJSON(r, http.MethodDelete, fmt.Sprintf("/Groups/%d", group.ID), ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete group: status %d: %s", w.Code, w.Body.String())
+	}
+	if workspaces := userWorkspaces(t, alice.ID); len(workspaces) != 0 {
+		t.Errorf("workspaces of a member of a deleted group = %v", workspaces)
+	}
+	if workspaces := userWorkspaces(t, carol.ID); len(workspaces) != 1 {
+		t.Errorf("member added by an administrator left the workspace of a deleted group: %v", workspaces)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSearchResultsPerPage = 50
+	maxSearchResultsPerPage     = 200
+)
+
+// Search finds diagrams, tables, fields, comments and notes in the latest versions of the user's diagrams
+// ?q= is required; every word must match, the last one as a prefix. Narrowed down with ?type= (comma-separated
+// entity types) and ?diagram_id=; paginated with ?page= and ?per_page=. Best matches come first
+func Search(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	match := searchMatchExpression(c.Query("q"))
+	if match == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	query := database.DB.Table("search_index").
+		Joins("JOIN search_entries ON search_entries.id = search_index.rowid").
+		Joins("JOIN diagrams ON diagrams.id = search_entries.diagram_id").
+		Where("search_index MATCH ?", match).
+		Where("diagrams.user_id = ? AND diagrams.deleted_at IS NULL", userID)
+	if types := c.Query("type"); types != "" {
+		query = query.Where("search_entries.entity_type IN ?", splitList(types))
+	}
+	if diagramID := c.Query("diagram_id"); diagramID != "" {
+		query = query.Where("diagrams.diagram_id = ?", diagramID)
+	}
+
+	page, perPage := pagination(c, defaultSearchResultsPerPage, maxSearchResultsPerPage)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	results := []models.SearchResult{}
+	if err := query.Select(`diagrams.diagram_id, diagrams.name AS diagram_name, search_entries.entity_type,
+		search_entries.path, snippet(search_index, 0, '', '', '…', 16) AS text`).
+		Order("rank").Offset((page - 1) * perPage).Limit(perPage).Scan(&results).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SearchResponse{
+		Results: results,
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	})
+}
+
+// searchMatchExpression turns a search query into an FTS5 expression that matches entries
+// containing every word, the last one as a prefix; words are quoted so operators are taken literally
+func searchMatchExpression(q string) string {
+	words := strings.Fields(q)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	words[len(words)-1] += "*"
+	return strings.Join(words, " ")
+}
+
+// indexDiagram updates the search index of a diagram after a write
+// A failure is logged rather than failing the write; the next write indexes the diagram again
+func indexDiagram(tx *gorm.DB, diagram *models.Diagram, data string) {
+	if err := database.IndexDiagram(tx, diagram, data); err != nil {
+		log.Printf("Warning: failed to update the search index of diagram %s: %v", diagram.DiagramID, err)
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+func TestSearchMatchExpression(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"orders", `"orders"*`},
+		{"  customer   id ", `"customer" "id"*`},
+		{"NOT orders", `"NOT" "orders"*`},
+		{`say "hi"`, `"say" """hi"""*`},
+		{"col:value", `"col:value"*`},
+		{"a* OR b", `"a*" "OR" "b"*`},
+	} {
+		if got := searchMatchExpression(tc.q); got != tc.want {
+			t.Errorf("searchMatchExpression(%q) = %s, want %s", tc.q, got, tc.want)
+		}
+	}
+}
+
+const searchTestDiagram = `{
+	"name": "Shop",
+	"tables": [
+		{"name": "orders", "schema": "public", "comments": "Placed by customers", "fields": [
+			{"name": "id"},
+			{"name": "customer_id", "comments": "Who placed the order"}
+		]},
+		{"name": "order_totals", "isView": true, "fields": [{"name": "total"}]}
+	],
+	"notes": [{"content": "Invoices live in another service"}]
+}`
+
+// createSearchTestDiagram stores a diagram with a single version and indexes it
+func createSearchTestDiagram(t *testing.T, user *models.User, diagramID, data string) *models.Diagram {
+	t.Helper()
+
+	diagram := models.Diagram{DiagramID: diagramID, UserID: user.ID, Name: "Shop"}
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&diagram).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.DiagramVersion{DiagramID: diagram.ID, Version: 1, Data: data}).Error; err != nil {
+			return err
+		}
+		return database.IndexDiagram(tx, &diagram, data)
+	}); err != nil {
+		t.Fatalf("create diagram: %v", err)
+	}
+	return &diagram
+}
+
+// search calls the search endpoint as the user
+func search(t *testing.T, user *models.User, query url.Values) models.SearchResponse {
+	t.Helper()
+
+	r := gin.New()
+	r.GET("/search", func(c *gin.Context) { c.Set("user_id", user.ID) }, Search)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("search %v: status %d: %s", query, w.Code, w.Body.String())
+	}
+
+	var response models.SearchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func searchPaths(response models.SearchResponse) map[string]string {
+	paths := make(map[string]string)
+	for _, result := range response.Results {
+		paths[result.EntityType+" "+result.Path] = result.Text
+	}
+	return paths
+}
+
+func searchEntryCount(t *testing.T, diagram *models.Diagram) int64 {
+	t.Helper()
+
+	var count int64
+	if err := database.DB.Table("search_entries").Where("diagram_id = ?", diagram.ID).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestSearchIndexesDiagramContents(t *testing.T) {
+	user := createTestUser(t, "search@example.com")
+	other := createTestUser(t, "search-other@example.com")
+	diagram := createSearchTestDiagram(t, user, "search-shop", searchTestDiagram)
+	createSearchTestDiagram(t, other, "search-other-shop", searchTestDiagram)
+
+	// Name, schema-qualified table, view, fields, comments and notes are indexed
+	if got := searchEntryCount(t, diagram); got != 9 {
+		t.Errorf("indexed %d entries, want 9", got)
+	}
+
+	paths := searchPaths(search(t, user, url.Values{"q": {"order"}}))
+	for _, want := range []string{"table public.orders", "view order_totals", "comment public.orders.customer_id"} {
+		if _, ok := paths[want]; !ok {
+			t.Errorf("prefix search is missing %q, got %v", want, paths)
+		}
+	}
+	if len(paths) != 3 {
+		t.Errorf("search returned %v, want only the user's own diagram", paths)
+	}
+
+	paths = searchPaths(search(t, user, url.Values{"q": {"invoices service"}}))
+	if paths["note Note 1"] != "Invoices live in another service" {
+		t.Errorf("note search returned %v", paths)
+	}
+
+	paths = searchPaths(search(t, user, url.Values{"q": {"customer"}, "type": {"field"}}))
+	if len(paths) != 1 || paths["field public.orders.customer_id"] != "customer_id" {
+		t.Errorf("field search returned %v", paths)
+	}
+
+	// Indexing a new version replaces the entries of the previous one
+	if err := database.IndexDiagram(database.DB, diagram, `{"tables": [{"name": "invoices", "fields": []}]}`); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchEntryCount(t, diagram); got != 2 {
+		t.Errorf("reindexed %d entries, want 2", got)
+	}
+	if response := search(t, user, url.Values{"q": {"orders"}}); response.Total != 0 {
+		t.Errorf("entries of the previous version are still found: %+v", response.Results)
+	}
+	if response := search(t, user, url.Values{"q": {"invoices"}}); response.Total != 1 {
+		t.Errorf("search after reindexing returned %+v", response.Results)
+	}
+}
+
+func TestPurgeUserRemovesSearchEntries(t *testing.T) {
+	user := createTestUser(t, "search-purge@example.com")
+	diagram := createSearchTestDiagram(t, user, "search-purged-shop", searchTestDiagram)
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeUser(tx, user.ID)
+	}); err != nil {
+		t.Fatalf("purge user: %v", err)
+	}
+
+	if got := searchEntryCount(t, diagram); got != 0 {
+		t.Errorf("%d search entries left after purging the account", got)
+	}
+
+	var matches int64
+	if err := database.DB.Table("search_index").Where("search_index MATCH ?", searchMatchExpression("invoices")).
+		Where("rowid NOT IN (SELECT id FROM search_entries)").Count(&matches).Error; err != nil {
+		t.Fatal(err)
+	}
+	if matches != 0 {
+		t.Errorf("%d orphaned full-text entries left after purging the account", matches)
+	}
+}
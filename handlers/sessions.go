@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// ListSessions returns the active sessions of the current user
+func ListSessions(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	currentSessionID := middleware.GetSessionID(c)
+
+	var sessions []models.Session
+	if err := database.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	response := make([]models.SessionResponse, len(sessions))
+	for i, s := range sessions {
+		response[i] = models.SessionResponse{
+			ID:         s.ID,
+			Device:     s.Device,
+			IPAddress:  s.IPAddress,
+			UserAgent:  s.UserAgent,
+			Current:    s.ID == currentSessionID,
+			CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: s.LastSeenAt.Format(time.RFC3339),
+			ExpiresAt:  s.ExpiresAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession signs out one session of the current user
+func RevokeSession(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	sessionID := c.Param("sessionId")
+
+	var session models.Session
+	if err := database.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := middleware.RevokeSession(session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	// Revoking the current session is a logout
+	if session.ID == middleware.GetSessionID(c) {
+		middleware.ClearSessionCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions signs out every session of the current user except this one
+func RevokeOtherSessions(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	currentSessionID := middleware.GetSessionID(c)
+
+	count, err := middleware.RevokeUserSessions(userID, currentSessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Other sessions revoked successfully",
+		"count":   count,
+	})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+// GetSettings returns the server settings (admin only)
+func GetSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, models.SettingsResponse{
+		RequireTwoFactor:   database.GetBoolSetting(models.SettingRequireTwoFactor),
+		MaxSessionsPerUser: middleware.MaxSessionsPerUser(),
+	})
+}
+
+// UpdateSettings changes server settings (admin only)
+func UpdateSettings(c *gin.Context) {
+	var req models.UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RequireTwoFactor != nil {
+		if err := database.SetBoolSetting(models.SettingRequireTwoFactor, *req.RequireTwoFactor); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+			return
+		}
+		middleware.Audit(c, models.AuditEvent{
+			Action:  models.AuditSettingsChanged,
+			Details: map[string]interface{}{models.SettingRequireTwoFactor: *req.RequireTwoFactor},
+		})
+	}
+
+	// Lowering the cap ends surplus sessions the next time a user signs in
+	if req.MaxSessionsPerUser != nil {
+		if err := database.SetIntSetting(models.SettingMaxSessionsPerUser, *req.MaxSessionsPerUser); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+			return
+		}
+		middleware.Audit(c, models.AuditEvent{
+			Action:  models.AuditSettingsChanged,
+			Details: map[string]interface{}{models.SettingMaxSessionsPerUser: *req.MaxSessionsPerUser},
+		})
+	}
+
+	GetSettings(c)
+}
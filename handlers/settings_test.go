@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+func TestUpdateSettingsRefreshesCachedValue(t *testing.T) {
+	r := gin.New()
+	r.PUT("/settings", UpdateSettings)
+
+	update := func(body string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/settings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("update settings: status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// Reading first caches the value, which every write must replace
+	if database.GetBoolSetting(models.SettingRequireTwoFactor) {
+		t.Fatal("two-factor authentication is required before it was enabled")
+	}
+	update(`{"require_two_factor": true}`)
+	if !database.GetBoolSetting(models.SettingRequireTwoFactor) {
+		t.Error("enabling the setting was not picked up")
+	}
+	update(`{"require_two_factor": false}`)
+	if database.GetBoolSetting(models.SettingRequireTwoFactor) {
+		t.Error("disabling the setting was not picked up")
+	}
+}
+
+func TestMaxSessionsPerUserSetting(t *testing.T) {
+	r := gin.New()
+	r.PUT("/settings", UpdateSettings)
+	defer database.SetIntSetting(models.SettingMaxSessionsPerUser, 0)
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"max_sessions_per_user": 2}`, http.StatusOK},
+		{`{"max_sessions_per_user": -1}`, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/settings", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Fatalf("%s: status %d, want %d: %s", tc.body, w.Code, tc.code, w.Body.String())
+		}
+	}
+
+	// Signing in a third time ends the least recently used session
+	user := createTestUser(t, "session-cap@example.com")
+	for i := 0; i < 3; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+		if _, err := middleware.CreateSession(c, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int64
+	database.DB.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("%d sessions after signing in 3 times, want 2", count)
+	}
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	shareTokenPrefix    = "shr_"
+	sharePasswordHeader = "X-Share-Password"
+	defaultShareLinkTTL = 30 * 24 * time.Hour
+)
+
+var (
+	errShareNotFound         = errors.New("share link not found or expired")
+	errSharePasswordRequired = errors.New("password required")
+	errShareVersionGone      = errors.New("shared version is no longer available")
+)
+
+//go:embed templates/share.html
+var shareTemplateSource string
+
+var shareTemplate = template.Must(template.New("share").Parse(shareTemplateSource))
+
+// CreateShareLink creates a public read-only link for a diagram
+func CreateShareLink(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var req models.CreateShareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Make sure a pinned version actually exists
+	if req.Version != nil {
+		var count int64
+		database.DB.Model(&models.DiagramVersion{}).Where("diagram_id = ? AND version = ?", diagram.ID, *req.Version).Count(&count)
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Version not found"})
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(defaultShareLinkTTL)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	token, err := middleware.GenerateOpaqueToken(shareTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	link := models.ShareLink{
+		DiagramID:   diagram.ID,
+		UserID:      userID,
+		TokenHash:   middleware.HashOpaqueToken(token),
+		TokenPrefix: token[:len(shareTokenPrefix)+6],
+		Label:       req.Label,
+		Version:     req.Version,
+		ExpiresAt:   &expiresAt,
+	}
+
+	if req.Password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		link.PasswordHash = string(hashedPassword)
+	}
+
+	if err := database.DB.Create(&link).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	middleware.AuditDiagram(c, models.AuditShareLinkCreated, &diagram, map[string]interface{}{
+		"share_id":     link.ID,
+		"token_prefix": link.TokenPrefix,
+		"version":      link.Version,
+		"has_password": link.PasswordHash != "",
+		"expires_at":   expiresAt.Format(time.RFC3339),
+	})
+
+	// The raw token is only ever returned here
+	response := toShareLinkResponse(link)
+	response.Token = token
+	response.URL = "/sync/share/" + token
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListShareLinks returns all share links of a diagram
+func ListShareLinks(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var links []models.ShareLink
+	if err := database.DB.Where("diagram_id = ?", diagram.ID).Order("created_at desc").Find(&links).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share links"})
+		return
+	}
+
+	response := make([]models.ShareLinkResponse, len(links))
+	for i, link := range links {
+		response[i] = toShareLinkResponse(link)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeShareLink revokes a share link so it can no longer be opened
+func RevokeShareLink(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+	shareID := c.Param("shareId")
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var link models.ShareLink
+	if err := database.DB.Where("id = ? AND diagram_id = ?", shareID, diagram.ID).First(&link).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		if err := database.DB.Save(&link).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+			return
+		}
+		middleware.AuditDiagram(c, models.AuditShareLinkRevoked, &diagram, map[string]interface{}{
+			"share_id":     link.ID,
+			"token_prefix": link.TokenPrefix,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
+}
+
+// GetSharedDiagram returns the JSON of a shared diagram (public)
+// Password-protected links expect the password in the X-Share-Password header
+func GetSharedDiagram(c *gin.Context) {
+	setShareHeaders(c)
+
+	password := c.GetHeader(sharePasswordHeader)
+	link, _, version, err := resolveShareLink(c.Param("token"), password)
+	if err != nil {
+		recordSharePasswordFailure(c, err, password)
+		c.JSON(shareErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(version.Data), &data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse diagram data"})
+		return
+	}
+
+	data["version"] = version.Version
+	countShareView(link)
+	c.JSON(http.StatusOK, data)
+}
+
+// ViewSharedDiagram renders a read-only HTML view of a shared diagram (public)
+// The password of protected links is submitted through the form on the page
+func ViewSharedDiagram(c *gin.Context) {
+	setShareHeaders(c)
+	token := c.Param("token")
+	password := c.PostForm("password")
+
+	link, diagram, version, err := resolveShareLink(token, password)
+	if err != nil {
+		recordSharePasswordFailure(c, err, password)
+		page := sharePage{Token: token, Error: err.Error()}
+		if err == errSharePasswordRequired {
+			page.PasswordRequired = true
+			page.Error = ""
+			if password != "" {
+				page.Error = "Incorrect password"
+			}
+		}
+		renderSharePage(c, shareErrorStatus(err), page)
+		return
+	}
+
+	content, err := models.ParseDiagramContent(version.Data)
+	if err != nil {
+		renderSharePage(c, http.StatusInternalServerError, sharePage{Token: token, Error: "Failed to parse diagram data"})
+		return
+	}
+
+	page := buildSharePage(content)
+	page.Token = token
+	page.Name = diagram.Name
+	page.DatabaseType = diagram.DatabaseType
+	page.Version = version.Version
+	page.UpdatedAt = version.CreatedAt.Format(time.RFC1123)
+	page.JSONAvailable = password == ""
+	countShareView(link)
+	renderSharePage(c, http.StatusOK, page)
+}
+
+// resolveShareLink validates a share token and password and loads the shared version
+// It doesn't count a view, the handlers do that once the diagram is served
+func resolveShareLink(token, password string) (*models.ShareLink, *models.Diagram, *models.DiagramVersion, error) {
+	if token == "" {
+		return nil, nil, nil, errShareNotFound
+	}
+
+	var link models.ShareLink
+	if err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(token)).First(&link).Error; err != nil {
+		return nil, nil, nil, errShareNotFound
+	}
+	if !link.IsActive() {
+		return nil, nil, nil, errShareNotFound
+	}
+
+	if link.PasswordHash != "" {
+		if password == "" || bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return nil, nil, nil, errSharePasswordRequired
+		}
+	}
+
+	// Soft-deleted diagrams are excluded by the default scope
+	var diagram models.Diagram
+	if err := database.DB.First(&diagram, link.DiagramID).Error; err != nil {
+		return nil, nil, nil, errShareNotFound
+	}
+
+	var version models.DiagramVersion
+	query := database.DB.Where("diagram_id = ?", diagram.ID)
+	if link.Version != nil {
+		query = query.Where("version = ?", *link.Version)
+	} else {
+		query = query.Order("version desc")
+	}
+	if err := query.First(&version).Error; err != nil {
+		return nil, nil, nil, errShareVersionGone
+	}
+
+	return &link, &diagram, &version, nil
+}
+
+// countShareView records a view of a share link
+func countShareView(link *models.ShareLink) {
+	database.DB.Model(link).Updates(map[string]interface{}{
+		"view_count":     gorm.Expr("view_count + 1"),
+		"last_viewed_at": time.Now(),
+	})
+}
+
+// recordSharePasswordFailure reports a wrong share link password to AuthRateLimit
+func recordSharePasswordFailure(c *gin.Context, err error, password string) {
+	if err == errSharePasswordRequired && password != "" {
+		middleware.RecordAuthFailure(c)
+	}
+}
+
+func shareErrorStatus(err error) int {
+	switch err {
+	case errSharePasswordRequired:
+		return http.StatusUnauthorized
+	case errShareVersionGone:
+		return http.StatusGone
+	default:
+		return http.StatusNotFound
+	}
+}
+
+// setShareHeaders keeps shared pages out of caches, search engines and referrers
+func setShareHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex, nofollow")
+	c.Header("Referrer-Policy", "no-referrer")
+}
+
+func toShareLinkResponse(link models.ShareLink) models.ShareLinkResponse {
+	return models.ShareLinkResponse{
+		ID:           link.ID,
+		TokenPrefix:  link.TokenPrefix,
+		Label:        link.Label,
+		Version:      link.Version,
+		HasPassword:  link.PasswordHash != "",
+		ExpiresAt:    formatOptionalTime(link.ExpiresAt),
+		RevokedAt:    formatOptionalTime(link.RevokedAt),
+		Active:       link.IsActive(),
+		ViewCount:    link.ViewCount,
+		LastViewedAt: formatOptionalTime(link.LastViewedAt),
+		CreatedAt:    link.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+// sharePage is the view model of templates/share.html
+type sharePage struct {
+	Token            string
+	Error            string
+	PasswordRequired bool
+	JSONAvailable    bool
+	Name             string
+	DatabaseType     string
+	Version          int
+	UpdatedAt        string
+	Tables           []shareTableView
+	Relationships    []string
+	Notes            []string
+}
+
+type shareTableView struct {
+	Name     string
+	Comments string
+	IsView   bool
+	Fields   []shareFieldView
+}
+
+type shareFieldView struct {
+	Name       string
+	Type       string
+	PrimaryKey bool
+	Unique     bool
+	Nullable   bool
+	References string
+	Comments   string
+}
+
+// buildSharePage resolves table and field IDs into readable names for the HTML view
+func buildSharePage(content *models.DiagramContent) sharePage {
+	tableNames := make(map[string]string)
+	fieldNames := make(map[string]string)
+	for _, table := range content.Tables {
+		name := table.Name
+		if table.Schema != "" {
+			name = table.Schema + "." + table.Name
+		}
+		tableNames[table.ID] = name
+		for _, field := range table.Fields {
+			fieldNames[field.ID] = field.Name
+		}
+	}
+
+	references := make(map[string]string)
+	var page sharePage
+	for _, rel := range content.Relationships {
+		source := tableNames[rel.SourceTableID] + "." + fieldNames[rel.SourceFieldID]
+		target := tableNames[rel.TargetTableID] + "." + fieldNames[rel.TargetFieldID]
+		references[rel.SourceFieldID] = target
+		page.Relationships = append(page.Relationships, source+" → "+target)
+	}
+
+	for _, table := range content.Tables {
+		view := shareTableView{
+			Name:     tableNames[table.ID],
+			Comments: table.Comments,
+			IsView:   table.IsView,
+		}
+		for _, field := range table.Fields {
+			view.Fields = append(view.Fields, shareFieldView{
+				Name:       field.Name,
+				Type:       field.Type.Name,
+				PrimaryKey: field.PrimaryKey,
+				Unique:     field.Unique,
+				Nullable:   field.Nullable,
+				References: references[field.ID],
+				Comments:   field.Comments,
+			})
+		}
+		page.Tables = append(page.Tables, view)
+	}
+
+	for _, note := range content.Notes {
+		if note.Content != "" {
+			page.Notes = append(page.Notes, note.Content)
+		}
+	}
+
+	return page
+}
+
+func renderSharePage(c *gin.Context, status int, page sharePage) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := shareTemplate.Execute(c.Writer, page); err != nil {
+		c.Error(err)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSharedDiagramPasswordViews(t *testing.T) {
+	user := createTestUser(t, "share-password@example.com")
+	diagram := createSearchTestDiagram(t, user, "share-password", searchTestDiagram)
+
+	token := "shr_password-test"
+	hash, err := bcrypt.GenerateFromPassword([]byte("letmein"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := models.ShareLink{
+		DiagramID:    diagram.ID,
+		UserID:       user.ID,
+		TokenHash:    middleware.HashOpaqueToken(token),
+		PasswordHash: string(hash),
+	}
+	if err := database.DB.Create(&link).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	const clientIP = "198.51.100.26"
+	defer middleware.RateLimiter.ResetFailures("ip:" + clientIP)
+
+	r := gin.New()
+	r.GET("/share/:token", ViewSharedDiagram)
+	r.POST("/share/:token", middleware.AuthRateLimit("share_password", nil), ViewSharedDiagram)
+	r.GET("/api/share/:token", middleware.AuthRateLimit("share_password", nil), GetSharedDiagram)
+
+	send := func(req *http.Request) int {
+		t.Helper()
+		req.RemoteAddr = clientIP + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	submit := func(password string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/share/"+token, strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return send(req)
+	}
+	views := func() int {
+		t.Helper()
+		var current models.ShareLink
+		database.DB.First(&current, link.ID)
+		return current.ViewCount
+	}
+
+	if code := send(httptest.NewRequest(http.MethodGet, "/share/"+token, nil)); code != http.StatusUnauthorized {
+		t.Fatalf("password form: status %d", code)
+	}
+	if code := submit("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d", code)
+	}
+	if views() != 0 {
+		t.Errorf("view count = %d before the diagram was shown", views())
+	}
+	if failures := middleware.RateLimiter.Failures("ip:"+clientIP, time.Now()).Count; failures != 1 {
+		t.Errorf("%d failed attempts recorded for a wrong password, want 1", failures)
+	}
+
+	if code := submit("letmein"); code != http.StatusOK {
+		t.Fatalf("correct password: status %d", code)
+	}
+	if views() != 1 {
+		t.Errorf("view count = %d after one view, want 1", views())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/share/"+token, nil)
+	req.Header.Set(sharePasswordHeader, "letmein")
+	if code := send(req); code != http.StatusOK {
+		t.Fatalf("JSON with password: status %d", code)
+	}
+	if views() != 2 {
+		t.Errorf("view count = %d after two views, want 2", views())
+	}
+}
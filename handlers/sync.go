@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,11 +15,136 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	defaultDiagramsPerPage = 50
+	maxDiagramsPerPage     = 200
+	defaultVersionsPerPage = 50
+	maxVersionsPerPage     = 200
+)
+
+// diagramSortColumns are the columns diagrams can be sorted by with ?sort=
+var diagramSortColumns = map[string]bool{
+	"name":               true,
+	"created_at":         true,
+	"updated_at":         true,
+	"version":            true,
+	"table_count":        true,
+	"relationship_count": true,
+}
+
+// sortOrder reads ?order=asc|desc, responding with an error if it is neither
+func sortOrder(c *gin.Context, defaultOrder string) (string, bool) {
+	order := c.DefaultQuery("order", defaultOrder)
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return "", false
+	}
+	return order, true
+}
+
+// paginateList limits a list query to the page in ?page= and ?per_page=, if either is given
+// The body stays a plain array for existing clients; the total and the page are reported in the
+// X-Total-Count, X-Page and X-Per-Page headers
+func paginateList(c *gin.Context, query *gorm.DB, total int64, defaultPerPage, maxPerPage int) *gorm.DB {
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	if c.Query("page") == "" && c.Query("per_page") == "" {
+		return query
+	}
+
+	page, perPage := pagination(c, defaultPerPage, maxPerPage)
+	c.Header("X-Page", strconv.Itoa(page))
+	c.Header("X-Per-Page", strconv.Itoa(perPage))
+	return query.Offset((page - 1) * perPage).Limit(perPage)
+}
+
+// splitList splits a comma-separated query parameter, dropping blanks around and between the values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// toDiagramListResponse describes a diagram with its tags
+func toDiagramListResponse(d models.Diagram, tags []string) models.DiagramListResponse {
+	if tags == nil {
+		tags = []string{}
+	}
+	return models.DiagramListResponse{
+		ID:                d.ID,
+		DiagramID:         d.DiagramID,
+		Name:              d.Name,
+		DatabaseType:      d.DatabaseType,
+		Version:           d.Version,
+		TableCount:        d.TableCount,
+		RelationshipCount: d.RelationshipCount,
+		Tags:              tags,
+		CreatedAt:         d.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         d.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// pushSource returns the source to record for a pushed version
+// Clients may declare it with ?source=; pushes made with a personal access token default to api
+func pushSource(c *gin.Context) (string, bool) {
+	switch source := c.Query("source"); source {
+	case "":
+		if middleware.IsTokenRequest(c) {
+			return models.VersionSourceAPI, true
+		}
+		return models.VersionSourcePush, true
+	case models.VersionSourcePush, models.VersionSourceRestore, models.VersionSourceImport, models.VersionSourceAPI:
+		return source, true
+	}
+	return "", false
+}
+
+// diagramIDTaken responds with a conflict when another account owns a diagram with this ID
+// ChartDB keeps the ID of a diagram after it has been transferred away, so the previous owner's
+// next push or sync would otherwise try to create it again
+func diagramIDTaken(c *gin.Context, tx *gorm.DB, diagramID string, userID uint) bool {
+	var existing models.Diagram
+	if err := tx.Unscoped().Where("diagram_id = ?", diagramID).First(&existing).Error; err != nil {
+		return false
+	}
+
+	message := "A diagram with this ID belongs to another account"
+	var transfer models.DiagramTransfer
+	if tx.Where("diagram_id = ? AND from_user_id = ?", existing.ID, userID).First(&transfer).Error == nil {
+		message = "This diagram was transferred to another account and can no longer be saved from here"
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": message})
+	return true
+}
+
+// newDiagramVersion builds a version attributed to the user and client of the request
+func newDiagramVersion(c *gin.Context, diagramID uint, version int, data, description, source string) models.DiagramVersion {
+	authorID := middleware.GetUserID(c)
+	return models.DiagramVersion{
+		DiagramID:   diagramID,
+		Version:     version,
+		Data:        data,
+		Description: description,
+		AuthorID:    &authorID,
+		Client:      middleware.ClientName(c),
+		Source:      source,
+	}
+}
+
 // PushDiagram saves a diagram from the browser to the database
 // Creates a new version for each push
 func PushDiagram(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 
+	source, ok := pushSource(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be one of push, restore, import or api"})
+		return
+	}
+
 	var req models.DiagramJSONRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,14 +170,21 @@ func PushDiagram(c *gin.Context) {
 	err = tx.Unscoped().Where("diagram_id = ? AND user_id = ?", req.ID, userID).First(&diagram).Error
 
 	if err == gorm.ErrRecordNotFound {
+		if diagramIDTaken(c, tx, req.ID, userID) {
+			tx.Rollback()
+			return
+		}
+
 		// Create new diagram
 		diagram = models.Diagram{
-			DiagramID:       req.ID,
-			UserID:          userID,
-			Name:            req.Name,
-			DatabaseType:    req.DatabaseType,
-			DatabaseEdition: req.DatabaseEdition,
-			Version:         1,
+			DiagramID:         req.ID,
+			UserID:            userID,
+			Name:              req.Name,
+			DatabaseType:      req.DatabaseType,
+			DatabaseEdition:   req.DatabaseEdition,
+			Version:           1,
+			TableCount:        len(req.Tables),
+			RelationshipCount: len(req.Relationships),
 		}
 		if err := tx.Create(&diagram).Error; err != nil {
 			tx.Rollback()
@@ -60,19 +193,16 @@ func PushDiagram(c *gin.Context) {
 		}
 
 		// Create version 1
-		version := models.DiagramVersion{
-			DiagramID:   diagram.ID,
-			Version:     1,
-			Data:        string(jsonData),
-			Description: req.Description,
-		}
+		version := newDiagramVersion(c, diagram.ID, 1, string(jsonData), req.Description, source)
 		if err := tx.Create(&version).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create version"})
 			return
 		}
 
+		indexDiagram(tx, &diagram, string(jsonData))
 		tx.Commit()
+		middleware.AuditDiagram(c, models.AuditDiagramPushed, &diagram, map[string]interface{}{"version": 1, "created": true, "source": source})
 		c.JSON(http.StatusCreated, gin.H{
 			"message":    "Diagram created successfully",
 			"diagram_id": diagram.DiagramID,
@@ -87,17 +217,16 @@ func PushDiagram(c *gin.Context) {
 		return
 	}
 
-	// Handle soft-deleted diagram restoration
-	if diagram.DeletedAt.Valid {
-		diagram.DeletedAt = gorm.DeletedAt{}
-		diagram.Version = 1
-	} else {
-		diagram.Version++
-	}
+	// Pushing a diagram that is in the trash restores it
+	restored := diagram.DeletedAt.Valid
+	diagram.DeletedAt = gorm.DeletedAt{}
+	diagram.Version++
 
 	diagram.Name = req.Name
 	diagram.DatabaseType = req.DatabaseType
 	diagram.DatabaseEdition = req.DatabaseEdition
+	diagram.TableCount = len(req.Tables)
+	diagram.RelationshipCount = len(req.Relationships)
 	diagram.UpdatedAt = time.Now()
 
 	if err := tx.Unscoped().Save(&diagram).Error; err != nil {
@@ -107,12 +236,7 @@ func PushDiagram(c *gin.Context) {
 	}
 
 	// Create new version
-	version := models.DiagramVersion{
-		DiagramID:   diagram.ID,
-		Version:     diagram.Version,
-		Data:        string(jsonData),
-		Description: req.Description,
-	}
+	version := newDiagramVersion(c, diagram.ID, diagram.Version, string(jsonData), req.Description, source)
 	if err := tx.Create(&version).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create version"})
@@ -126,7 +250,9 @@ func PushDiagram(c *gin.Context) {
 		tx.Delete(&v)
 	}
 
+	indexDiagram(tx, &diagram, string(jsonData))
 	tx.Commit()
+	middleware.AuditDiagram(c, models.AuditDiagramPushed, &diagram, map[string]interface{}{"version": diagram.Version, "created": false, "restored": restored, "source": source})
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Diagram updated successfully",
 		"diagram_id": diagram.DiagramID,
@@ -159,17 +285,24 @@ func SyncDiagram(c *gin.Context) {
 	}()
 
 	var diagram models.Diagram
-	err = tx.Where("diagram_id = ? AND user_id = ?", req.ID, userID).First(&diagram).Error
+	err = tx.Unscoped().Where("diagram_id = ? AND user_id = ?", req.ID, userID).First(&diagram).Error
 
 	if err == gorm.ErrRecordNotFound {
+		if diagramIDTaken(c, tx, req.ID, userID) {
+			tx.Rollback()
+			return
+		}
+
 		// Create new diagram
 		diagram = models.Diagram{
-			DiagramID:       req.ID,
-			UserID:          userID,
-			Name:            req.Name,
-			DatabaseType:    req.DatabaseType,
-			DatabaseEdition: req.DatabaseEdition,
-			Version:         1,
+			DiagramID:         req.ID,
+			UserID:            userID,
+			Name:              req.Name,
+			DatabaseType:      req.DatabaseType,
+			DatabaseEdition:   req.DatabaseEdition,
+			Version:           1,
+			TableCount:        len(req.Tables),
+			RelationshipCount: len(req.Relationships),
 		}
 		if err := tx.Create(&diagram).Error; err != nil {
 			tx.Rollback()
@@ -177,19 +310,16 @@ func SyncDiagram(c *gin.Context) {
 			return
 		}
 
-		version := models.DiagramVersion{
-			DiagramID:   diagram.ID,
-			Version:     1,
-			Data:        string(jsonData),
-			Description: "Initial sync",
-		}
+		version := newDiagramVersion(c, diagram.ID, 1, string(jsonData), "Initial sync", models.VersionSourceAutosync)
 		if err := tx.Create(&version).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create version"})
 			return
 		}
 
+		indexDiagram(tx, &diagram, string(jsonData))
 		tx.Commit()
+		middleware.AuditDiagram(c, models.AuditDiagramSynced, &diagram, map[string]interface{}{"version": 1, "created": true})
 		c.JSON(http.StatusCreated, gin.H{
 			"message":    "Diagram synced successfully",
 			"diagram_id": diagram.DiagramID,
@@ -205,10 +335,19 @@ func SyncDiagram(c *gin.Context) {
 		return
 	}
 
+	// Auto-sync doesn't bring diagrams back from the trash, only restoring or pushing does
+	if diagram.DeletedAt.Valid {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Diagram is in the trash. Restore it or push it again to sync it."})
+		return
+	}
+
 	// Update without incrementing version
 	diagram.Name = req.Name
 	diagram.DatabaseType = req.DatabaseType
 	diagram.DatabaseEdition = req.DatabaseEdition
+	diagram.TableCount = len(req.Tables)
+	diagram.RelationshipCount = len(req.Relationships)
 	diagram.UpdatedAt = time.Now()
 
 	if err := tx.Save(&diagram).Error; err != nil {
@@ -217,7 +356,7 @@ func SyncDiagram(c *gin.Context) {
 		return
 	}
 
-	// Update the latest version data
+	// Update the latest version data; it keeps the author, client and source it was created with
 	var latestVersion models.DiagramVersion
 	err = tx.Where("diagram_id = ? AND version = ?", diagram.ID, diagram.Version).First(&latestVersion).Error
 	if err == nil {
@@ -226,7 +365,9 @@ func SyncDiagram(c *gin.Context) {
 		tx.Save(&latestVersion)
 	}
 
+	indexDiagram(tx, &diagram, string(jsonData))
 	tx.Commit()
+	middleware.AuditDiagram(c, models.AuditDiagramSynced, &diagram, map[string]interface{}{"version": diagram.Version, "created": false})
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "Diagram synced successfully",
 		"diagram_id": diagram.DiagramID,
@@ -315,27 +456,84 @@ func PullAllDiagrams(c *gin.Context) {
 	})
 }
 
-// ListDiagrams returns all diagrams for the current user
+// ListDiagrams returns the user's diagrams
+// Filters: ?database_type= (comma-separated), ?name= (substring), ?updated_since= and ?updated_until= (RFC 3339),
+// ?tag= and, for administrators, ?owner= (user ID or email). Sorted with ?sort= and ?order=; paginated with ?page= and ?per_page=
 func ListDiagrams(c *gin.Context) {
-	userID := middleware.GetUserID(c)
+	ownerID := middleware.GetUserID(c)
+	if owner := strings.TrimSpace(c.Query("owner")); owner != "" {
+		var user models.User
+		query := database.DB.Where("LOWER(email) = LOWER(?)", owner)
+		if id, err := strconv.ParseUint(owner, 10, 64); err == nil {
+			query = database.DB.Where("id = ?", id)
+		}
+		err := query.First(&user).Error
+		if !middleware.IsAdmin(c) && (err != nil || user.ID != ownerID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can list the diagrams of other users"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Owner not found"})
+			return
+		}
+		ownerID = user.ID
+	}
+
+	query := database.DB.Model(&models.Diagram{}).Where("user_id = ?", ownerID)
+
+	if types := c.Query("database_type"); types != "" {
+		query = query.Where("database_type IN ?", splitList(types))
+	}
+	if name := strings.ToLower(strings.TrimSpace(c.Query("name"))); name != "" {
+		query = query.Where("LOWER(name) LIKE ?", "%"+name+"%")
+	}
+	if tag := normalizeTag(c.Query("tag")); tag != "" {
+		query = query.Where("id IN (?)", database.DB.Model(&models.DiagramTag{}).Select("diagram_id").Where("tag = ?", tag))
+	}
+	for param, condition := range map[string]string{"updated_since": "updated_at >= ?", "updated_until": "updated_at < ?"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time, e.g. 2024-01-31T00:00:00Z"})
+			return
+		}
+		query = query.Where(condition, t)
+	}
+
+	sort := c.DefaultQuery("sort", "updated_at")
+	if !diagramSortColumns[sort] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of name, created_at, updated_at, version, table_count or relationship_count"})
+		return
+	}
+	order, ok := sortOrder(c, "desc")
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch diagrams"})
+		return
+	}
+	query = paginateList(c, query, total, defaultDiagramsPerPage, maxDiagramsPerPage)
 
 	var diagrams []models.Diagram
-	if err := database.DB.Where("user_id = ?", userID).Order("updated_at desc").Find(&diagrams).Error; err != nil {
+	if err := query.Order(sort + " " + order).Order("id " + order).Find(&diagrams).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch diagrams"})
 		return
 	}
 
+	tags, err := loadDiagramTags(diagrams)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch diagrams"})
+		return
+	}
 	response := make([]models.DiagramListResponse, len(diagrams))
 	for i, d := range diagrams {
-		response[i] = models.DiagramListResponse{
-			ID:           d.ID,
-			DiagramID:    d.DiagramID,
-			Name:         d.Name,
-			DatabaseType: d.DatabaseType,
-			Version:      d.Version,
-			CreatedAt:    d.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:    d.UpdatedAt.Format(time.RFC3339),
-		}
+		response[i] = toDiagramListResponse(d, tags[d.ID])
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -356,18 +554,17 @@ func GetDiagram(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, models.DiagramListResponse{
-		ID:           diagram.ID,
-		DiagramID:    diagram.DiagramID,
-		Name:         diagram.Name,
-		DatabaseType: diagram.DatabaseType,
-		Version:      diagram.Version,
-		CreatedAt:    diagram.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:    diagram.UpdatedAt.Format(time.RFC3339),
-	})
+	tags, err := loadDiagramTags([]models.Diagram{diagram})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toDiagramListResponse(diagram, tags[diagram.ID]))
 }
 
-// DeleteDiagram deletes a diagram and all its versions
+// DeleteDiagram moves a diagram to the trash, where it keeps its versions and share links
+// Share links stop working until the diagram is restored
 func DeleteDiagram(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	diagramID := c.Param("diagramId")
@@ -382,27 +579,21 @@ func DeleteDiagram(c *gin.Context) {
 		return
 	}
 
-	tx := database.DB.Begin()
-
-	// Delete all versions
-	if err := tx.Where("diagram_id = ?", diagram.ID).Delete(&models.DiagramVersion{}).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete versions"})
-		return
-	}
-
-	// Delete diagram
-	if err := tx.Delete(&diagram).Error; err != nil {
-		tx.Rollback()
+	if err := database.DB.Delete(&diagram).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete diagram"})
 		return
 	}
 
-	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{"message": "Diagram deleted successfully"})
+	middleware.AuditDiagram(c, models.AuditDiagramDeleted, &diagram, map[string]interface{}{"name": diagram.Name})
+	response := gin.H{"message": "Diagram moved to trash"}
+	if purgeAt := trashPurgeAt(time.Now()); purgeAt != "" {
+		response["purge_at"] = purgeAt
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// GetVersions returns version history for a diagram
+// GetVersions returns the version history of a diagram
+// Filters: ?source= (comma-separated); ?order=asc lists the oldest first; paginated with ?page= and ?per_page=
 func GetVersions(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	diagramID := c.Param("diagramId")
@@ -417,8 +608,24 @@ func GetVersions(c *gin.Context) {
 		return
 	}
 
+	query := database.DB.Model(&models.DiagramVersion{}).Where("diagram_id = ?", diagram.ID)
+	if sources := c.Query("source"); sources != "" {
+		query = query.Where("source IN ?", splitList(sources))
+	}
+	order, ok := sortOrder(c, "desc")
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+	query = paginateList(c, query, total, defaultVersionsPerPage, maxVersionsPerPage)
+
 	var versions []models.DiagramVersion
-	if err := database.DB.Where("diagram_id = ?", diagram.ID).Order("version desc").Find(&versions).Error; err != nil {
+	if err := query.Omit("data").Order("version " + order).Find(&versions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
 		return
 	}
@@ -429,6 +636,7 @@ func GetVersions(c *gin.Context) {
 			ID:          v.ID,
 			Version:     v.Version,
 			Description: v.Description,
+			Source:      v.Source,
 			CreatedAt:   v.CreatedAt.Format(time.RFC3339),
 		}
 	}
@@ -436,6 +644,62 @@ func GetVersions(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// GetDiagramTimeline returns the versions of a diagram, newest first, with who
+// created each one, from which client and how
+func GetDiagramTimeline(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var versions []models.DiagramVersion
+	if err := database.DB.Omit("data").Where("diagram_id = ?", diagram.ID).Order("version desc").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+
+	// Authors may have left; deleted accounts still have a name until they are purged
+	var authorIDs []uint
+	for _, v := range versions {
+		if v.AuthorID != nil {
+			authorIDs = append(authorIDs, *v.AuthorID)
+		}
+	}
+	authors := make(map[uint]models.User)
+	if len(authorIDs) > 0 {
+		var found []models.User
+		database.DB.Unscoped().Where("id IN ?", authorIDs).Find(&found)
+		for _, user := range found {
+			authors[user.ID] = user
+		}
+	}
+
+	response := make([]models.TimelineEntryResponse, len(versions))
+	for i, v := range versions {
+		response[i] = models.TimelineEntryResponse{
+			Version:     v.Version,
+			Description: v.Description,
+			Source:      v.Source,
+			Client:      v.Client,
+			CreatedAt:   v.CreatedAt.Format(time.RFC3339),
+		}
+		if v.AuthorID != nil {
+			author := authors[*v.AuthorID]
+			response[i].Author = &models.TimelineAuthor{ID: *v.AuthorID, Email: author.Email, Name: author.Name}
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // CreateSnapshot creates a new version snapshot of the diagram
 func CreateSnapshot(c *gin.Context) {
 	userID := middleware.GetUserID(c)
@@ -479,12 +743,7 @@ func CreateSnapshot(c *gin.Context) {
 	if description == "" {
 		description = "Manual snapshot"
 	}
-	newVersion := models.DiagramVersion{
-		DiagramID:   diagram.ID,
-		Version:     diagram.Version,
-		Data:        latestVersion.Data,
-		Description: description,
-	}
+	newVersion := newDiagramVersion(c, diagram.ID, diagram.Version, latestVersion.Data, description, models.VersionSourceSnapshot)
 	if err := tx.Create(&newVersion).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create snapshot"})
@@ -499,6 +758,7 @@ func CreateSnapshot(c *gin.Context) {
 	}
 
 	tx.Commit()
+	middleware.AuditDiagram(c, models.AuditSnapshotCreated, &diagram, map[string]interface{}{"version": diagram.Version, "description": description})
 	c.JSON(http.StatusCreated, gin.H{
 		"message":    "Snapshot created successfully",
 		"diagram_id": diagram.DiagramID,
@@ -560,6 +820,8 @@ func DeleteVersion(c *gin.Context) {
 		return
 	}
 
+	middleware.AuditDiagram(c, models.AuditVersionDeleted, &diagram, map[string]interface{}{"version": version})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Version deleted successfully",
 		"version": version,
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+func TestPushSourceInTimeline(t *testing.T) {
+	user := createTestUser(t, "push-source@example.com")
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.POST("/diagrams/push", PushDiagram)
+	r.GET("/diagrams/:diagramId/timeline", GetDiagramTimeline)
+
+	diagram := `{"id": "push-source-diagram", "name": "Inventory"}`
+	if w := serveJSON(r, http.MethodPost, "/diagrams/push", diagram); w.Code != http.StatusCreated {
+		t.Fatalf("first push: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(r, http.MethodPost, "/diagrams/push?source=restore", diagram); w.Code != http.StatusOK {
+		t.Fatalf("push with source restore: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveJSON(r, http.MethodPost, "/diagrams/push?source=autosync", diagram); w.Code != http.StatusBadRequest {
+		t.Errorf("push with source autosync: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w := serveJSON(r, http.MethodGet, "/diagrams/push-source-diagram/timeline", "")
+	var timeline []models.TimelineEntryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("timeline: status %d: %s", w.Code, w.Body.String())
+	}
+	if len(timeline) != 2 || timeline[0].Source != models.VersionSourceRestore || timeline[1].Source != models.VersionSourcePush {
+		t.Errorf("timeline = %+v, want the restore above the first push", timeline)
+	}
+	if timeline[0].Author == nil || timeline[0].Author.ID != user.ID {
+		t.Errorf("restored version author = %+v, want user %d", timeline[0].Author, user.ID)
+	}
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	maxTagsPerDiagram = 20
+	maxTagLength      = 50
+)
+
+// SetDiagramTags replaces the tags of a diagram
+// Tags are case-insensitive and stored in lowercase
+func SetDiagramTags(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var req models.SetDiagramTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	seen := make(map[string]bool)
+	tags := []string{}
+	for _, tag := range req.Tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len([]rune(tag)) > maxTagLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Tags can be at most %d characters long", maxTagLength)})
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTagsPerDiagram {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A diagram can have at most %d tags", maxTagsPerDiagram)})
+		return
+	}
+	sort.Strings(tags)
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("diagram_id = ?", diagram.ID).Delete(&models.DiagramTag{}).Error; err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if err := tx.Create(&models.DiagramTag{DiagramID: diagram.ID, Tag: tag}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// normalizeTag trims and lowercases a tag
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// loadDiagramTags returns the sorted tags of each of the given diagrams by diagram ID
+func loadDiagramTags(diagrams []models.Diagram) (map[uint][]string, error) {
+	tags := make(map[uint][]string)
+	if len(diagrams) == 0 {
+		return tags, nil
+	}
+
+	ids := make([]uint, len(diagrams))
+	for i, diagram := range diagrams {
+		ids[i] = diagram.ID
+	}
+
+	var found []models.DiagramTag
+	if err := database.DB.Where("diagram_id IN ?", ids).Order("tag").Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, tag := range found {
+		tags[tag.DiagramID] = append(tags[tag.DiagramID], tag.Tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// ListPersonalAccessTokens returns the personal access tokens of the current user
+func ListPersonalAccessTokens(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens"})
+		return
+	}
+
+	response := make([]models.PersonalAccessTokenResponse, len(tokens))
+	for i, t := range tokens {
+		response[i] = toPersonalAccessTokenResponse(t)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreatePersonalAccessToken creates a new personal access token
+// The token is returned once and only its hash is stored
+func CreatePersonalAccessToken(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !isValidTokenScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+		if scope == models.ScopeAdmin && !middleware.IsAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only administrators can create tokens with the admin scope"})
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+		return
+	}
+
+	token, err := middleware.GenerateOpaqueToken(models.PersonalAccessTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	pat := models.PersonalAccessToken{
+		UserID:      userID,
+		Name:        req.Name,
+		TokenHash:   middleware.HashOpaqueToken(token),
+		TokenPrefix: token[:len(models.PersonalAccessTokenPrefix)+6],
+		Scopes:      strings.Join(req.Scopes, ","),
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := database.DB.Create(&pat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	response := toPersonalAccessTokenResponse(pat)
+	response.Token = token
+	c.JSON(http.StatusCreated, response)
+}
+
+// RevokePersonalAccessToken deletes a personal access token
+func RevokePersonalAccessToken(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	tokenID := c.Param("tokenId")
+
+	var pat models.PersonalAccessToken
+	if err := database.DB.Where("id = ? AND user_id = ?", tokenID, userID).First(&pat).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := database.DB.Delete(&pat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked successfully"})
+}
+
+func isValidTokenScope(scope string) bool {
+	for _, s := range models.TokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func toPersonalAccessTokenResponse(t models.PersonalAccessToken) models.PersonalAccessTokenResponse {
+	return models.PersonalAccessTokenResponse{
+		ID:          t.ID,
+		Name:        t.Name,
+		TokenPrefix: t.TokenPrefix,
+		Scopes:      t.ScopeList(),
+		ExpiresAt:   formatOptionalTime(t.ExpiresAt),
+		LastUsedAt:  formatOptionalTime(t.LastUsedAt),
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// TransferDiagram transfers a diagram and its full version history to another user
+// Allowed for the owner of the diagram and for administrators
+func TransferDiagram(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var req models.TransferDiagramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := database.DB.Where("diagram_id = ?", diagramID)
+	if !middleware.IsAdmin(c) {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var diagram models.Diagram
+	if err := query.First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	newOwner, ok := findTransferTarget(c, req)
+	if !ok {
+		return
+	}
+
+	if newOwner.ID == diagram.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Diagram already belongs to this user"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := transferDiagrams(tx, []models.Diagram{diagram}, newOwner.ID, userID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer diagram"})
+		return
+	}
+	tx.Commit()
+
+	middleware.AuditDiagram(c, models.AuditDiagramTransferred, &diagram, map[string]interface{}{
+		"from_user_id": diagram.UserID,
+		"to_user_id":   newOwner.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Diagram transferred successfully",
+		"diagram_id": diagram.DiagramID,
+		"to_user_id": newOwner.ID,
+	})
+}
+
+// TransferUserDiagrams transfers every diagram of a user to another user (admin only)
+// Used when someone leaves the team. Trashed diagrams are moved too so their IDs stay with the new owner.
+func TransferUserDiagrams(c *gin.Context) {
+	adminID := middleware.GetUserID(c)
+	fromUserID := c.Param("userId")
+
+	var req models.TransferDiagramRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var fromUser models.User
+	if err := database.DB.Unscoped().First(&fromUser, fromUserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	newOwner, ok := findTransferTarget(c, req)
+	if !ok {
+		return
+	}
+
+	if newOwner.ID == fromUser.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot transfer diagrams to the same user"})
+		return
+	}
+
+	var diagrams []models.Diagram
+	if err := database.DB.Unscoped().Where("user_id = ?", fromUser.ID).Find(&diagrams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch diagrams"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	if err := transferDiagrams(tx, diagrams, newOwner.ID, adminID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer diagrams"})
+		return
+	}
+	tx.Commit()
+
+	for i := range diagrams {
+		if diagrams[i].UserID != newOwner.ID {
+			middleware.AuditDiagram(c, models.AuditDiagramTransferred, &diagrams[i], map[string]interface{}{
+				"from_user_id": fromUser.ID,
+				"to_user_id":   newOwner.ID,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Diagrams transferred successfully",
+		"from_user_id": fromUser.ID,
+		"to_user_id":   newOwner.ID,
+		"count":        len(diagrams),
+	})
+}
+
+// GetDiagramTransfers returns the ownership history of a diagram
+func GetDiagramTransfers(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var diagram models.Diagram
+	if err := database.DB.Where("diagram_id = ? AND user_id = ?", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var transfers []models.DiagramTransfer
+	if err := database.DB.Where("diagram_id = ?", diagram.ID).Order("created_at desc").Find(&transfers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transfers"})
+		return
+	}
+
+	// Resolve emails, including users that have since been deleted
+	emails := make(map[uint]string)
+	var userIDs []uint
+	for _, t := range transfers {
+		userIDs = append(userIDs, t.FromUserID, t.ToUserID)
+	}
+	if len(userIDs) > 0 {
+		var users []models.User
+		database.DB.Unscoped().Where("id IN ?", userIDs).Find(&users)
+		for _, u := range users {
+			emails[u.ID] = u.Email
+		}
+	}
+
+	response := make([]models.DiagramTransferResponse, len(transfers))
+	for i, t := range transfers {
+		response[i] = models.DiagramTransferResponse{
+			ID:            t.ID,
+			FromUserID:    t.FromUserID,
+			FromEmail:     emails[t.FromUserID],
+			ToUserID:      t.ToUserID,
+			ToEmail:       emails[t.ToUserID],
+			TransferredBy: t.TransferredBy,
+			CreatedAt:     t.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// findTransferTarget looks up the new owner of a transfer and writes the error response if needed
+func findTransferTarget(c *gin.Context, req models.TransferDiagramRequest) (*models.User, bool) {
+	var user models.User
+	var err error
+	switch {
+	case req.ToUserID != 0:
+		err = database.DB.First(&user, req.ToUserID).Error
+	case req.ToEmail != "":
+		err = database.DB.Where("email = ?", req.ToEmail).First(&user).Error
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_user_id or to_email is required"})
+		return nil, false
+	}
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Target user not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	return &user, true
+}
+
+// transferDiagrams moves diagrams to a new owner and records each transfer.
+// Versions reference the diagram's primary key, so the history follows automatically.
+func transferDiagrams(tx *gorm.DB, diagrams []models.Diagram, toUserID, actorID uint) error {
+	for _, diagram := range diagrams {
+		if diagram.UserID == toUserID {
+			continue
+		}
+
+		if err := tx.Unscoped().Model(&models.Diagram{}).Where("id = ?", diagram.ID).Update("user_id", toUserID).Error; err != nil {
+			return err
+		}
+
+		transfer := models.DiagramTransfer{
+			DiagramID:     diagram.ID,
+			FromUserID:    diagram.UserID,
+			ToUserID:      toUserID,
+			TransferredBy: actorID,
+		}
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
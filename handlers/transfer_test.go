@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+func TestPushAfterTransferConflicts(t *testing.T) {
+	owner := createTestUser(t, "transfer-owner@example.com")
+	recipient := createTestUser(t, "transfer-recipient@example.com")
+	stranger := createTestUser(t, "transfer-stranger@example.com")
+
+	send := func(user *models.User, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := gin.New()
+		r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+		r.POST("/diagrams/push", PushDiagram)
+		r.POST("/diagrams/sync", SyncDiagram)
+		r.POST("/diagrams/:diagramId/transfer", TransferDiagram)
+
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	diagram := `{"id": "transferred-diagram", "name": "Payments"}`
+
+	if w := send(owner, http.MethodPost, "/diagrams/push", diagram); w.Code != http.StatusCreated {
+		t.Fatalf("first push: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := send(owner, http.MethodPost, "/diagrams/transferred-diagram/transfer", `{"to_email": "transfer-recipient@example.com"}`); w.Code != http.StatusOK {
+		t.Fatalf("transfer: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// The previous owner's browser still has the diagram under the same ID
+	for _, path := range []string{"/diagrams/push", "/diagrams/sync"} {
+		w := send(owner, http.MethodPost, path, diagram)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "transferred") {
+			t.Errorf("%s by the previous owner: status %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	if w := send(stranger, http.MethodPost, "/diagrams/push", diagram); w.Code != http.StatusConflict {
+		t.Errorf("push by another user: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := send(recipient, http.MethodPost, "/diagrams/push", diagram); w.Code != http.StatusOK {
+		t.Errorf("push by the new owner: status %d: %s", w.Code, w.Body.String())
+	}
+}
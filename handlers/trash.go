@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+// trashPurgeInterval is how often diagrams that have been in the trash too long are erased
+const trashPurgeInterval = time.Hour
+
+// ListTrash returns the deleted diagrams of the user, most recently deleted first
+func ListTrash(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var diagrams []models.Diagram
+	if err := database.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Order("deleted_at desc").Find(&diagrams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	response := make([]models.TrashedDiagramResponse, len(diagrams))
+	for i, diagram := range diagrams {
+		response[i] = models.TrashedDiagramResponse{
+			DiagramID:    diagram.DiagramID,
+			Name:         diagram.Name,
+			DatabaseType: diagram.DatabaseType,
+			Version:      diagram.Version,
+			DeletedAt:    diagram.DeletedAt.Time.Format(time.RFC3339),
+			PurgeAt:      trashPurgeAt(diagram.DeletedAt.Time),
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreDiagram takes a diagram out of the trash, with its versions and share links
+func RestoreDiagram(c *gin.Context) {
+	diagram, ok := findTrashedDiagram(c)
+	if !ok {
+		return
+	}
+
+	// Diagrams deleted before the trash existed lost their versions
+	var versionCount int64
+	database.DB.Model(&models.DiagramVersion{}).Where("diagram_id = ?", diagram.ID).Count(&versionCount)
+	if versionCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This diagram has no versions left to restore. Push it from the browser instead."})
+		return
+	}
+
+	if err := database.DB.Unscoped().Model(diagram).Update("deleted_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore diagram"})
+		return
+	}
+
+	middleware.AuditDiagram(c, models.AuditDiagramRestored, diagram, map[string]interface{}{"name": diagram.Name})
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Diagram restored successfully",
+		"diagram_id": diagram.DiagramID,
+		"version":    diagram.Version,
+	})
+}
+
+// PurgeDiagram permanently deletes a diagram in the trash
+func PurgeDiagram(c *gin.Context) {
+	diagram, ok := findTrashedDiagram(c)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeDiagrams(tx, []uint{diagram.ID})
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete diagram"})
+		return
+	}
+
+	middleware.AuditDiagram(c, models.AuditDiagramPurged, diagram, map[string]interface{}{"name": diagram.Name})
+	c.JSON(http.StatusOK, gin.H{"message": "Diagram permanently deleted"})
+}
+
+// EmptyTrash permanently deletes all diagrams in the trash of the user
+func EmptyTrash(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var diagrams []models.Diagram
+	if err := database.DB.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).Find(&diagrams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	ids := make([]uint, len(diagrams))
+	for i, diagram := range diagrams {
+		ids[i] = diagram.ID
+	}
+	if len(ids) > 0 {
+		if err := database.DB.Transaction(func(tx *gorm.DB) error {
+			return purgeDiagrams(tx, ids)
+		}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+			return
+		}
+	}
+
+	for i := range diagrams {
+		middleware.AuditDiagram(c, models.AuditDiagramPurged, &diagrams[i], map[string]interface{}{"name": diagrams[i].Name})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Trash emptied",
+		"deleted": len(ids),
+	})
+}
+
+// StartTrashPurge periodically erases diagrams that have been in the trash longer than TRASH_RETENTION_DAYS
+func StartTrashPurge() {
+	if config.TrashRetention == 0 {
+		return
+	}
+
+	go func() {
+		purgeExpiredTrash()
+
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			purgeExpiredTrash()
+		}
+	}()
+}
+
+// purgeExpiredTrash erases diagrams whose time in the trash is up
+// Diagrams of deleted accounts are left alone; they are restored or erased with the account
+func purgeExpiredTrash() {
+	var ids []uint
+	if err := database.DB.Unscoped().Model(&models.Diagram{}).
+		Joins("JOIN users ON users.id = diagrams.user_id AND users.deleted_at IS NULL").
+		Where("diagrams.deleted_at <= ?", time.Now().Add(-config.TrashRetention)).
+		Pluck("diagrams.id", &ids).Error; err != nil {
+		log.Printf("Warning: failed to look up expired diagrams in the trash: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	if err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return purgeDiagrams(tx, ids)
+	}); err != nil {
+		log.Printf("Warning: failed to erase expired diagrams in the trash: %v", err)
+		return
+	}
+	log.Printf("Erased %d diagrams from the trash", len(ids))
+}
+
+// purgeDiagrams permanently removes diagrams with their versions, tags, share links, transfers and search index entries
+// ids is a list of diagram IDs or a subquery that selects them; the diagrams are deleted last, since a
+// subquery over them selects nothing afterwards
+func purgeDiagrams(tx *gorm.DB, ids interface{}) error {
+	if err := database.RemoveFromSearchIndex(tx, ids); err != nil {
+		return err
+	}
+
+	if err := tx.Where("diagram_id IN (?)", ids).Delete(&models.DiagramVersion{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("diagram_id IN (?)", ids).Delete(&models.DiagramTag{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("diagram_id IN (?)", ids).Delete(&models.ShareLink{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("diagram_id IN (?)", ids).Delete(&models.DiagramTransfer{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN (?)", ids).Delete(&models.Diagram{}).Error
+}
+
+// findTrashedDiagram looks up a diagram of the user in the trash, responding with an error if there is none
+func findTrashedDiagram(c *gin.Context) (*models.Diagram, bool) {
+	userID := middleware.GetUserID(c)
+	diagramID := c.Param("diagramId")
+
+	var diagram models.Diagram
+	if err := database.DB.Unscoped().Where("diagram_id = ? AND user_id = ? AND deleted_at IS NOT NULL", diagramID, userID).First(&diagram).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Diagram not found in trash"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return &diagram, true
+}
+
+// trashPurgeAt returns when a diagram deleted at deletedAt will be erased, or "" if it is kept until the trash is emptied
+func trashPurgeAt(deletedAt time.Time) string {
+	if config.TrashRetention == 0 {
+		return ""
+	}
+	return deletedAt.Add(config.TrashRetention).Format(time.RFC3339)
+}
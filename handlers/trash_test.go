@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+// trashRouter serves the diagram and trash routes as a user
+func trashRouter(user *models.User) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.POST("/diagrams/push", PushDiagram)
+	r.GET("/diagrams", ListDiagrams)
+	r.DELETE("/diagrams/:diagramId", DeleteDiagram)
+	r.GET("/trash", ListTrash)
+	r.POST("/trash/:diagramId/restore", RestoreDiagram)
+	r.DELETE("/trash/:diagramId", PurgeDiagram)
+	r.DELETE("/trash", EmptyTrash)
+	return r
+}
+
+// trashTestDiagram pushes a diagram and moves it to the trash
+func trashTestDiagram(t *testing.T, r *gin.Engine, diagramID string) *models.Diagram {
+	t.Helper()
+
+	if w := serveJSON(r, http.MethodPost, "/diagrams/push", fmt.Sprintf(`{"id": %q, "name": "Trashed"}`, diagramID)); w.Code != http.StatusCreated {
+		t.Fatalf("push %s: status %d: %s", diagramID, w.Code, w.Body.String())
+	}
+	if w := serveJSON(r, http.MethodDelete, "/diagrams/"+diagramID, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete %s: status %d: %s", diagramID, w.Code, w.Body.String())
+	}
+
+	var diagram models.Diagram
+	if err := database.DB.Unscoped().Where("diagram_id = ?", diagramID).First(&diagram).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &diagram
+}
+
+// diagramRows counts what is stored for a diagram, in the trash or not: the diagram and its versions and share links
+func diagramRows(diagram *models.Diagram) (diagrams, versions, shares int64) {
+	database.DB.Unscoped().Model(&models.Diagram{}).Where("id = ?", diagram.ID).Count(&diagrams)
+	database.DB.Model(&models.DiagramVersion{}).Where("diagram_id = ?", diagram.ID).Count(&versions)
+	database.DB.Model(&models.ShareLink{}).Where("diagram_id = ?", diagram.ID).Count(&shares)
+	return diagrams, versions, shares
+}
+
+func TestTrashRestore(t *testing.T) {
+	user := createTestUser(t, "trash-restore@example.com")
+	r := trashRouter(user)
+	trashTestDiagram(t, r, "trash-restored-diagram")
+
+	w := serveJSON(r, http.MethodGet, "/trash", "")
+	var trash []models.TrashedDiagramResponse
+	json.Unmarshal(w.Body.Bytes(), &trash)
+	if len(trash) != 1 || trash[0].DiagramID != "trash-restored-diagram" || trash[0].PurgeAt == "" {
+		t.Fatalf("trash = %s", w.Body.String())
+	}
+
+	if w := serveJSON(r, http.MethodPost, "/trash/trash-restored-diagram/restore", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: status %d: %s", w.Code, w.Body.String())
+	}
+	w = serveJSON(r, http.MethodGet, "/diagrams", "")
+	var diagrams []models.DiagramListResponse
+	json.Unmarshal(w.Body.Bytes(), &diagrams)
+	if len(diagrams) != 1 || diagrams[0].DiagramID != "trash-restored-diagram" {
+		t.Errorf("diagrams after restoring = %s", w.Body.String())
+	}
+	if w := serveJSON(r, http.MethodPost, "/trash/trash-restored-diagram/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restoring a diagram that isn't in the trash: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	user := createTestUser(t, "trash-purge@example.com")
+	r := trashRouter(user)
+	purged := trashTestDiagram(t, r, "trash-purged-diagram")
+	database.DB.Create(&models.ShareLink{DiagramID: purged.ID, UserID: user.ID, TokenHash: "trash-purged-share"})
+	emptied := []*models.Diagram{
+		trashTestDiagram(t, r, "trash-emptied-diagram-1"),
+		trashTestDiagram(t, r, "trash-emptied-diagram-2"),
+	}
+	if w := serveJSON(r, http.MethodPost, "/diagrams/push", `{"id": "trash-kept-diagram", "name": "Kept"}`); w.Code != http.StatusCreated {
+		t.Fatalf("push: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveJSON(r, http.MethodDelete, "/trash/trash-purged-diagram", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d: %s", w.Code, w.Body.String())
+	}
+	if diagrams, versions, shares := diagramRows(purged); diagrams+versions+shares != 0 {
+		t.Errorf("after purging: %d diagrams, %d versions and %d share links left", diagrams, versions, shares)
+	}
+
+	w := serveJSON(r, http.MethodDelete, "/trash", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("empty trash: status %d: %s", w.Code, w.Body.String())
+	}
+	var emptiedResponse struct {
+		Deleted int `json:"deleted"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &emptiedResponse)
+	if emptiedResponse.Deleted != 2 {
+		t.Errorf("emptied %d diagrams, want 2", emptiedResponse.Deleted)
+	}
+	for _, diagram := range emptied {
+		if diagrams, versions, _ := diagramRows(diagram); diagrams+versions != 0 {
+			t.Errorf("%s after emptying the trash: %d diagrams and %d versions left", diagram.DiagramID, diagrams, versions)
+		}
+	}
+
+	// Diagrams outside the trash are not touched
+	var kept models.Diagram
+	if err := database.DB.Where("diagram_id = ?", "trash-kept-diagram").First(&kept).Error; err != nil {
+		t.Fatalf("diagram outside the trash was erased: %v", err)
+	}
+	if _, versions, _ := diagramRows(&kept); versions != 1 {
+		t.Errorf("diagram outside the trash has %d versions, want 1", versions)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	user := createTestUser(t, "trash-expired@example.com")
+	deletedUser := createTestUser(t, "trash-expired-deleted-account@example.com")
+	r := trashRouter(user)
+	expired := trashTestDiagram(t, r, "trash-expired-diagram")
+	recent := trashTestDiagram(t, r, "trash-recent-diagram")
+	ofDeletedAccount := trashTestDiagram(t, trashRouter(deletedUser), "trash-deleted-account-diagram")
+
+	longAgo := time.Now().Add(-config.TrashRetention - time.Hour)
+	database.DB.Unscoped().Model(&models.Diagram{}).Where("id IN ?", []uint{expired.ID, ofDeletedAccount.ID}).Update("deleted_at", longAgo)
+	database.DB.Delete(deletedUser)
+
+	purgeExpiredTrash()
+
+	if diagrams, versions, _ := diagramRows(expired); diagrams+versions != 0 {
+		t.Errorf("expired diagram: %d diagrams and %d versions left", diagrams, versions)
+	}
+	if diagrams, _, _ := diagramRows(recent); diagrams != 1 {
+		t.Error("diagram deleted recently was erased")
+	}
+	// It is restored or erased with the account
+	if diagrams, _, _ := diagramRows(ofDeletedAccount); diagrams != 1 {
+		t.Error("expired diagram of a deleted account was erased")
+	}
+}
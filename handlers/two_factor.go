@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"github.com/thorved/chartdb-backend/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	loginChallengePrefix = "mfa_"
+	loginChallengeTTL    = 5 * time.Minute
+
+	// maxLoginChallengeAttempts limits guessing codes with one challenge
+	maxLoginChallengeAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+// GetTwoFactorStatus returns whether 2FA is enabled for the current user
+func GetTwoFactorStatus(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var remaining int64
+	database.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+
+	c.JSON(http.StatusOK, models.TwoFactorStatusResponse{
+		Enabled:                user.TwoFactorEnabled(),
+		Required:               user.IsLocal() && database.GetBoolSetting(models.SettingRequireTwoFactor),
+		EnabledAt:              formatOptionalTime(user.TOTPEnabledAt),
+		RecoveryCodesRemaining: int(remaining),
+	})
+}
+
+// SetupTwoFactor starts TOTP enrollment and returns the secret to add to an authenticator app
+// 2FA is only turned on once EnableTwoFactor confirms a code
+func SetupTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.IsLocal() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is only available for password accounts"})
+		return
+	}
+	if user.TwoFactorEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("totp_secret", secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, totpIssuer(), user.Email),
+	})
+}
+
+// EnableTwoFactor confirms enrollment with a code from the authenticator app
+// Returns the recovery codes, which are shown only once
+func EnableTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.TwoFactorEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start the setup first"})
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"totp_enabled_at": time.Now(),
+		"totp_last_step":  step,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	codes, err := generateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns off 2FA after checking the password and a TOTP or recovery code
+func DisableTwoFactor(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.TwoFactorEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if database.GetBoolSetting(models.SettingRequireTwoFactor) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required by the administrator"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+		return
+	}
+	if !verifySecondFactor(&user, req.Code, true) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	tx := database.DB.Begin()
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"totp_secret":     "",
+		"totp_enabled_at": nil,
+		"totp_last_step":  0,
+	}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code
+func RegenerateRecoveryCodes(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !user.TwoFactorEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !verifySecondFactor(&user, req.Code, false) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	codes, err := generateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyTwoFactorLogin completes a login challenge with a TOTP or recovery code and starts the session
+func VerifyTwoFactorLogin(c *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var challenge models.LoginChallenge
+	err := database.DB.Where("token_hash = ?", middleware.HashOpaqueToken(req.ChallengeToken)).First(&challenge).Error
+	if err != nil || !challenge.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	// Count the attempt first, so concurrent guesses can't exceed the limit
+	result := database.DB.Model(&challenge).
+		Where("attempts < ?", maxLoginChallengeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		database.DB.Delete(&challenge)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many attempts, please sign in again"})
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, challenge.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	if !verifySecondFactor(&user, req.Code, true) {
+		middleware.RecordAuthFailure(c)
+		auditLoginFailure(c, &user, user.Email, "two_factor", "wrong_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// The challenge is single-use; losing a race for it means another request already signed in
+	if result := database.DB.Delete(&challenge); result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, please sign in again"})
+		return
+	}
+
+	startSession(c, http.StatusOK, &user, "two_factor")
+}
+
+// startLoginChallenge responds to a correct password with a challenge token for the second step
+func startLoginChallenge(c *gin.Context, user *models.User) {
+	token, err := middleware.GenerateOpaqueToken(loginChallengePrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+
+	// Clean up expired challenges of the user while we're here
+	database.DB.Where("user_id = ? AND expires_at <= ?", user.ID, now).Delete(&models.LoginChallenge{})
+
+	challenge := models.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		ExpiresAt: now.Add(loginChallengeTTL),
+	}
+	if err := database.DB.Create(&challenge).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// Failed attempts on the account only count as cleared once the second factor is verified
+	middleware.RecordAuthPending(c)
+	c.JSON(http.StatusOK, models.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when allowRecovery is set
+// Accepted codes are consumed: a TOTP code can't be replayed and a recovery code works once
+func verifySecondFactor(user *models.User, code string, allowRecovery bool) bool {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		result := database.DB.Model(user).
+			Where("totp_last_step < ?", step).
+			Update("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	if !allowRecovery {
+		return false
+	}
+
+	var recoveryCode models.RecoveryCode
+	if err := database.DB.Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(code)).First(&recoveryCode).Error; err != nil {
+		return false
+	}
+	result := database.DB.Model(&recoveryCode).Where("used_at IS NULL").Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// generateRecoveryCodes replaces the recovery codes of a user and returns the new codes
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b)) // 8 characters
+		codes[i] = raw[:4] + "-" + raw[4:]
+
+		if err := tx.Create(&models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(codes[i]),
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	return middleware.HashOpaqueToken(normalized)
+}
+
+// totpIssuer is the account issuer shown in authenticator apps, from TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "ChartDB Sync"
+}
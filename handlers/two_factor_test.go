@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"github.com/thorved/chartdb-backend/totp"
+)
+
+// enableTestTwoFactor enrolls a user in 2FA through the setup routes and returns the secret and recovery codes
+func enableTestTwoFactor(t *testing.T, user *models.User) (string, []string) {
+	t.Helper()
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", user.ID) })
+	r.POST("/2fa/setup", SetupTwoFactor)
+	r.POST("/2fa/enable", EnableTwoFactor)
+
+	w := serveJSON(r, http.MethodPost, "/2fa/setup", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("2FA setup: status %d: %s", w.Code, w.Body.String())
+	}
+	var setup models.TwoFactorSetupResponse
+	json.Unmarshal(w.Body.Bytes(), &setup)
+
+	w = serveJSON(r, http.MethodPost, "/2fa/enable", `{"code": "`+totpCode(t, setup.Secret, time.Now())+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("2FA enable: status %d: %s", w.Code, w.Body.String())
+	}
+	var recovery models.RecoveryCodesResponse
+	json.Unmarshal(w.Body.Bytes(), &recovery)
+	return setup.Secret, recovery.RecoveryCodes
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// startTwoFactorLogin signs in with a password and returns the challenge token of the second step
+func startTwoFactorLogin(t *testing.T, email, password string) string {
+	t.Helper()
+
+	w := login(t, email, password)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	var challenge struct {
+		models.TwoFactorChallengeResponse
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &challenge)
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" || challenge.Token != "" {
+		t.Fatalf("login with 2FA enabled didn't answer with a challenge: %s", w.Body.String())
+	}
+	return challenge.ChallengeToken
+}
+
+func verifyTwoFactorLogin(challengeToken, code string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/2fa/verify", VerifyTwoFactorLogin)
+	return serveJSON(r, http.MethodPost, "/2fa/verify", `{"challenge_token": "`+challengeToken+`", "code": "`+code+`"}`)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	user := createTestUser(t, "2fa-login@example.com")
+	setTestPassword(t, user, "2fa-password")
+	secret, recoveryCodes := enableTestTwoFactor(t, user)
+
+	// Enrollment used the current code, so sign in with the next one, still within the accepted drift
+	next := time.Now().Add(totp.Period * time.Second)
+	code := totpCode(t, secret, next)
+	challenge := startTwoFactorLogin(t, user.Email, "2fa-password")
+	loginUser(t, verifyTwoFactorLogin(challenge, code))
+
+	if w := verifyTwoFactorLogin(challenge, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("used challenge: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	challenge = startTwoFactorLogin(t, user.Email, "2fa-password")
+	if w := verifyTwoFactorLogin(challenge, code); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed TOTP code: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	var stored models.User
+	database.DB.First(&stored, user.ID)
+	if stored.TOTPLastStep != next.Unix()/totp.Period {
+		t.Errorf("totp_last_step = %d, want the step of the last accepted code", stored.TOTPLastStep)
+	}
+
+	// The same challenge still takes a recovery code, which then works only once
+	loginUser(t, verifyTwoFactorLogin(challenge, strings.ToUpper(recoveryCodes[0])))
+	challenge = startTwoFactorLogin(t, user.Email, "2fa-password")
+	if w := verifyTwoFactorLogin(challenge, recoveryCodes[0]); w.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	loginUser(t, verifyTwoFactorLogin(challenge, recoveryCodes[1]))
+}
+
+func TestTwoFactorChallengeAttemptLimit(t *testing.T) {
+	user := createTestUser(t, "2fa-attempts@example.com")
+	setTestPassword(t, user, "2fa-password")
+	secret, _ := enableTestTwoFactor(t, user)
+
+	challenge := startTwoFactorLogin(t, user.Email, "2fa-password")
+	for i := 0; i < maxLoginChallengeAttempts; i++ {
+		w := verifyTwoFactorLogin(challenge, "wrong1")
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid code") {
+			t.Fatalf("wrong code %d: status %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+
+	// Past the limit, even the right code needs a new login
+	code := totpCode(t, secret, time.Now().Add(totp.Period*time.Second))
+	w := verifyTwoFactorLogin(challenge, code)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Too many attempts") {
+		t.Errorf("code after the attempt limit: status %d: %s", w.Code, w.Body.String())
+	}
+	var count int64
+	database.DB.Model(&models.LoginChallenge{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d challenges left after exceeding the attempt limit", count)
+	}
+
+	loginUser(t, verifyTwoFactorLogin(startTwoFactorLogin(t, user.Email, "2fa-password"), code))
+}
+
+func TestRequireTwoFactorSetup(t *testing.T) {
+	user := createTestUser(t, "2fa-required@example.com")
+	oidcUser := createTestUser(t, "2fa-required-oidc@example.com")
+	database.DB.Model(oidcUser).Update("auth_provider", "oidc")
+
+	if err := database.SetBoolSetting(models.SettingRequireTwoFactor, true); err != nil {
+		t.Fatal(err)
+	}
+	defer database.SetBoolSetting(models.SettingRequireTwoFactor, false)
+
+	r := gin.New()
+	protected := r.Group("", middleware.AuthMiddleware())
+	protected.GET("/2fa", GetTwoFactorStatus)
+	protected.GET("/diagrams", middleware.RequireTwoFactor(), ListDiagrams)
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	token := createTestSession(t, user).AccessToken
+	if w := get("/diagrams", token); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "two_factor_setup_required") {
+		t.Errorf("diagrams before enrolling: status %d: %s", w.Code, w.Body.String())
+	}
+	if w := get("/2fa", token); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"required":true`) {
+		t.Errorf("2FA status before enrolling: status %d: %s", w.Code, w.Body.String())
+	}
+
+	enableTestTwoFactor(t, user)
+	if w := get("/diagrams", token); w.Code != http.StatusOK {
+		t.Errorf("diagrams after enrolling: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := get("/diagrams", createTestSession(t, oidcUser).AccessToken); w.Code != http.StatusOK {
+		t.Errorf("diagrams of an OIDC account: status %d: %s", w.Code, w.Body.String())
+	}
+}
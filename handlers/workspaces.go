@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ListMyWorkspaces returns the workspaces of the current user with their role
+func ListMyWorkspaces(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var members []models.WorkspaceMember
+	if err := database.DB.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspaces"})
+		return
+	}
+
+	roles := make(map[uint]string, len(members))
+	workspaceIDs := make([]uint, len(members))
+	for i, member := range members {
+		roles[member.WorkspaceID] = member.Role
+		workspaceIDs[i] = member.WorkspaceID
+	}
+
+	var workspaces []models.Workspace
+	if len(workspaceIDs) > 0 {
+		if err := database.DB.Where("id IN ?", workspaceIDs).Order("name").Find(&workspaces).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspaces"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, toWorkspaceResponses(workspaces, roles))
+}
+
+// ListWorkspaces returns all workspaces with their member counts (admin only)
+func ListWorkspaces(c *gin.Context) {
+	var workspaces []models.Workspace
+	if err := database.DB.Order("name").Find(&workspaces).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch workspaces"})
+		return
+	}
+
+	c.JSON(http.StatusOK, toWorkspaceResponses(workspaces, nil))
+}
+
+// CreateWorkspace creates an empty workspace (admin only)
+func CreateWorkspace(c *gin.Context) {
+	var req models.WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if workspaceNameTaken(name, 0) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A workspace with this name already exists"})
+		return
+	}
+
+	workspace := models.Workspace{Name: name}
+	if err := database.DB.Create(&workspace).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create workspace"})
+		return
+	}
+	auditWorkspaceChange(c, models.AuditWorkspaceCreated, &workspace, nil)
+
+	c.JSON(http.StatusCreated, toWorkspaceResponses([]models.Workspace{workspace}, nil)[0])
+}
+
+// UpdateWorkspace renames a workspace (admin only)
+func UpdateWorkspace(c *gin.Context) {
+	var req models.WorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if name != workspace.Name {
+		if workspaceNameTaken(name, workspace.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A workspace with this name already exists"})
+			return
+		}
+		previousName := workspace.Name
+		if err := database.DB.Model(workspace).Update("name", name).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update workspace"})
+			return
+		}
+		auditWorkspaceChange(c, models.AuditWorkspaceUpdated, workspace, map[string]interface{}{"previous_name": previousName})
+	}
+
+	c.JSON(http.StatusOK, toWorkspaceResponses([]models.Workspace{*workspace}, nil)[0])
+}
+
+// DeleteWorkspace deletes a workspace with its memberships and revokes the pending invitations into it (admin only)
+func DeleteWorkspace(c *gin.Context) {
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("workspace_id = ?", workspace.ID).Delete(&models.WorkspaceMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Invitation{}).
+			Where("workspace_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", workspace.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(workspace).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete workspace"})
+		return
+	}
+	auditWorkspaceChange(c, models.AuditWorkspaceDeleted, workspace, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Workspace deleted successfully"})
+}
+
+// ListWorkspaceMembers returns the members of a workspace (admin only)
+func ListWorkspaceMembers(c *gin.Context) {
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+
+	var members []models.WorkspaceMember
+	if err := database.DB.Where("workspace_id = ?", workspace.ID).Order("user_id").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+
+	userIDs := make([]uint, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
+	users := make(map[uint]models.User, len(members))
+	if len(userIDs) > 0 {
+		var found []models.User
+		database.DB.Where("id IN ?", userIDs).Find(&found)
+		for _, user := range found {
+			users[user.ID] = user
+		}
+	}
+
+	response := make([]models.WorkspaceMemberResponse, 0, len(members))
+	for _, member := range members {
+		user, ok := users[member.UserID]
+		if !ok {
+			continue
+		}
+		response = append(response, models.WorkspaceMemberResponse{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Name:      user.Name,
+			Role:      member.Role,
+			Source:    member.Source,
+			CreatedAt: member.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetWorkspaceMember adds a user to a workspace or changes their role (admin only)
+// The membership then counts as added by an administrator, so directories no longer remove it
+func SetWorkspaceMember(c *gin.Context) {
+	var req models.WorkspaceMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	role := req.Role
+	if role == "" {
+		role = models.WorkspaceRoleMember
+	}
+
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := setWorkspaceMember(database.DB, workspace.ID, user.ID, role, models.WorkspaceSourceManual); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	auditWorkspaceChange(c, models.AuditWorkspaceMemberSet, workspace, map[string]interface{}{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"role":    role,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+}
+
+// RemoveWorkspaceMember removes a user from a workspace (admin only)
+func RemoveWorkspaceMember(c *gin.Context) {
+	workspace, ok := findWorkspace(c)
+	if !ok {
+		return
+	}
+	user, ok := findAdminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("workspace_id = ? AND user_id = ?", workspace.ID, user.ID).Delete(&models.WorkspaceMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this workspace"})
+		return
+	}
+	auditWorkspaceChange(c, models.AuditWorkspaceMemberRemoved, workspace, map[string]interface{}{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// setWorkspaceMember adds a user to a workspace, or updates the role and source of their membership
+func setWorkspaceMember(tx *gorm.DB, workspaceID, userID uint, role, source string) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workspace_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "source"}),
+	}).Create(&models.WorkspaceMember{
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		Role:        role,
+		Source:      source,
+	}).Error
+}
+
+// syncDirectoryWorkspaces makes a user a member of the named workspaces, creating missing ones, and
+// removes them from the other workspaces that the directory source added them to
+// Memberships added by an administrator or another source are left alone
+func syncDirectoryWorkspaces(userID uint, source string, names []string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		workspaceIDs := []uint{}
+		for _, name := range names {
+			workspace, err := findOrCreateWorkspace(tx, name)
+			if err != nil {
+				return err
+			}
+			workspaceIDs = append(workspaceIDs, workspace.ID)
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WorkspaceMember{
+				WorkspaceID: workspace.ID,
+				UserID:      userID,
+				Role:        models.WorkspaceRoleMember,
+				Source:      source,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		query := tx.Where("user_id = ? AND source = ?", userID, source)
+		if len(workspaceIDs) > 0 {
+			query = query.Where("workspace_id NOT IN ?", workspaceIDs)
+		}
+		return query.Delete(&models.WorkspaceMember{}).Error
+	})
+}
+
+// findOrCreateWorkspace returns the workspace with a name, ignoring case, and creates it if there is none
+func findOrCreateWorkspace(tx *gorm.DB, name string) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := tx.Where("LOWER(name) = LOWER(?)", name).First(&workspace).Error
+	if err == gorm.ErrRecordNotFound {
+		workspace = models.Workspace{Name: name}
+		err = tx.Create(&workspace).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// isWorkspaceAdmin reports whether a user has the admin role in a workspace
+func isWorkspaceAdmin(userID, workspaceID uint) bool {
+	var count int64
+	database.DB.Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id = ? AND role = ?", workspaceID, userID, models.WorkspaceRoleAdmin).
+		Count(&count)
+	return count > 0
+}
+
+// workspaceNameTaken reports whether another workspace has a name, ignoring case
+func workspaceNameTaken(name string, exceptID uint) bool {
+	var count int64
+	database.DB.Model(&models.Workspace{}).Where("LOWER(name) = LOWER(?) AND id <> ?", name, exceptID).Count(&count)
+	return count > 0
+}
+
+// findWorkspace loads the workspace in the workspaceId parameter, responding with 404 if there is none
+func findWorkspace(c *gin.Context) (*models.Workspace, bool) {
+	var workspace models.Workspace
+	if err := database.DB.First(&workspace, c.Param("workspaceId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Workspace not found"})
+		return nil, false
+	}
+	return &workspace, true
+}
+
+func auditWorkspaceChange(c *gin.Context, action string, workspace *models.Workspace, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["workspace_id"] = workspace.ID
+	details["workspace"] = workspace.Name
+	middleware.Audit(c, models.AuditEvent{Action: action, Details: details})
+}
+
+// toWorkspaceResponses converts workspaces for API responses, counting their members
+// roles holds the current user's role in each workspace, nil when listing for administrators
+func toWorkspaceResponses(workspaces []models.Workspace, roles map[uint]string) []models.WorkspaceResponse {
+	workspaceIDs := make([]uint, len(workspaces))
+	for i, workspace := range workspaces {
+		workspaceIDs[i] = workspace.ID
+	}
+
+	memberCounts := make(map[uint]int64)
+	if len(workspaceIDs) > 0 {
+		var counts []struct {
+			WorkspaceID uint
+			Count       int64
+		}
+		database.DB.Model(&models.WorkspaceMember{}).Select("workspace_id, COUNT(*) AS count").
+			Where("workspace_id IN ?", workspaceIDs).Group("workspace_id").Scan(&counts)
+		for _, count := range counts {
+			memberCounts[count.WorkspaceID] = count.Count
+		}
+	}
+
+	response := make([]models.WorkspaceResponse, len(workspaces))
+	for i, workspace := range workspaces {
+		response[i] = models.WorkspaceResponse{
+			ID:          workspace.ID,
+			Name:        workspace.Name,
+			Role:        roles[workspace.ID],
+			MemberCount: memberCounts[workspace.ID],
+			CreatedAt:   workspace.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return response
+}
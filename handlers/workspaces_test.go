@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+// workspaceRouter serves the workspace and invitation routes as a user, with the administrator role if isAdmin
+func workspaceRouter(user *models.User, isAdmin bool) *gin.Engine {
+	r := gin.New()
+	r.POST("/signup", Signup)
+	r.GET("/invitations/:token", GetInvitation)
+
+	authed := r.Group("")
+	authed.Use(func(c *gin.Context) {
+		c.Set("user_id", user.ID)
+		c.Set("user_email", user.Email)
+		c.Set("is_admin", isAdmin)
+	})
+	authed.GET("/workspaces", ListMyWorkspaces)
+	authed.POST("/invitations", CreateInvitation)
+	authed.POST("/admin/workspaces", CreateWorkspace)
+	authed.DELETE("/admin/workspaces/:workspaceId", DeleteWorkspace)
+	authed.GET("/admin/workspaces/:workspaceId/members", ListWorkspaceMembers)
+	authed.PUT("/admin/workspaces/:workspaceId/members/:userId", SetWorkspaceMember)
+	authed.DELETE("/admin/workspaces/:workspaceId/members/:userId", RemoveWorkspaceMember)
+	return r
+}
+
+func serveJSON(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestWorkspaceInvitation(t *testing.T) {
+	admin := createTestUser(t, "workspace-admin@example.com")
+	lead := createTestUser(t, "workspace-lead@example.com")
+	outsider := createTestUser(t, "workspace-outsider@example.com")
+	asAdmin := workspaceRouter(admin, true)
+
+	w := serveJSON(asAdmin, http.MethodPost, "/admin/workspaces", `{"name": "Payments"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create workspace: status %d: %s", w.Code, w.Body.String())
+	}
+	var workspace models.WorkspaceResponse
+	json.Unmarshal(w.Body.Bytes(), &workspace)
+	if w := serveJSON(asAdmin, http.MethodPost, "/admin/workspaces", `{"name": "payments"}`); w.Code != http.StatusConflict {
+		t.Errorf("duplicate workspace name: status %d", w.Code)
+	}
+
+	membersPath := fmt.Sprintf("/admin/workspaces/%d/members", workspace.ID)
+	if w := serveJSON(asAdmin, http.MethodPut, fmt.Sprintf("%s/%d", membersPath, lead.ID), `{"role": "admin"}`); w.Code != http.StatusOK {
+		t.Fatalf("add workspace admin: status %d: %s", w.Code, w.Body.String())
+	}
+
+	invite := fmt.Sprintf(`{"email": "workspace-invitee@example.com", "workspace_id": %d}`, workspace.ID)
+	if w := serveJSON(workspaceRouter(outsider, false), http.MethodPost, "/invitations", invite); w.Code != http.StatusForbidden {
+		t.Errorf("invitation into a workspace by a non-member: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	w = serveJSON(workspaceRouter(lead, false), http.MethodPost, "/invitations", invite)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invitation by the workspace admin: status %d: %s", w.Code, w.Body.String())
+	}
+	var invitation models.InvitationResponse
+	json.Unmarshal(w.Body.Bytes(), &invitation)
+	token := invitation.URL[strings.Index(invitation.URL, "invite=")+len("invite="):]
+
+	if w := serveJSON(asAdmin, http.MethodGet, "/invitations/"+token, ""); !strings.Contains(w.Body.String(), `"workspace":"Payments"`) {
+		t.Errorf("invitation lookup doesn't name the workspace: %s", w.Body.String())
+	}
+
+	w = serveJSON(asAdmin, http.MethodPost, "/signup", `{"email": "workspace-invitee@example.com", "password": "invitee-password", "name": "Invitee", "invite_token": "`+token+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("signup: status %d: %s", w.Code, w.Body.String())
+	}
+	var invitee models.User
+	database.DB.Where("email = ?", "workspace-invitee@example.com").First(&invitee)
+
+	w = serveJSON(workspaceRouter(&invitee, false), http.MethodGet, "/workspaces", "")
+	var mine []models.WorkspaceResponse
+	json.Unmarshal(w.Body.Bytes(), &mine)
+	if len(mine) != 1 || mine[0].ID != workspace.ID || mine[0].Role != models.WorkspaceRoleMember || mine[0].MemberCount != 2 {
+		t.Errorf("workspaces of the invitee = %+v, want Payments as a member", mine)
+	}
+
+	if w := serveJSON(asAdmin, http.MethodDelete, fmt.Sprintf("%s/%d", membersPath, invitee.ID), ""); w.Code != http.StatusOK {
+		t.Errorf("remove member: status %d: %s", w.Code, w.Body.String())
+	}
+	w = serveJSON(asAdmin, http.MethodGet, membersPath, "")
+	var members []models.WorkspaceMemberResponse
+	json.Unmarshal(w.Body.Bytes(), &members)
+	if len(members) != 1 || members[0].UserID != lead.ID || members[0].Role != models.WorkspaceRoleAdmin {
+		t.Errorf("members = %+v, want only the workspace admin", members)
+	}
+}
+
+func TestDeleteWorkspaceRevokesInvitations(t *testing.T) {
+	admin := createTestUser(t, "workspace-delete-admin@example.com")
+	asAdmin := workspaceRouter(admin, true)
+
+	w := serveJSON(asAdmin, http.MethodPost, "/admin/workspaces", `{"name": "Deleted team"}`)
+	var workspace models.WorkspaceResponse
+	json.Unmarshal(w.Body.Bytes(), &workspace)
+
+	w = serveJSON(asAdmin, http.MethodPost, "/invitations", fmt.Sprintf(`{"email": "workspace-deleted-invitee@example.com", "workspace_id": %d, "workspace_role": "admin"}`, workspace.ID))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("invitation: status %d: %s", w.Code, w.Body.String())
+	}
+	var invitation models.InvitationResponse
+	json.Unmarshal(w.Body.Bytes(), &invitation)
+	if invitation.WorkspaceRole != models.WorkspaceRoleAdmin {
+		t.Errorf("invitation role = %q, want admin", invitation.WorkspaceRole)
+	}
+
+	if w := serveJSON(asAdmin, http.MethodDelete, fmt.Sprintf("/admin/workspaces/%d", workspace.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("delete workspace: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var stored models.Invitation
+	database.DB.First(&stored, invitation.ID)
+	if stored.RevokedAt == nil {
+		t.Error("invitation into a deleted workspace is still pending")
+	}
+}
+
+func TestDeleteUserLeavesWorkspaces(t *testing.T) {
+	admin := createTestUser(t, "workspace-user-delete-admin@example.com")
+	member := createTestUser(t, "workspace-user-deleted@example.com")
+	workspace := models.Workspace{Name: "Deleted member team"}
+	database.DB.Create(&workspace)
+	if err := setWorkspaceMember(database.DB, workspace.ID, member.ID, models.WorkspaceRoleMember, models.WorkspaceSourceManual); err != nil {
+		t.Fatal(err)
+	}
+
+	r := workspaceRouter(admin, true)
+	r.DELETE("/admin/users/:userId", DeleteUser)
+	if w := serveJSON(r, http.MethodDelete, fmt.Sprintf("/admin/users/%d", member.ID), ""); w.Code != http.StatusOK {
+		t.Fatalf("delete user: status %d: %s", w.Code, w.Body.String())
+	}
+
+	// The user is only soft-deleted, their memberships must not keep counting
+	var count int64
+	database.DB.Model(&models.WorkspaceMember{}).Where("workspace_id = ?", workspace.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d memberships left after deleting the user", count)
+	}
+}
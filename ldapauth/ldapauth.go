@@ -0,0 +1,158 @@
+// Package ldapauth checks passwords against an LDAP directory such as OpenLDAP or Active Directory
+package ldapauth
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/thorved/chartdb-backend/config"
+)
+
+const timeout = 10 * time.Second
+
+// ErrInvalidCredentials is returned when the user doesn't exist, isn't unique or the password is wrong
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// Entry is the directory entry of a user who signed in
+type Entry struct {
+	DN     string
+	ID     string // Value of the ID attribute, or the DN
+	Email  string
+	Name   string
+	Groups []string // Group DNs
+}
+
+// Authenticate finds the user by their login name and verifies the password by binding as them
+func Authenticate(cfg config.LDAPConfig, username, password string) (*Entry, error) {
+	// An empty password would make the bind unauthenticated, which many servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := bindService(conn, cfg); err != nil {
+		return nil, err
+	}
+
+	attributes := []string{cfg.EmailAttribute, cfg.NameAttribute, cfg.GroupAttribute}
+	if cfg.IDAttribute != "" {
+		attributes = append(attributes, cfg.IDAttribute)
+	}
+	filter := strings.ReplaceAll(cfg.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(timeout.Seconds()), false,
+		filter, attributes, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap: user search failed: %w", err)
+	}
+	// A login name matching several entries is ambiguous, so it is refused like an unknown one
+	if err != nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	userEntry := result.Entries[0]
+
+	if err := conn.Bind(userEntry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind failed: %w", err)
+	}
+
+	entry := &Entry{
+		DN:     userEntry.DN,
+		ID:     userEntry.DN,
+		Email:  strings.TrimSpace(userEntry.GetAttributeValue(cfg.EmailAttribute)),
+		Name:   userEntry.GetAttributeValue(cfg.NameAttribute),
+		Groups: userEntry.GetAttributeValues(cfg.GroupAttribute),
+	}
+	if cfg.IDAttribute != "" {
+		// Binary IDs such as Active Directory's objectGUID are stored hex-encoded
+		if raw := userEntry.GetRawAttributeValue(cfg.IDAttribute); len(raw) > 0 {
+			if utf8.Valid(raw) {
+				entry.ID = string(raw)
+			} else {
+				entry.ID = hex.EncodeToString(raw)
+			}
+		}
+	}
+
+	if cfg.GroupFilter != "" {
+		// The user may not be allowed to read groups, so search them as the service account
+		if err := bindService(conn, cfg); err != nil {
+			return nil, err
+		}
+		groupFilter := strings.NewReplacer(
+			"{dn}", ldap.EscapeFilter(userEntry.DN),
+			"{username}", ldap.EscapeFilter(username),
+		).Replace(cfg.GroupFilter)
+		groups, err := conn.Search(ldap.NewSearchRequest(
+			cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(timeout.Seconds()), false,
+			groupFilter, []string{"dn"}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("ldap: group search failed: %w", err)
+		}
+		for _, group := range groups.Entries {
+			entry.Groups = append(entry.Groups, group.DN)
+		}
+	}
+
+	return entry, nil
+}
+
+// InGroup reports whether the entry is a member of one of the groups, given by DN or CN
+func (e *Entry) InGroup(groups []string) bool {
+	for _, memberOf := range e.Groups {
+		name := ""
+		if dn, err := ldap.ParseDN(memberOf); err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			name = dn.RDNs[0].Attributes[0].Value
+		}
+		for _, group := range groups {
+			if strings.EqualFold(group, memberOf) || (name != "" && strings.EqualFold(group, name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func dial(cfg config.LDAPConfig) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	conn, err := ldap.DialURL(cfg.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: failed to connect: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap: StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func bindService(conn *ldap.Conn, cfg config.LDAPConfig) error {
+	if cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+		return fmt.Errorf("ldap: service account bind failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes each email as an .eml file, for development and tests
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the message to a new file in Dir
+func (m *FileMailer) Send(msg Message) error {
+	data, err := buildMessage(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(stripNewlines(msg.To))
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000Z"), recipient)
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0600)
+}
@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Default is the mailer used by the application, configured by Init
+var Default Mailer = &LogMailer{}
+
+// Init configures the default mailer from the environment
+// MAIL_DRIVER is "smtp", "file" (writes .eml files to MAIL_DIR) or "log" (default, development only).
+// In release mode the log driver is refused, since emails carry live reset and invitation links.
+func Init() error {
+	driver := strings.TrimSpace(os.Getenv("MAIL_DRIVER"))
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "ChartDB Sync <noreply@localhost>"
+	}
+
+	switch driver {
+	case "", "log":
+		if os.Getenv("GIN_MODE") == "release" {
+			return fmt.Errorf("MAIL_DRIVER must be smtp or file in release mode; the log driver writes password reset and invitation links to the server log")
+		}
+		Default = &LogMailer{From: from}
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create mail directory: %w", err)
+		}
+		Default = &FileMailer{Dir: dir, From: from}
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return fmt.Errorf("MAIL_DRIVER is smtp but SMTP_HOST is not set")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		Default = &SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	default:
+		return fmt.Errorf("unsupported MAIL_DRIVER '%s' (use smtp, file or log)", driver)
+	}
+
+	// Links in emails must not be built from the client-supplied Host header, or anyone could
+	// have reset and invitation links sent out that point at their own server
+	if _, logOnly := Default.(*LogMailer); !logOnly && strings.TrimSpace(os.Getenv("APP_BASE_URL")) == "" {
+		return fmt.Errorf("MAIL_DRIVER is %s but APP_BASE_URL is not set", driver)
+	}
+
+	log.Printf("Mail driver: %T", Default)
+	return nil
+}
+
+// Send sends a message with the default mailer
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
+
+// LogMailer writes emails to the application log instead of sending them
+type LogMailer struct {
+	From string
+}
+
+// Send logs the message
+func (m *LogMailer) Send(msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
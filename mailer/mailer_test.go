@@ -0,0 +1,26 @@
+package mailer
+
+import "testing"
+
+func TestInitRefusesLogDriverInRelease(t *testing.T) {
+	previous := Default
+	defer func() { Default = previous }()
+
+	t.Setenv("GIN_MODE", "release")
+	for _, driver := range []string{"", "log"} {
+		t.Setenv("MAIL_DRIVER", driver)
+		if err := Init(); err == nil {
+			t.Errorf("MAIL_DRIVER=%q accepted in release mode", driver)
+		}
+	}
+
+	t.Setenv("MAIL_DRIVER", "file")
+	t.Setenv("MAIL_DIR", t.TempDir())
+	t.Setenv("APP_BASE_URL", "https://chartdb.example.com")
+	if err := Init(); err != nil {
+		t.Fatalf("file driver in release mode: %v", err)
+	}
+	if _, ok := Default.(*FileMailer); !ok {
+		t.Errorf("Default is %T, want *FileMailer", Default)
+	}
+}
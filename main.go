@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/static"
@@ -10,6 +11,9 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/thorved/chartdb-backend/config"
 	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/handlers"
+	"github.com/thorved/chartdb-backend/mailer"
+	"github.com/thorved/chartdb-backend/middleware"
 	"github.com/thorved/chartdb-backend/routes"
 )
 
@@ -22,12 +26,72 @@ func main() {
 	// Initialize database
 	database.InitDB()
 
+	// Initialize JWT signing keys
+	if err := middleware.InitSigningKeys(); err != nil {
+		log.Fatal("Failed to initialize JWT signing keys: ", err)
+	}
+
+	// Initialize mailer
+	if err := mailer.Init(); err != nil {
+		log.Fatal("Failed to initialize mailer: ", err)
+	}
+
+	// Initialize registration settings
+	if err := config.InitRegistration(); err != nil {
+		log.Fatal("Failed to initialize registration settings: ", err)
+	}
+	log.Printf("Registration mode: %s", config.RegistrationMode)
+
+	// Bootstrap administrators
+	config.InitAdmin()
+	if err := database.PromoteAdmins(config.AdminEmails); err != nil {
+		log.Fatal("Failed to promote ADMIN_EMAIL accounts: ", err)
+	}
+
+	// Remove audit events older than AUDIT_RETENTION
+	middleware.StartAuditPruning()
+
+	// Erase deleted accounts once their grace period ends
+	if err := config.InitAccountDeletion(); err != nil {
+		log.Fatal("Failed to initialize account deletion: ", err)
+	}
+	handlers.StartAccountPurge()
+
+	// Erase diagrams that have been in the trash longer than TRASH_RETENTION_DAYS
+	if err := config.InitTrash(); err != nil {
+		log.Fatal("Failed to initialize trash: ", err)
+	}
+	handlers.StartTrashPurge()
+
+	// Initialize passkey (WebAuthn) support
+	if err := config.InitWebAuthn(); err != nil {
+		log.Fatal("Failed to initialize WebAuthn: ", err)
+	}
+
+	// Initialize LDAP configuration
+	if err := config.InitLDAP(); err != nil {
+		log.Fatal("Failed to initialize LDAP: ", err)
+	}
+	if config.LDAPEnabled {
+		log.Printf("LDAP authentication enabled (%s)", config.LDAP.URL)
+	}
+
+	// Initialize SCIM provisioning
+	if err := config.InitSCIM(); err != nil {
+		log.Fatal("Failed to initialize SCIM: ", err)
+	}
+	if config.SCIMEnabled {
+		log.Println("SCIM provisioning enabled at /scim/v2")
+	}
+
 	// Initialize OIDC configuration
-	log.Printf("OIDC_ENABLED env value: '%s'", os.Getenv("OIDC_ENABLED"))
 	if err := config.InitOIDC(); err != nil {
 		log.Printf("Warning: Failed to initialize OIDC: %v", err)
-	} else if config.OIDCEnabled {
-		log.Println("OIDC authentication enabled")
+	}
+	if config.OIDCEnabled {
+		for _, provider := range config.OIDCProviders {
+			log.Printf("OIDC provider enabled: %s (%s)", provider.Name, provider.IssuerURL)
+		}
 	} else {
 		log.Println("OIDC authentication disabled")
 	}
@@ -39,11 +103,25 @@ func main() {
 
 	r := gin.Default()
 
+	// Only trust X-Forwarded-For from these proxies, so clients can't pick the IP that is rate limited
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		var trusted []string
+		if proxies != "none" {
+			for _, proxy := range strings.Split(proxies, ",") {
+				trusted = append(trusted, strings.TrimSpace(proxy))
+			}
+		}
+		if err := r.SetTrustedProxies(trusted); err != nil {
+			log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+		}
+	}
+
 	// CORS configuration
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "X-ChartDB-Client"}
+	config.ExposeHeaders = []string{"X-Total-Count", "X-Page", "X-Per-Page"}
 	config.AllowCredentials = true
 	r.Use(cors.New(config))
 
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+const (
+	defaultAuditRetention = 90 * 24 * time.Hour
+	auditPruneInterval    = time.Hour
+)
+
+// auditRetention returns how long audit events are kept from AUDIT_RETENTION (e.g. "2160h", "0" keeps them forever)
+func auditRetention() time.Duration {
+	if os.Getenv("AUDIT_RETENTION") == "0" {
+		return 0
+	}
+	return durationFromEnv("AUDIT_RETENTION", defaultAuditRetention)
+}
+
+// Audit records an event with the client's IP address and user agent
+// The signed-in user is recorded unless the event names a user or email. Audit events must never
+// fail the request, so errors are only logged
+func Audit(c *gin.Context, event models.AuditEvent) {
+	if event.UserID == nil && event.Email == "" {
+		if userID := GetUserID(c); userID != 0 {
+			event.UserID = &userID
+			event.Email = GetUserEmail(c)
+		}
+	}
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Warning: failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// AuditUser records an event done by or to the given user, e.g. a login before the session exists
+func AuditUser(c *gin.Context, action string, user *models.User, details map[string]interface{}) {
+	Audit(c, models.AuditEvent{Action: action, UserID: &user.ID, Email: user.Email, Details: details})
+}
+
+// AuditDiagram records an event about a diagram by the signed-in user
+func AuditDiagram(c *gin.Context, action string, diagram *models.Diagram, details map[string]interface{}) {
+	Audit(c, models.AuditEvent{Action: action, DiagramID: &diagram.ID, Details: details})
+}
+
+// StartAuditPruning removes audit events older than AUDIT_RETENTION, now and then every hour
+func StartAuditPruning() {
+	go func() {
+		pruneAuditEvents()
+
+		ticker := time.NewTicker(auditPruneInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			pruneAuditEvents()
+		}
+	}()
+}
+
+func pruneAuditEvents() {
+	retention := auditRetention()
+	if retention == 0 {
+		return
+	}
+
+	result := database.DB.Where("created_at < ?", time.Now().Add(-retention)).Delete(&models.AuditEvent{})
+	if result.Error != nil {
+		log.Printf("Warning: failed to prune audit events: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Pruned %d audit event(s) older than %s", result.RowsAffected, retention)
+	}
+}
@@ -2,26 +2,16 @@ package middleware
 
 import (
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/thorved/chartdb-backend/config"
 	"github.com/thorved/chartdb-backend/database"
 	"github.com/thorved/chartdb-backend/models"
 )
 
-var jwtSecret []byte
-
-func init() {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "chartdb-sync-secret-key-change-in-production"
-	}
-	jwtSecret = []byte(secret)
-}
-
 // Claims represents JWT claims
 type Claims struct {
 	UserID uint   `json:"user_id"`
@@ -29,30 +19,29 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID uint, email string) (string, error) {
-	expirationTime := time.Now().Add(7 * 24 * time.Hour) // 7 days
+// GenerateToken creates a new JWT token for a user session
+// tokenID is stored as the JWT ID and links the token to its Session record
+func GenerateToken(userID uint, email string, tokenID string) (string, error) {
+	expirationTime := time.Now().Add(accessTokenTTL())
 
 	claims := &Claims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "chartdb-backend",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signingKeys.signToken(claims)
 }
 
 // ValidateToken validates a JWT token and returns the claims
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, signingKeys.verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -66,13 +55,19 @@ func ValidateToken(tokenString string) (*Claims, error) {
 }
 
 // AuthMiddleware is the JWT authentication middleware
-// Supports both cookie-based and header-based authentication
+// Supports both cookie-based and header-based authentication, as well as personal access tokens
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Personal access tokens are only accepted from the Authorization header
+		if token := bearerToken(c); strings.HasPrefix(token, models.PersonalAccessTokenPrefix) {
+			authenticatePersonalAccessToken(c, token)
+			return
+		}
+
 		var tokenString string
 
 		// First, try to get token from cookie
-		cookieToken, err := c.Cookie("auth_token")
+		cookieToken, err := c.Cookie(AuthCookieName)
 		if err == nil && cookieToken != "" {
 			tokenString = cookieToken
 		} else {
@@ -84,14 +79,12 @@ func AuthMiddleware() gin.HandlerFunc {
 				return
 			}
 
-			// Extract token from "Bearer <token>"
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || parts[0] != "Bearer" {
+			tokenString = BearerToken(c)
+			if tokenString == "" {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
 				c.Abort()
 				return
 			}
-			tokenString = parts[1]
 		}
 
 		claims, err := ValidateToken(tokenString)
@@ -101,29 +94,90 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Check if this is the current active session (single-session enforcement)
+		// Check that the session has not been revoked
+		var session models.Session
+		if err := database.DB.Where("token_id = ? AND user_id = ?", claims.ID, claims.UserID).First(&session).Error; err != nil || !session.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please login again."})
+			c.Abort()
+			return
+		}
+
 		var user models.User
 		if err := database.DB.First(&user, claims.UserID).Error; err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 			c.Abort()
 			return
 		}
-
-		// Check if token matches the current active session
-		if user.CurrentToken != tokenString {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please login again."})
+		if !user.IsActive() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "This account has been deactivated"})
 			c.Abort()
 			return
 		}
 
+		touchSession(&session)
+
 		// Set user info in context
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
+		c.Set("is_admin", user.IsAdmin)
+		c.Set("email_verified", user.IsEmailVerified())
+		c.Set("two_factor_setup_required", twoFactorSetupRequired(&user))
+		c.Set("session_id", session.ID)
+
+		c.Next()
+	}
+}
+
+// AdminMiddleware only lets administrators through
+// Must be used after AuthMiddleware
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Administrator access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users who haven't confirmed their email address
+// Only enforced when REGISTRATION_MODE is verify_email. Must be used after AuthMiddleware
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if config.EmailVerificationRequired() && !c.GetBool("email_verified") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireTwoFactor rejects local users who haven't set up 2FA while an administrator requires it
+// Must be used after AuthMiddleware; the 2FA setup routes themselves must not use it
+func RequireTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("two_factor_setup_required") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Two-factor authentication must be set up first",
+				"code":  "two_factor_setup_required",
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
 
+// twoFactorSetupRequired reports whether a user must enroll in 2FA before using the app
+func twoFactorSetupRequired(user *models.User) bool {
+	return user.IsLocal() && !user.TwoFactorEnabled() && database.GetBoolSetting(models.SettingRequireTwoFactor)
+}
+
 // GetUserID extracts user ID from context
 func GetUserID(c *gin.Context) uint {
 	userID, exists := c.Get("user_id")
@@ -141,3 +195,21 @@ func GetUserEmail(c *gin.Context) string {
 	}
 	return email.(string)
 }
+
+// IsAdmin reports whether the authenticated user is an administrator
+func IsAdmin(c *gin.Context) bool {
+	isAdmin, exists := c.Get("is_admin")
+	if !exists {
+		return false
+	}
+	return isAdmin.(bool)
+}
+
+// BearerToken returns the token of a "Bearer <token>" Authorization header, or "" without one
+func BearerToken(c *gin.Context) string {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return ""
+	}
+	return parts[1]
+}
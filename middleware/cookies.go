@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AuthCookieName    = "auth_token"
+	RefreshCookieName = "refresh_token"
+
+	// refreshCookiePath limits the refresh cookie to the sync app and its API
+	refreshCookiePath = "/sync"
+)
+
+// SetSessionCookies stores the session tokens in httpOnly cookies
+// Both cookies live as long as the refresh token; the access token's own expiry is enforced by its JWT claims
+func SetSessionCookies(c *gin.Context, tokens *SessionTokens) {
+	// In production, set Secure: true and SameSite: http.SameSiteStrictMode
+	secure := os.Getenv("GIN_MODE") == "release"
+	maxAge := int(refreshTokenTTL().Seconds())
+	c.SetCookie(AuthCookieName, tokens.AccessToken, maxAge, "/", "", secure, true)
+	c.SetCookie(RefreshCookieName, tokens.RefreshToken, maxAge, refreshCookiePath, "", secure, true)
+}
+
+// ClearSessionCookies clears the authentication cookies
+func ClearSessionCookies(c *gin.Context) {
+	c.SetCookie(AuthCookieName, "", -1, "/", "", false, true)
+	c.SetCookie(RefreshCookieName, "", -1, refreshCookiePath, "", false, true)
+}
+
+// SecureRequest reports whether the request was served over HTTPS, directly or through a TLS-terminating proxy
+func SecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
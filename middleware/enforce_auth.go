@@ -17,11 +17,27 @@ func EnforceAuthMiddleware() gin.HandlerFunc {
 		publicPaths := []string{
 			"/sync/login",
 			"/sync/signup",
+			"/sync/forgot-password",
+			"/sync/reset-password",
+			"/sync/verify-email",
+			"/sync/link-account",
+			"/sync/restore-account",
 			"/sync/api/auth/login",
 			"/sync/api/auth/signup",
-			"/sync/api/auth/oidc/login",
-			"/sync/api/auth/oidc/callback",
-			"/sync/api/auth/oidc/enabled",
+			"/sync/api/auth/refresh",
+			"/sync/api/auth/logout",
+			"/sync/api/auth/password/reset-request",
+			"/sync/api/auth/password/reset",
+			"/sync/api/auth/registration",
+			"/sync/api/auth/verify-email",
+			"/sync/api/auth/invitations",
+			"/sync/api/auth/2fa/verify",
+			"/sync/api/auth/passkeys/login",
+			"/sync/api/auth/oidc",
+			"/sync/api/auth/identities/link",
+			"/sync/api/auth/restore-account",
+			"/sync/share",
+			"/sync/api/share",
 			"/health",
 		}
 
@@ -32,11 +48,21 @@ func EnforceAuthMiddleware() gin.HandlerFunc {
 			}
 		}
 
+		// API clients sending an Authorization header (e.g. personal access tokens)
+		// are authenticated by AuthMiddleware on the protected routes
+		if strings.HasPrefix(path, "/sync/api/") && c.GetHeader("Authorization") != "" {
+			c.Next()
+			return
+		}
+
+		isAPIRequest := strings.HasPrefix(path, "/sync/api/")
+
 		// Check if user is authenticated via cookie
-		token, err := c.Cookie("auth_token")
-		if err != nil || token == "" {
+		token, _ := c.Cookie(AuthCookieName)
+		refreshToken, _ := c.Cookie(RefreshCookieName)
+		if token == "" && refreshToken == "" {
 			// Check if this is an API request
-			if strings.HasPrefix(path, "/sync/api/") {
+			if isAPIRequest {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
 			} else {
 				// Redirect to login page for browser requests
@@ -48,13 +74,29 @@ func EnforceAuthMiddleware() gin.HandlerFunc {
 
 		// Validate token
 		claims, err := ValidateToken(token)
-		if err != nil {
-			// Clear invalid cookie
-			c.SetCookie("auth_token", "", -1, "/", "", false, true)
 
-			if strings.HasPrefix(path, "/sync/api/") {
+		// Access tokens are short-lived; renew them from the refresh cookie on page loads.
+		// API clients get a 401 and call the refresh endpoint themselves.
+		if err != nil && !isAPIRequest && refreshToken != "" {
+			tokens, refreshErr := RefreshSession(refreshToken)
+			if refreshErr == ErrRefreshInProgress {
+				// A parallel request just rotated the cookies, reload with the new ones
+				c.Redirect(http.StatusTemporaryRedirect, c.Request.URL.RequestURI())
+				c.Abort()
+				return
+			}
+			if refreshErr == nil {
+				SetSessionCookies(c, tokens)
+				claims, err = ValidateToken(tokens.AccessToken)
+			}
+		}
+
+		if err != nil {
+			if isAPIRequest {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
 			} else {
+				// Clear invalid cookies
+				ClearSessionCookies(c)
 				c.Redirect(http.StatusTemporaryRedirect, "/sync/login")
 			}
 			c.Abort()
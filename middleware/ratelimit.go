@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+)
+
+const (
+	defaultAuthRateLimit    = 20 // requests per IP per minute, per endpoint
+	authRateLimitWindow     = time.Minute
+	defaultLockoutThreshold = 10
+	defaultLockoutDuration  = 15 * time.Minute
+
+	// Failures allowed before backoff kicks in; an IP may be shared by many users (NAT, offices)
+	accountFreeFailures = 3
+	ipFreeFailures      = 10
+	backoffBase         = time.Second
+
+	// maxAccountPeekBytes bounds how much of a request body is read to find the account
+	maxAccountPeekBytes = 64 << 10
+
+	authFailureKey = "auth_failure"
+	authPendingKey = "auth_pending"
+)
+
+// RateLimiter stores the counters used by AuthRateLimit
+var RateLimiter RateLimitStore = NewMemoryRateLimitStore()
+
+// authRateLimit returns the request limit per IP and minute from AUTH_RATE_LIMIT (0 disables it)
+func authRateLimit() int {
+	return intFromEnv("AUTH_RATE_LIMIT", defaultAuthRateLimit)
+}
+
+// lockoutThreshold returns the failed attempts that lock an account from AUTH_LOCKOUT_THRESHOLD (0 disables lockout)
+func lockoutThreshold() int {
+	return intFromEnv("AUTH_LOCKOUT_THRESHOLD", defaultLockoutThreshold)
+}
+
+// lockoutDuration returns how long a locked account stays locked from AUTH_LOCKOUT_DURATION (e.g. "15m")
+// Failures are also forgotten after this long without another one
+func lockoutDuration() time.Duration {
+	return durationFromEnv("AUTH_LOCKOUT_DURATION", defaultLockoutDuration)
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Warning: invalid %s '%s', using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+// AuthRateLimit protects an endpoint that checks credentials against brute force
+// Requests are limited per IP address, and failed attempts (reported by the handler with
+// RecordAuthFailure) slow down both the IP address and the targeted account with exponential
+// backoff. After AUTH_LOCKOUT_THRESHOLD failures the account is locked for AUTH_LOCKOUT_DURATION.
+// action names the endpoint for the request limit; account returns the account a request
+// targets, or is nil when only the IP address is limited
+func AuthRateLimit(action string, account func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+		ipKey := "ip:" + ip
+
+		if limit := authRateLimit(); limit > 0 {
+			count, resetAt := RateLimiter.Increment("requests:"+action+":"+ip, authRateLimitWindow, now)
+			if count > limit {
+				tooManyAttempts(c, resetAt.Sub(now), "Too many requests. Please try again later.")
+				return
+			}
+		}
+
+		if wait := backoffRemaining(RateLimiter.Failures(ipKey, now), ipFreeFailures, now); wait > 0 {
+			tooManyAttempts(c, wait, "Too many failed attempts. Please try again later.")
+			return
+		}
+
+		var accountName, accountKey string
+		if account != nil {
+			if accountName = account(c); accountName != "" {
+				accountKey = "account:" + accountName
+			}
+		}
+
+		if accountKey != "" {
+			record := RateLimiter.Failures(accountKey, now)
+			if threshold := lockoutThreshold(); threshold > 0 && record.Count >= threshold {
+				if wait := record.LastFailure.Add(lockoutDuration()).Sub(now); wait > 0 {
+					tooManyAttempts(c, wait, "This account is temporarily locked after too many failed attempts. Please try again later.")
+					return
+				}
+			}
+			if wait := backoffRemaining(record, accountFreeFailures, now); wait > 0 {
+				tooManyAttempts(c, wait, "Too many failed attempts. Please try again later.")
+				return
+			}
+		}
+
+		c.Next()
+
+		if c.GetBool(authFailureKey) {
+			RateLimiter.AddFailure(ipKey, lockoutDuration(), now)
+			if accountKey != "" {
+				record := RateLimiter.AddFailure(accountKey, lockoutDuration(), now)
+				if threshold := lockoutThreshold(); threshold > 0 && record.Count == threshold {
+					auditLockout(c, accountName, record.Count)
+				}
+			}
+		} else if accountKey != "" && c.Writer.Status() < http.StatusBadRequest && !c.GetBool(authPendingKey) {
+			RateLimiter.ResetFailures(accountKey)
+		}
+	}
+}
+
+// RecordAuthFailure tells AuthRateLimit that the request presented wrong credentials
+func RecordAuthFailure(c *gin.Context) {
+	c.Set(authFailureKey, true)
+}
+
+// RecordAuthPending tells AuthRateLimit that the credentials were right but the login needs
+// another step (a second factor), so the failures of the account are kept until it completes
+func RecordAuthPending(c *gin.Context) {
+	c.Set(authPendingKey, true)
+}
+
+// LoginAccount returns the email in the JSON request body, for use with AuthRateLimit
+func LoginAccount(c *gin.Context) string {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !peekJSONBody(c, &req) {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// LoginChallengeAccount returns the email of the user a two-factor login challenge in the JSON
+// request body belongs to, for use with AuthRateLimit
+func LoginChallengeAccount(c *gin.Context) string {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	if !peekJSONBody(c, &req) || req.ChallengeToken == "" {
+		return ""
+	}
+
+	var user models.User
+	if err := database.DB.Joins("JOIN login_challenges ON login_challenges.user_id = users.id").
+		Where("login_challenges.token_hash = ?", HashOpaqueToken(req.ChallengeToken)).
+		First(&user).Error; err != nil {
+		return ""
+	}
+	return strings.ToLower(user.Email)
+}
+
+// peekJSONBody decodes the JSON request body into v and puts the body back so the handler can still bind it
+func peekJSONBody(c *gin.Context, v interface{}) bool {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAccountPeekBytes))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(body, v) == nil
+}
+
+// SessionAccount returns the signed-in user's email, for use with AuthRateLimit
+// Must be used after AuthMiddleware
+func SessionAccount(c *gin.Context) string {
+	return strings.ToLower(GetUserEmail(c))
+}
+
+// backoffRemaining returns how long to wait before the next attempt after the recorded failures
+// The delay doubles with each failure past the free ones, up to the lockout duration
+func backoffRemaining(record FailureRecord, freeFailures int, now time.Time) time.Duration {
+	if record.Count < freeFailures {
+		return 0
+	}
+
+	maxDelay := lockoutDuration()
+	delay := maxDelay
+	if exp := record.Count - freeFailures; exp < 32 && backoffBase<<exp < maxDelay {
+		delay = backoffBase << exp
+	}
+	return record.LastFailure.Add(delay).Sub(now)
+}
+
+// tooManyAttempts rejects the request with 429 and a Retry-After header
+func tooManyAttempts(c *gin.Context, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": seconds})
+	c.Abort()
+}
+
+// auditLockout records that an account was locked, with the address of the last failed attempt
+func auditLockout(c *gin.Context, email string, failures int) {
+	Audit(c, models.AuditEvent{
+		Action: models.AuditAccountLocked,
+		Email:  email,
+		Details: map[string]interface{}{
+			"failures":   failures,
+			"locked_for": lockoutDuration().String(),
+		},
+	})
+}
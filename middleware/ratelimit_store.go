@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// FailureRecord counts the recent failed attempts for an IP address or account
+type FailureRecord struct {
+	Count       int
+	LastFailure time.Time
+}
+
+// RateLimitStore keeps the counters behind AuthRateLimit
+// The in-memory store only works for a single instance; several instances behind a load balancer
+// need an implementation backed by a shared store (e.g. Redis) assigned to RateLimiter
+type RateLimitStore interface {
+	// Increment counts a request for key in the fixed window that is running,
+	// returning the count so far and when the window resets
+	Increment(key string, window time.Duration, now time.Time) (int, time.Time)
+
+	// Failures returns the failures recorded for key, or a zero record
+	Failures(key string, now time.Time) FailureRecord
+
+	// AddFailure records a failed attempt for key and returns the updated record
+	// The record is forgotten once ttl passes without another failure
+	AddFailure(key string, ttl time.Duration, now time.Time) FailureRecord
+
+	// ResetFailures forgets the failures recorded for key
+	ResetFailures(key string)
+}
+
+// memorySweepInterval is how often expired entries are dropped from the in-memory store
+const memorySweepInterval = time.Minute
+
+type memoryCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+type memoryFailures struct {
+	record    FailureRecord
+	expiresAt time.Time
+}
+
+// MemoryRateLimitStore is a RateLimitStore kept in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryCounter
+	failures  map[string]*memoryFailures
+	nextSweep time.Time
+}
+
+// NewMemoryRateLimitStore creates an empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		counters: make(map[string]*memoryCounter),
+		failures: make(map[string]*memoryFailures),
+	}
+}
+
+func (s *MemoryRateLimitStore) Increment(key string, window time.Duration, now time.Time) (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	counter, ok := s.counters[key]
+	if !ok || !now.Before(counter.resetAt) {
+		counter = &memoryCounter{resetAt: now.Add(window)}
+		s.counters[key] = counter
+	}
+	counter.count++
+	return counter.count, counter.resetAt
+}
+
+func (s *MemoryRateLimitStore) Failures(key string, now time.Time) FailureRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.failures[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		return FailureRecord{}
+	}
+	return entry.record
+}
+
+func (s *MemoryRateLimitStore) AddFailure(key string, ttl time.Duration, now time.Time) FailureRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	entry, ok := s.failures[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		entry = &memoryFailures{}
+		s.failures[key] = entry
+	}
+	entry.record.Count++
+	entry.record.LastFailure = now
+	entry.expiresAt = now.Add(ttl)
+	return entry.record
+}
+
+func (s *MemoryRateLimitStore) ResetFailures(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+}
+
+// sweep drops expired entries so the maps don't grow without bound
+// Must be called with s.mu held
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(memorySweepInterval)
+
+	for key, counter := range s.counters {
+		if !now.Before(counter.resetAt) {
+			delete(s.counters, key)
+		}
+	}
+	for key, entry := range s.failures {
+		if !now.Before(entry.expiresAt) {
+			delete(s.failures, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thorved/chartdb-backend/config"
+)
+
+// SCIMAuth only lets the provisioning client holding SCIM_TOKEN through
+// Errors use the SCIM error format, as that's what SCIM clients understand
+func SCIMAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.SCIMEnabled {
+			SCIMError(c, http.StatusNotFound, "", "SCIM provisioning is disabled")
+			c.Abort()
+			return
+		}
+
+		// Compare hashes so the comparison takes the same time whatever the token's length
+		token := HashOpaqueToken(bearerToken(c))
+		if subtle.ConstantTimeCompare([]byte(token), []byte(HashOpaqueToken(config.SCIMToken))) != 1 {
+			SCIMError(c, http.StatusUnauthorized, "", "Invalid SCIM token")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// SCIMError responds with a SCIM error message (RFC 7644, section 3.12)
+// scimType is optional, e.g. "uniqueness" or "invalidFilter"
+func SCIMError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	c.Header("Content-Type", "application/scim+json; charset=utf-8")
+	c.JSON(status, body)
+}
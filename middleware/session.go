@@ -0,0 +1,399 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/thorved/chartdb-backend/database"
+	"github.com/thorved/chartdb-backend/models"
+	"gorm.io/gorm"
+)
+
+const (
+	refreshTokenPrefix = "rt_"
+
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 7 * 24 * time.Hour // 7 days
+
+	// refreshReuseGracePeriod tolerates two tabs refreshing with the same token at once
+	refreshReuseGracePeriod = 10 * time.Second
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrRefreshInProgress   = errors.New("refresh token was just rotated")
+	ErrAccountDeactivated  = errors.New("account is deactivated")
+)
+
+// SessionTokens are the tokens issued when a session starts or is refreshed
+type SessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int  // Access token lifetime in seconds
+	SessionID    uint // Session the tokens belong to
+}
+
+// accessTokenTTL returns the access token lifetime from ACCESS_TOKEN_TTL (e.g. "15m")
+func accessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// refreshTokenTTL returns the refresh token lifetime from REFRESH_TOKEN_TTL (e.g. "168h")
+// A session stays signed in as long as it is refreshed within this window
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid %s '%s', using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+// MaxSessionsPerUser returns the cap on concurrent sessions per user, 0 for unlimited
+// Administrators set it in the server settings; until they do, MAX_SESSIONS_PER_USER applies
+func MaxSessionsPerUser() int {
+	return database.GetIntSetting(models.SettingMaxSessionsPerUser, defaultMaxSessionsPerUser())
+}
+
+// defaultMaxSessionsPerUser returns the session cap from MAX_SESSIONS_PER_USER
+func defaultMaxSessionsPerUser() int {
+	value := os.Getenv("MAX_SESSIONS_PER_USER")
+	if value == "" {
+		return 0
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 0 {
+		log.Printf("Warning: invalid MAX_SESSIONS_PER_USER '%s', sessions are not capped", value)
+		return 0
+	}
+	return max
+}
+
+// CreateSession starts a new session for the user and returns its access and refresh tokens
+// When sessions are capped, the least recently used ones are ended to stay within the cap
+func CreateSession(c *gin.Context, user *models.User) (*SessionTokens, error) {
+	if !user.IsActive() {
+		return nil, ErrAccountDeactivated
+	}
+
+	tokenID, err := GenerateOpaqueToken("")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	userAgent := c.Request.UserAgent()
+	session := models.Session{
+		UserID:     user.ID,
+		TokenID:    tokenID,
+		Device:     describeDevice(userAgent),
+		IPAddress:  c.ClientIP(),
+		UserAgent:  userAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+
+	tx := database.DB.Begin()
+
+	// Clean up expired sessions and refresh tokens of ended sessions while we're here
+	if err := tx.Where("user_id = ? AND expires_at <= ?", user.ID, now).Delete(&models.Session{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Where("session_id NOT IN (?)", tx.Model(&models.Session{}).Select("id")).Delete(&models.RefreshToken{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Create(&session).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if max := MaxSessionsPerUser(); max > 0 {
+		var evicted []models.Session
+		if err := tx.Where("user_id = ?", user.ID).Order("last_seen_at desc, id desc").Offset(max).Find(&evicted).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		for _, s := range evicted {
+			if err := deleteSession(tx, s.ID); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	tokens, err := issueSessionTokens(tx, &session, user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RefreshSession rotates a refresh token and issues a new access token for its session
+// A refresh token that was already used revokes the whole session, since it may have been stolen
+func RefreshSession(refreshToken string) (*SessionTokens, error) {
+	if !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var stored models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", HashOpaqueToken(refreshToken)).First(&stored).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if stored.UsedAt != nil {
+		if now.Sub(*stored.UsedAt) <= refreshReuseGracePeriod {
+			return nil, ErrRefreshInProgress
+		}
+		log.Printf("Refresh token reuse detected for session %d, revoking session", stored.SessionID)
+		deleteSession(database.DB, stored.SessionID)
+		return nil, ErrRefreshTokenReused
+	}
+	if !stored.ExpiresAt.After(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var session models.Session
+	if err := database.DB.First(&session, stored.SessionID).Error; err != nil || !session.ExpiresAt.After(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, session.UserID).Error; err != nil || !user.IsActive() {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tx := database.DB.Begin()
+
+	// Mark the token as used; the condition guards against concurrent refreshes
+	result := tx.Model(&stored).Where("used_at IS NULL").Update("used_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if err := tx.Model(&session).Updates(map[string]interface{}{
+		"last_seen_at": now,
+		"expires_at":   now.Add(refreshTokenTTL()),
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tokens, err := issueSessionTokens(tx, &session, &user)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// EndSession revokes the session an access token belongs to
+// Expired access tokens are accepted so that logging out always works; invalid tokens are ignored
+// Returns the ended session, or nil if there was none
+func EndSession(tokenString string) *models.Session {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, signingKeys.endSessionKey, jwt.WithoutClaimsValidation())
+	if err != nil || claims.ID == "" {
+		return nil
+	}
+
+	var session models.Session
+	if err := database.DB.Where("token_id = ? AND user_id = ?", claims.ID, claims.UserID).First(&session).Error; err != nil {
+		return nil
+	}
+	deleteSession(database.DB, session.ID)
+	return &session
+}
+
+// EndRefreshSession revokes the session a refresh token belongs to, for clients without cookies
+// Returns the ended session, or nil if there was none
+func EndRefreshSession(refreshToken string) *models.Session {
+	if !strings.HasPrefix(refreshToken, refreshTokenPrefix) {
+		return nil
+	}
+
+	var stored models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", HashOpaqueToken(refreshToken)).First(&stored).Error; err != nil {
+		return nil
+	}
+
+	var session models.Session
+	if err := database.DB.First(&session, stored.SessionID).Error; err != nil {
+		return nil
+	}
+	deleteSession(database.DB, session.ID)
+	return &session
+}
+
+// RevokeSession ends a session and invalidates its refresh tokens
+func RevokeSession(sessionID uint) error {
+	return deleteSession(database.DB, sessionID)
+}
+
+// RevokeUserSessions ends all sessions of a user except keepSessionID (0 to end all of them)
+// Returns the number of sessions ended
+func RevokeUserSessions(userID uint, keepSessionID uint) (int64, error) {
+	var sessions []models.Session
+	if err := database.DB.Where("user_id = ? AND id <> ?", userID, keepSessionID).Find(&sessions).Error; err != nil {
+		return 0, err
+	}
+
+	tx := database.DB.Begin()
+	for _, s := range sessions {
+		if err := deleteSession(tx, s.ID); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	return int64(len(sessions)), nil
+}
+
+// GetSessionID extracts the current session ID from context
+// Returns 0 for requests authenticated with a personal access token
+func GetSessionID(c *gin.Context) uint {
+	sessionID, exists := c.Get("session_id")
+	if !exists {
+		return 0
+	}
+	return sessionID.(uint)
+}
+
+// issueSessionTokens signs a new access token and stores a new refresh token for a session
+func issueSessionTokens(tx *gorm.DB, session *models.Session, user *models.User) (*SessionTokens, error) {
+	accessToken, err := GenerateToken(user.ID, user.Email, session.TokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := GenerateOpaqueToken(refreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: HashOpaqueToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL().Seconds()),
+		SessionID:    session.ID,
+	}, nil
+}
+
+// deleteSession deletes a session together with its refresh tokens
+func deleteSession(tx *gorm.DB, sessionID uint) error {
+	if err := tx.Where("session_id = ?", sessionID).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Session{}, sessionID).Error
+}
+
+// touchSession records activity on a session, at most once per lastUsedUpdateInterval
+func touchSession(session *models.Session) {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > lastUsedUpdateInterval {
+		database.DB.Model(session).Update("last_seen_at", now)
+	}
+}
+
+// maxClientNameLength caps the client name a request may give itself
+const maxClientNameLength = 100
+
+// ClientName identifies the app or device a request comes from
+// Clients can name themselves with the X-ChartDB-Client header; otherwise the
+// personal access token or the user agent is used
+func ClientName(c *gin.Context) string {
+	if name := strings.TrimSpace(c.GetHeader("X-ChartDB-Client")); name != "" {
+		if runes := []rune(name); len(runes) > maxClientNameLength {
+			name = string(runes[:maxClientNameLength])
+		}
+		return name
+	}
+	if tokenName, ok := c.Get("token_name"); ok {
+		return "Token: " + tokenName.(string)
+	}
+	return describeDevice(c.Request.UserAgent())
+}
+
+// describeDevice turns a user agent into a short label such as "Firefox on Linux"
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"), strings.Contains(userAgent, "Macintosh"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
@@ -0,0 +1,469 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWTSecret  = "chartdb-sync-secret-key-change-in-production"
+	defaultKeysDir    = "jwt-keys"
+	keyFileExtension  = ".pem"
+	retiredExtension  = ".retired"
+	rsaKeyBits        = 3072
+	rotationCheckTick = time.Hour
+
+	// keyRetirementLeeway keeps a replaced key a little longer than strictly needed, for clock skew
+	keyRetirementLeeway = time.Minute
+
+	// maxNextKeyLead is how long a new key is published before it starts signing, at most
+	// Verifiers that cache the JWKS pick it up before the first token signed with it arrives
+	maxNextKeyLead = 24 * time.Hour
+)
+
+// placeholderSecrets are secrets shipped in examples that must never be used in production
+var placeholderSecrets = []string{
+	defaultJWTSecret,
+	"change-this-in-production",
+	"your-super-secret-jwt-key-change-this",
+}
+
+// signingKey is a private key used to sign JWTs, identified by the kid header
+type signingKey struct {
+	ID        string
+	Key       crypto.Signer
+	CreatedAt time.Time
+	RetiredAt time.Time // Zero while the key is published
+}
+
+// keySet holds the keys used to sign and verify JWTs
+// With HS256 a single shared secret is used. With RS256 and EdDSA a new key is published
+// ahead of rotation and signs once its lead time has passed; older keys keep validating until
+// every token they signed has expired, and retired keys are still recognized when a session ends.
+type keySet struct {
+	mu               sync.RWMutex
+	method           jwt.SigningMethod
+	secret           []byte
+	dir              string
+	rotationInterval time.Duration
+	keys             []*signingKey // Published keys, sorted oldest first
+	retired          []*signingKey // No longer published, kept for one refresh token lifetime
+}
+
+var signingKeys = &keySet{method: jwt.SigningMethodHS256, secret: []byte(defaultJWTSecret)}
+
+// InitSigningKeys loads the JWT signing configuration
+// JWT_SIGNING_ALG selects HS256 (default, JWT_SECRET), RS256 or EdDSA (keys in JWT_KEYS_DIR).
+// In release mode HS256 refuses to start with a missing or placeholder secret.
+func InitSigningKeys() error {
+	alg := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALG"))
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		return initSharedSecret()
+	case jwt.SigningMethodRS256.Alg():
+		return initKeyDirectory(jwt.SigningMethodRS256)
+	case jwt.SigningMethodEdDSA.Alg():
+		return initKeyDirectory(jwt.SigningMethodEdDSA)
+	default:
+		return fmt.Errorf("unsupported JWT_SIGNING_ALG '%s' (use HS256, RS256 or EdDSA)", alg)
+	}
+}
+
+func initSharedSecret() error {
+	secret := os.Getenv("JWT_SECRET")
+	if isPlaceholderSecret(secret) {
+		if os.Getenv("GIN_MODE") == "release" {
+			return errors.New("JWT_SECRET is missing or uses a placeholder value; set a strong secret or use JWT_SIGNING_ALG=RS256/EdDSA")
+		}
+		log.Println("Warning: JWT_SECRET is not set, using the insecure development default")
+		if secret == "" {
+			secret = defaultJWTSecret
+		}
+	}
+
+	signingKeys.mu.Lock()
+	defer signingKeys.mu.Unlock()
+	signingKeys.method = jwt.SigningMethodHS256
+	signingKeys.secret = []byte(secret)
+	signingKeys.keys = nil
+	signingKeys.retired = nil
+	return nil
+}
+
+func initKeyDirectory(method jwt.SigningMethod) error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		dir = defaultKeysDir
+	}
+
+	interval := durationFromEnv("JWT_KEY_ROTATION_INTERVAL", 0)
+
+	signingKeys.mu.Lock()
+	signingKeys.method = method
+	signingKeys.secret = nil
+	signingKeys.dir = dir
+	signingKeys.rotationInterval = interval
+	signingKeys.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create JWT key directory: %w", err)
+	}
+
+	if err := signingKeys.load(time.Now()); err != nil {
+		return err
+	}
+
+	// Rotate on startup as well, which also creates the first key
+	if err := signingKeys.rotateIfDue(time.Now()); err != nil {
+		return err
+	}
+
+	if interval > 0 {
+		go signingKeys.rotatePeriodically()
+	}
+
+	log.Printf("JWT signing with %s, %d key(s) loaded from %s", method.Alg(), len(signingKeys.keys), dir)
+	return nil
+}
+
+// load reads all private keys of the configured algorithm from the key directory
+// Retired keys are read too while they may still have signed the token of a session; the
+// modification time of a retired key file is when it was retired
+func (ks *keySet) load(now time.Time) error {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read JWT key directory: %w", err)
+	}
+
+	var keys, retired []*signingKey
+	for _, entry := range entries {
+		name := entry.Name()
+		isRetired := strings.HasSuffix(name, keyFileExtension+retiredExtension)
+		if entry.IsDir() || (!isRetired && filepath.Ext(name) != keyFileExtension) {
+			continue
+		}
+
+		path := filepath.Join(ks.dir, entry.Name())
+		key, err := readPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load JWT key %s: %w", path, err)
+		}
+		if !keyMatchesMethod(key, ks.method) {
+			log.Printf("Warning: skipping JWT key %s, it does not match %s", path, ks.method.Alg())
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		id := strings.TrimSuffix(strings.TrimSuffix(name, retiredExtension), keyFileExtension)
+		if isRetired {
+			if now.Sub(info.ModTime()) < refreshTokenTTL() {
+				retired = append(retired, &signingKey{ID: id, Key: key, RetiredAt: info.ModTime()})
+			}
+			continue
+		}
+		keys = append(keys, &signingKey{ID: id, Key: key, CreatedAt: info.ModTime()})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.retired = retired
+	ks.mu.Unlock()
+	return nil
+}
+
+// nextKeyLead returns how long a new key is published before it starts signing
+func (ks *keySet) nextKeyLead() time.Duration {
+	lead := ks.rotationInterval / 2
+	if lead > maxNextKeyLead {
+		lead = maxNextKeyLead
+	}
+	return lead
+}
+
+// current returns the key that signs new tokens: the newest key whose lead time has passed,
+// or the oldest key when none has (the first key of an empty directory signs at once)
+// Must be called with the lock held
+func (ks *keySet) current(now time.Time) *signingKey {
+	if len(ks.keys) == 0 {
+		return nil
+	}
+	lead := ks.nextKeyLead()
+	for i := len(ks.keys) - 1; i > 0; i-- {
+		if !now.Before(ks.keys[i].CreatedAt.Add(lead)) {
+			return ks.keys[i]
+		}
+	}
+	return ks.keys[0]
+}
+
+// rotateIfDue creates a new key when there is none or the newest one will be older than the
+// rotation interval once a new key has been published for its lead time, and retires keys
+// that can no longer have signed a valid token
+func (ks *keySet) rotateIfDue(now time.Time) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	lead := ks.nextKeyLead()
+	if len(ks.keys) == 0 || (ks.rotationInterval > 0 && now.Sub(ks.keys[len(ks.keys)-1].CreatedAt) >= ks.rotationInterval-lead) {
+		key, err := ks.generate()
+		if err != nil {
+			return err
+		}
+		ks.keys = append(ks.keys, key)
+		log.Printf("Generated new JWT signing key %s", key.ID)
+	}
+
+	// A key stops signing when its successor starts; the tokens it signed
+	// expire at most one access token lifetime later
+	var active []*signingKey
+	for i, key := range ks.keys {
+		if i < len(ks.keys)-1 && now.After(ks.keys[i+1].CreatedAt.Add(lead+accessTokenTTL()+keyRetirementLeeway)) {
+			path := filepath.Join(ks.dir, key.ID+keyFileExtension)
+			if err := os.Rename(path, path+retiredExtension); err != nil {
+				log.Printf("Warning: failed to retire JWT key %s: %v", key.ID, err)
+			} else if err := os.Chtimes(path+retiredExtension, now, now); err != nil {
+				log.Printf("Warning: failed to record the retirement of JWT key %s: %v", key.ID, err)
+			}
+			key.RetiredAt = now
+			ks.retired = append(ks.retired, key)
+			log.Printf("Retired JWT signing key %s", key.ID)
+			continue
+		}
+		active = append(active, key)
+	}
+	ks.keys = active
+
+	// Tokens of a retired key can only belong to a session that hasn't been refreshed since,
+	// and such a session expires one refresh token lifetime after the key was retired
+	var retired []*signingKey
+	for _, key := range ks.retired {
+		if now.Sub(key.RetiredAt) < refreshTokenTTL() {
+			retired = append(retired, key)
+		}
+	}
+	ks.retired = retired
+
+	return nil
+}
+
+func (ks *keySet) rotatePeriodically() {
+	ticker := time.NewTicker(rotationCheckTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := ks.rotateIfDue(now); err != nil {
+			log.Printf("Warning: JWT key rotation failed: %v", err)
+		}
+	}
+}
+
+// generate creates a new private key and writes it to the key directory
+// Must be called with the lock held
+func (ks *keySet) generate() (*signingKey, error) {
+	var key crypto.Signer
+	var err error
+	if ks.method == jwt.SigningMethodEdDSA {
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate JWT key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	kid := now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	path := filepath.Join(ks.dir, kid+keyFileExtension)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write JWT key: %w", err)
+	}
+
+	return &signingKey{ID: kid, Key: key, CreatedAt: now}, nil
+}
+
+// signToken signs claims with the current key and sets the kid header
+func (ks *keySet) signToken(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	token := jwt.NewWithClaims(ks.method, claims)
+	if ks.secret != nil {
+		return token.SignedString(ks.secret)
+	}
+
+	current := ks.current(time.Now())
+	if current == nil {
+		return "", errors.New("no JWT signing key available")
+	}
+	token.Header["kid"] = current.ID
+	return token.SignedString(current.Key)
+}
+
+// verificationKey is the jwt.Keyfunc used to validate tokens
+// Only published keys are used, so tokens of a retired key stop working once it is rotated out
+func (ks *keySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	return ks.lookupKey(token, false)
+}
+
+// endSessionKey is the jwt.Keyfunc used to identify the session of a token being logged out
+// Unlike verificationKey it also knows retired keys, whose tokens can still name a session
+func (ks *keySet) endSessionKey(token *jwt.Token) (interface{}, error) {
+	return ks.lookupKey(token, true)
+}
+
+// lookupKey returns the public key a token was signed with
+// The algorithm must match the configured one so a public key can never be used as an HMAC secret
+func (ks *keySet) lookupKey(token *jwt.Token, withRetired bool) (interface{}, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if token.Method.Alg() != ks.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	if ks.secret != nil {
+		return ks.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	sets := [][]*signingKey{ks.keys}
+	if withRetired {
+		sets = append(sets, ks.retired)
+	}
+	for _, keys := range sets {
+		for _, key := range keys {
+			if key.ID == kid {
+				return key.Key.Public(), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// PublicJWKS returns the public keys that currently validate tokens, and the next signing key
+// once it has been generated
+// Empty with HS256, since a shared secret cannot be published
+func PublicJWKS() []JWK {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	keys := make([]JWK, 0, len(signingKeys.keys))
+	for _, key := range signingKeys.keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: signingKeys.method.Alg()}
+		switch public := key.Key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported private key type")
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+func keyMatchesMethod(key crypto.Signer, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		return method == jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		return method == jwt.SigningMethodEdDSA
+	default:
+		return false
+	}
+}
+
+func isPlaceholderSecret(secret string) bool {
+	if secret == "" {
+		return true
+	}
+	for _, placeholder := range placeholderSecrets {
+		if secret == placeholder {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	ks := &keySet{method: jwt.SigningMethodEdDSA, dir: dir, rotationInterval: 48 * time.Hour}
+
+	previous := signingKeys
+	signingKeys = ks
+	defer func() { signingKeys = previous }()
+
+	sign := func() (string, string) {
+		t.Helper()
+		signed, err := ks.signToken(jwt.RegisteredClaims{ID: "rotation"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed, token.Header["kid"].(string)
+	}
+	published := func() []string {
+		var kids []string
+		for _, jwk := range PublicJWKS() {
+			kids = append(kids, jwk.KeyID)
+		}
+		return kids
+	}
+	endsSession := func(signed string) bool {
+		_, err := jwt.ParseWithClaims(signed, &jwt.RegisteredClaims{}, ks.endSessionKey, jwt.WithoutClaimsValidation())
+		return err == nil
+	}
+
+	now := time.Now()
+	if err := ks.rotateIfDue(now); err != nil {
+		t.Fatal(err)
+	}
+	old := ks.keys[0]
+	if _, kid := sign(); kid != old.ID {
+		t.Fatalf("signed with %s, want the first key %s", kid, old.ID)
+	}
+	oldToken, err := GenerateToken(1, "rotation@example.com", "rotation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(oldToken); err != nil {
+		t.Fatalf("token of the current key rejected: %v", err)
+	}
+
+	// The next key is published a day before the old one is due
+	old.CreatedAt = now.Add(-30 * time.Hour)
+	if err := ks.rotateIfDue(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.keys) != 2 {
+		t.Fatalf("%d keys after rotation, want 2", len(ks.keys))
+	}
+	next := ks.keys[1]
+	if kids := published(); len(kids) != 2 || kids[1] != next.ID {
+		t.Errorf("JWKS = %v, want the next key %s published", kids, next.ID)
+	}
+	if _, kid := sign(); kid != old.ID {
+		t.Errorf("signed with %s before the next key's lead time passed", kid)
+	}
+
+	next.CreatedAt = now.Add(-25 * time.Hour)
+	if _, kid := sign(); kid != next.ID {
+		t.Errorf("signed with %s, want the next key %s once its lead time passed", kid, next.ID)
+	}
+
+	// Once its tokens have expired the old key is retired: it no longer authenticates requests,
+	// but still ends the sessions it signed
+	retiredAt := now.Add(accessTokenTTL() + 2*keyRetirementLeeway)
+	if err := ks.rotateIfDue(retiredAt); err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range published() {
+		if kid == old.ID {
+			t.Errorf("retired key %s is still published", old.ID)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, old.ID+keyFileExtension+retiredExtension)); err != nil {
+		t.Errorf("retired key file: %v", err)
+	}
+	if _, err := ValidateToken(oldToken); err == nil {
+		t.Error("token of a retired key is still accepted")
+	}
+	if !endsSession(oldToken) {
+		t.Error("token of a retired key can no longer end its session")
+	}
+
+	reloaded := &keySet{method: jwt.SigningMethodEdDSA, dir: dir}
+	if err := reloaded.load(retiredAt); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.keys) != len(ks.keys) || len(reloaded.retired) != 1 || reloaded.retired[0].ID != old.ID {
+		t.Errorf("reloaded %d keys and %d retired keys, want %d and 1", len(reloaded.keys), len(reloaded.retired), len(ks.keys))
+	}
+
+	// Retired keys are forgotten when no session they signed can be left
+	if err := ks.rotateIfDue(retiredAt.Add(refreshTokenTTL())); err != nil {
+		t.Fatal(err)
+	}
+	if endsSession(oldToken) {
+		t.Error("retired key still known after a refresh token lifetime")
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// AccountDeletion schedules the erasure of an account its owner deleted
+// Until PurgeAt the account and its diagrams are only soft-deleted and can be restored with the emailed link
+type AccountDeletion struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"uniqueIndex;not null" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token in the restore link
+	PurgeAt   time.Time `gorm:"index" json:"purge_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeleteAccountRequest confirms an account deletion
+// Confirm must repeat the account's email; local accounts also need their password, and accounts with 2FA a code
+type DeleteAccountRequest struct {
+	Confirm  string `json:"confirm" binding:"required"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// RestoreAccountRequest undoes an account deletion during the grace period
+type RestoreAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AccountExport is the account.json file of a data export
+type AccountExport struct {
+	ExportedAt           string                        `json:"exported_at"`
+	Profile              AccountExportProfile          `json:"profile"`
+	Identities           []UserIdentityResponse        `json:"identities"`
+	Passkeys             []PasskeyResponse             `json:"passkeys"`
+	Sessions             []SessionResponse             `json:"sessions"`
+	PersonalAccessTokens []PersonalAccessTokenResponse `json:"personal_access_tokens"`
+	Invitations          []InvitationResponse          `json:"invitations"`
+}
+
+// AccountExportProfile is everything stored on the user record, except secrets
+type AccountExportProfile struct {
+	ID               uint    `json:"id"`
+	Email            string  `json:"email"`
+	Name             string  `json:"name"`
+	AuthProvider     string  `json:"auth_provider"`
+	IsAdmin          bool    `json:"is_admin"`
+	EmailVerifiedAt  *string `json:"email_verified_at,omitempty"`
+	TwoFactorEnabled bool    `json:"two_factor_enabled"`
+	ExternalID       string  `json:"external_id,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+// DiagramExport is the diagram.json file of each diagram in a data export
+// The data of each version is in versions/<version>.json next to it
+type DiagramExport struct {
+	DiagramID       string                 `json:"diagram_id"`
+	Name            string                 `json:"name"`
+	DatabaseType    string                 `json:"database_type"`
+	DatabaseEdition string                 `json:"database_edition,omitempty"`
+	Version         int                    `json:"version"`
+	Deleted         bool                   `json:"deleted"`
+	Tags            []string               `json:"tags,omitempty"`
+	CreatedAt       string                 `json:"created_at"`
+	UpdatedAt       string                 `json:"updated_at"`
+	Versions        []DiagramVersionExport `json:"versions"`
+	ShareLinks      []ShareLinkResponse    `json:"share_links"`
+}
+
+// DiagramVersionExport describes a version of an exported diagram
+type DiagramVersionExport struct {
+	Version     int    `json:"version"`
+	Description string `json:"description,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Client      string `json:"client,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Audit event actions
+const (
+	AuditLoginSucceeded         = "login.success"
+	AuditLoginFailed            = "login.failure"
+	AuditAccountLocked          = "account.locked"
+	AuditAccountDeleted         = "account.deleted"
+	AuditAccountRestored        = "account.restored"
+	AuditPasswordChanged        = "password.changed"
+	AuditPasswordReset          = "password.reset"
+	AuditIdentityLinked         = "identity.linked"
+	AuditIdentityUnlinked       = "identity.unlinked"
+	AuditDiagramPushed          = "diagram.pushed"
+	AuditDiagramSynced          = "diagram.synced"
+	AuditDiagramDeleted         = "diagram.deleted"
+	AuditDiagramRestored        = "diagram.restored"
+	AuditDiagramPurged          = "diagram.purged"
+	AuditDiagramTransferred     = "diagram.transferred"
+	AuditSnapshotCreated        = "version.created"
+	AuditVersionDeleted         = "version.deleted"
+	AuditShareLinkCreated       = "share.created"
+	AuditShareLinkRevoked       = "share.revoked"
+	AuditUserUpdated            = "user.updated"
+	AuditUserRoleChanged        = "user.role_changed"
+	AuditUserDisabled           = "user.disabled"
+	AuditUserEnabled            = "user.enabled"
+	AuditUserDeleted            = "user.deleted"
+	AuditUserLoggedOut          = "user.logged_out"
+	AuditSettingsChanged        = "settings.changed"
+	AuditWorkspaceCreated       = "workspace.created"
+	AuditWorkspaceUpdated       = "workspace.updated"
+	AuditWorkspaceDeleted       = "workspace.deleted"
+	AuditWorkspaceMemberSet     = "workspace.member_set"
+	AuditWorkspaceMemberRemoved = "workspace.member_removed"
+)
+
+// AuditEvent records who did what and from where
+// Events are only ever added, and removed once they are older than AUDIT_RETENTION
+type AuditEvent struct {
+	ID        uint                   `gorm:"primaryKey" json:"id"`
+	Action    string                 `gorm:"index;not null" json:"action"`
+	UserID    *uint                  `gorm:"index" json:"user_id,omitempty"`    // Who did it, nil when nobody was signed in
+	Email     string                 `gorm:"index" json:"email,omitempty"`      // The user's email, or the one tried at a failed login
+	DiagramID *uint                  `gorm:"index" json:"diagram_id,omitempty"` // Diagram the event is about
+	Details   map[string]interface{} `gorm:"serializer:json;type:text" json:"details,omitempty"`
+	IPAddress string                 `json:"ip_address"`
+	UserAgent string                 `json:"user_agent"`
+	CreatedAt time.Time              `gorm:"index" json:"created_at"`
+}
+
+// AuditEventResponse represents an audit event in API responses
+// The diagram is identified by its ChartDB ID; the activity feed of a diagram leaves out IP address and user agent
+type AuditEventResponse struct {
+	ID          uint                   `json:"id"`
+	Action      string                 `json:"action"`
+	UserID      *uint                  `json:"user_id,omitempty"`
+	Email       string                 `json:"email,omitempty"`
+	DiagramID   string                 `json:"diagram_id,omitempty"`
+	DiagramName string                 `json:"diagram_name,omitempty"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+	IPAddress   string                 `json:"ip_address,omitempty"`
+	UserAgent   string                 `json:"user_agent,omitempty"`
+	CreatedAt   string                 `json:"created_at"`
+}
+
+// AuditEventListResponse is a page of audit events, newest first
+type AuditEventListResponse struct {
+	Events  []AuditEventResponse `json:"events"`
+	Total   int64                `json:"total"`
+	Page    int                  `json:"page"`
+	PerPage int                  `json:"per_page"`
+}
@@ -20,21 +20,30 @@ const (
 
 // Diagram represents a database diagram (metadata only)
 type Diagram struct {
-	ID              uint           `gorm:"primaryKey" json:"id"`
-	DiagramID       string         `gorm:"uniqueIndex;not null" json:"diagram_id"` // Original ChartDB ID
-	UserID          uint           `gorm:"index;not null" json:"user_id"`
-	Name            string         `gorm:"not null" json:"name"`
-	DatabaseType    string         `json:"database_type"`
-	DatabaseEdition string         `json:"database_edition,omitempty"`
-	Version         int            `gorm:"default:1" json:"version"`
-	CreatedAt       time.Time      `json:"created_at"`
-	UpdatedAt       time.Time      `json:"updated_at"`
-	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                uint           `gorm:"primaryKey" json:"id"`
+	DiagramID         string         `gorm:"uniqueIndex;not null" json:"diagram_id"` // Original ChartDB ID
+	UserID            uint           `gorm:"index;not null" json:"user_id"`
+	Name              string         `gorm:"not null" json:"name"`
+	DatabaseType      string         `json:"database_type"`
+	DatabaseEdition   string         `json:"database_edition,omitempty"`
+	Version           int            `gorm:"default:1" json:"version"`
+	TableCount        int            `gorm:"not null;default:0" json:"table_count"`        // Tables in the latest version, updated on every write
+	RelationshipCount int            `gorm:"not null;default:0" json:"relationship_count"` // Relationships in the latest version
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Only keep version history - all diagram data is stored as JSON in DiagramVersion
 	Versions []DiagramVersion `gorm:"foreignKey:DiagramID;references:ID" json:"versions,omitempty"`
 }
 
+// DiagramTag is a label the owner put on a diagram
+type DiagramTag struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	DiagramID uint   `gorm:"uniqueIndex:idx_diagram_tag;not null" json:"diagram_id"`
+	Tag       string `gorm:"uniqueIndex:idx_diagram_tag;index;not null" json:"tag"` // Lowercase
+}
+
 // DiagramVersion stores version history for diagrams as JSON
 type DiagramVersion struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
@@ -42,9 +51,22 @@ type DiagramVersion struct {
 	Version     int       `gorm:"not null" json:"version"`
 	Data        string    `gorm:"type:text" json:"data"` // Full JSON backup of diagram
 	Description string    `json:"description,omitempty"`
+	AuthorID    *uint     `gorm:"index" json:"author_id,omitempty"` // User who created the version; empty for versions from before authors were recorded
+	Client      string    `json:"client,omitempty"`                 // App or device the version came from, e.g. "Firefox on Linux"
+	Source      string    `json:"source,omitempty"`                 // How the version was created, one of the VersionSource values
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// Ways a diagram version can be created
+const (
+	VersionSourcePush     = "push"     // Pushed from the browser
+	VersionSourceAutosync = "autosync" // Saved by auto-sync
+	VersionSourceSnapshot = "snapshot" // Manual snapshot of the latest version
+	VersionSourceRestore  = "restore"  // An older version pushed again by the client
+	VersionSourceImport   = "import"   // Imported from a file or another tool
+	VersionSourceAPI      = "api"      // Pushed with a personal access token
+)
+
 // Note: All normalized entity models (DBTable, DBField, DBIndex, DBRelationship,
 // DBDependency, Area, Note, DBCustomType) have been removed.
 // Diagram data is now stored as JSON in DiagramVersion.Data field only.
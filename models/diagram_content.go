@@ -0,0 +1,69 @@
+package models
+
+import "encoding/json"
+
+// DiagramContent is a typed, read-only view of the ChartDB JSON stored in DiagramVersion.Data.
+// Only the parts the backend needs to inspect are decoded; unknown fields are ignored
+// so the stored JSON stays the source of truth.
+type DiagramContent struct {
+	ID            string                `json:"id"`
+	Name          string                `json:"name"`
+	DatabaseType  string                `json:"databaseType"`
+	Tables        []DiagramTable        `json:"tables"`
+	Relationships []DiagramRelationship `json:"relationships"`
+	Notes         []DiagramNote         `json:"notes"`
+}
+
+// DiagramTable is a table (or view) in a diagram
+type DiagramTable struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Schema   string         `json:"schema,omitempty"`
+	Comments string         `json:"comments,omitempty"`
+	IsView   bool           `json:"isView,omitempty"`
+	Fields   []DiagramField `json:"fields"`
+}
+
+// DiagramField is a column of a diagram table
+type DiagramField struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Type       DiagramFieldType `json:"type"`
+	PrimaryKey bool             `json:"primaryKey"`
+	Unique     bool             `json:"unique"`
+	Nullable   bool             `json:"nullable"`
+	Comments   string           `json:"comments,omitempty"`
+}
+
+// DiagramFieldType is the data type of a field
+type DiagramFieldType struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// DiagramRelationship is a foreign key relationship between two fields
+type DiagramRelationship struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	SourceTableID     string `json:"sourceTableId"`
+	TargetTableID     string `json:"targetTableId"`
+	SourceFieldID     string `json:"sourceFieldId"`
+	TargetFieldID     string `json:"targetFieldId"`
+	SourceCardinality string `json:"sourceCardinality,omitempty"`
+	TargetCardinality string `json:"targetCardinality,omitempty"`
+}
+
+// DiagramNote is a free-text note placed on a diagram
+type DiagramNote struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// ParseDiagramContent decodes the JSON stored in DiagramVersion.Data
+func ParseDiagramContent(data string) (*DiagramContent, error) {
+	var content DiagramContent
+	if err := json.Unmarshal([]byte(data), &content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// EmailVerificationToken is a single-use token emailed to confirm that a user owns their email address
+type EmailVerificationToken struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VerifyEmailRequest confirms an email address with a verification token
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to an account at an OIDC provider
+// Identities are keyed by issuer and subject, as subjects are only unique per issuer
+type UserIdentity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Provider    string     `json:"provider"` // Name of the configured provider last used to sign in
+	Issuer      string     `gorm:"uniqueIndex:idx_user_identities_issuer_subject;not null" json:"issuer"`
+	Subject     string     `gorm:"uniqueIndex:idx_user_identities_issuer_subject;not null" json:"subject"`
+	Email       string     `json:"email"` // Email claim at the last login
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// OIDCProviderResponse describes a sign-in option on the login page
+type OIDCProviderResponse struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	LoginURL string `json:"login_url"`
+}
+
+// IdentityLinkRequest holds an OIDC identity whose email matches an existing account while the
+// account owner confirms the link with their password
+type IdentityLinkRequest struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token in the link URL
+	Provider  string    `json:"provider"`
+	Issuer    string    `gorm:"not null" json:"issuer"`
+	Subject   string    `gorm:"not null" json:"subject"`
+	Email     string    `json:"email"`
+	Attempts  int       `gorm:"default:0" json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LinkIdentityRequest confirms an identity link with the account's password
+// Accounts with 2FA also need a TOTP or recovery code
+type LinkIdentityRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"`
+}
+
+// IdentityLinkResponse describes a pending identity link on the confirmation page
+type IdentityLinkResponse struct {
+	Email             string `json:"email"`    // Email of the existing account
+	Provider          string `json:"provider"` // Label of the provider
+	TwoFactorRequired bool   `json:"two_factor_required"`
+}
+
+// UserIdentityResponse represents a linked identity in API responses
+type UserIdentityResponse struct {
+	ID          uint    `json:"id"`
+	Provider    string  `json:"provider"`
+	Label       string  `json:"label"`
+	Issuer      string  `json:"issuer"`
+	Email       string  `json:"email"`
+	LastLoginAt *string `json:"last_login_at,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Invitation lets someone create an account for a specific email address through a single-use link
+// Invitations bypass the registration mode and allowed email domains
+type Invitation struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Email          string     `gorm:"index;not null" json:"email"`
+	InvitedByID    uint       `gorm:"index;not null" json:"invited_by_id"`
+	WorkspaceID    *uint      `gorm:"index" json:"workspace_id,omitempty"` // Workspace the new account joins
+	WorkspaceRole  string     `json:"workspace_role,omitempty"`            // Role in that workspace
+	TokenHash      string     `gorm:"uniqueIndex;not null" json:"-"`       // SHA-256 of the invitation token
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	AcceptedUserID *uint      `json:"accepted_user_id,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// IsPending reports whether the invitation can still be accepted
+func (i *Invitation) IsPending() bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && i.ExpiresAt.After(time.Now())
+}
+
+// MatchesEmail reports whether the invitation was issued for an email address
+func (i *Invitation) MatchesEmail(email string) bool {
+	return strings.EqualFold(i.Email, email)
+}
+
+// CreateInvitationRequest is the payload for inviting someone
+// The invitee can be added to a workspace, as a member unless another role is given
+type CreateInvitationRequest struct {
+	Email         string `json:"email" binding:"required,email"`
+	WorkspaceID   *uint  `json:"workspace_id"`
+	WorkspaceRole string `json:"workspace_role" binding:"omitempty,oneof=admin member"`
+}
+
+// InvitationResponse represents an invitation in API responses
+// URL is only populated when the invitation is created
+type InvitationResponse struct {
+	ID            uint    `json:"id"`
+	Email         string  `json:"email"`
+	URL           string  `json:"url,omitempty"`
+	InvitedByID   uint    `json:"invited_by_id"`
+	WorkspaceID   *uint   `json:"workspace_id,omitempty"`
+	WorkspaceRole string  `json:"workspace_role,omitempty"`
+	Status        string  `json:"status"` // pending, accepted, revoked or expired
+	ExpiresAt     string  `json:"expires_at"`
+	AcceptedAt    *string `json:"accepted_at,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential registered by a user for passwordless login
+type Passkey struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"index;not null" json:"user_id"`
+	Name         string     `json:"name"`
+	CredentialID string     `gorm:"uniqueIndex;not null" json:"-"` // Base64url credential ID
+	Credential   string     `gorm:"type:text;not null" json:"-"`   // JSON-encoded webauthn.Credential (public key, sign count, flags)
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony between its begin and finish steps
+type WebAuthnSession struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token in the ceremony cookie
+	UserID    uint      `gorm:"index" json:"user_id"`          // 0 for login ceremonies
+	Purpose   string    `gorm:"not null" json:"purpose"`       // "registration" or "login"
+	Name      string    `json:"name"`                          // Name of the passkey being registered
+	Data      string    `gorm:"type:text;not null" json:"-"`   // JSON-encoded webauthn.SessionData
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BeginPasskeyRegistrationRequest names the passkey about to be registered
+type BeginPasskeyRegistrationRequest struct {
+	Name string `json:"name"`
+}
+
+// PasskeyResponse represents a passkey in API responses
+type PasskeyResponse struct {
+	ID         uint    `json:"id"`
+	Name       string  `json:"name"`
+	LastUsedAt *string `json:"last_used_at,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+}
@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// SCIM schema URNs (RFC 7643, RFC 7644)
+const (
+	SCIMSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaSPConfig     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// SCIMGroup is a group pushed by the identity provider
+// Each group is backed by a workspace of the same name, whose SCIM members are the group's members.
+// Members of SCIM_ADMIN_GROUPS are also administrators
+type SCIMGroup struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	DisplayName string    `gorm:"uniqueIndex;not null" json:"display_name"`
+	ExternalID  string    `gorm:"index" json:"external_id"`
+	WorkspaceID uint      `gorm:"index" json:"workspace_id"` // 0 until the group's members are first synced
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (SCIMGroup) TableName() string {
+	return "scim_groups"
+}
+
+// SCIMGroupMember puts a user in a SCIM group
+type SCIMGroupMember struct {
+	GroupID uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID  uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+func (SCIMGroupMember) TableName() string {
+	return "scim_group_members"
+}
+
+// SCIMUser is the SCIM representation of a user
+// userName is the user's email address
+type SCIMUser struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *SCIMName       `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []SCIMEmail     `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Password    string          `json:"password,omitempty"` // Write-only
+	Groups      []SCIMMemberRef `json:"groups,omitempty"`   // Read-only
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMGroupResource is the SCIM representation of a group
+type SCIMGroupResource struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []SCIMMemberRef `json:"members,omitempty"`
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMMemberRef references a group member, or a group from a user
+type SCIMMemberRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location"`
+}
+
+// SCIMListResponse is a page of query results
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is the payload of a PATCH request
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations" binding:"required,min=1"`
+}
+
+// SCIMPatchOperation is one change of a PATCH request
+// Without a path, value is an object of attributes to change
+type SCIMPatchOperation struct {
+	Op    string      `json:"op" binding:"required"` // add, replace or remove, in any case
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// SearchResult is an entry of a diagram that matches a search
+type SearchResult struct {
+	DiagramID   string `json:"diagram_id"`
+	DiagramName string `json:"diagram_name"`
+	EntityType  string `json:"entity_type"` // diagram, table, view, field, comment or note
+	Path        string `json:"path"`        // e.g. "public.orders.customer_id" for a field
+	Text        string `json:"text"`        // The matching text, shortened around the match
+}
+
+// SearchResponse is a page of search results
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Total   int64          `json:"total"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Session is a signed-in browser or device
+// A user may have many sessions; each JWT references its session by TokenID
+type Session struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	UserID     uint      `gorm:"index;not null" json:"user_id"`
+	TokenID    string    `gorm:"uniqueIndex;not null" json:"-"` // JWT ID (jti) of the session token
+	Device     string    `json:"device"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `gorm:"index" json:"expires_at"`
+
+	// Set for OIDC logins, to end the provider session on logout
+	OIDCProvider string `gorm:"column:oidc_provider" json:"-"`
+	IDToken      string `gorm:"column:id_token;type:text" json:"-"`
+}
+
+// RefreshToken is a single-use token that renews the access token of a session
+// Each refresh rotates it; presenting a used token again revokes the whole session
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SessionID uint       `gorm:"index;not null" json:"session_id"`
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RefreshRequest is the payload of the refresh endpoint
+// Browsers send the refresh token as a cookie instead
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SessionResponse represents a session in API responses
+type SessionResponse struct {
+	ID         uint   `json:"id"`
+	Device     string `json:"device"`
+	IPAddress  string `json:"ip_address"`
+	UserAgent  string `json:"user_agent"`
+	Current    bool   `json:"current"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Setting keys
+const (
+	// SettingRequireTwoFactor forces local accounts to enroll in TOTP before they can use the app
+	SettingRequireTwoFactor = "require_two_factor"
+	// SettingMaxSessionsPerUser caps the concurrent sessions of a user, 0 for no cap
+	SettingMaxSessionsPerUser = "max_sessions_per_user"
+)
+
+// Setting is a server-wide option changed at runtime by administrators
+type Setting struct {
+	Key       string    `gorm:"primaryKey" json:"key"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UpdateSettingsRequest is the payload for changing server settings
+// Fields left out of the request are not changed
+type UpdateSettingsRequest struct {
+	RequireTwoFactor   *bool `json:"require_two_factor"`
+	MaxSessionsPerUser *int  `json:"max_sessions_per_user" binding:"omitempty,min=0"`
+}
+
+// SettingsResponse lists the server settings
+type SettingsResponse struct {
+	RequireTwoFactor   bool `json:"require_two_factor"`
+	MaxSessionsPerUser int  `json:"max_sessions_per_user"`
+}
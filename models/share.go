@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// ShareLink grants anonymous read-only access to a diagram through an unguessable token
+type ShareLink struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	DiagramID    uint       `gorm:"index;not null" json:"diagram_id"`
+	UserID       uint       `gorm:"index;not null" json:"user_id"` // Creator of the link
+	TokenHash    string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the share token
+	TokenPrefix  string     `json:"token_prefix"`                  // First characters, for display only
+	Label        string     `json:"label,omitempty"`
+	Version      *int       `json:"version,omitempty"` // Pinned version, nil follows the latest
+	PasswordHash string     `json:"-"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	ViewCount    int        `gorm:"default:0" json:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// IsActive reports whether the link can still be used
+func (s *ShareLink) IsActive() bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || s.ExpiresAt.After(time.Now())
+}
+
+// CreateShareLinkRequest is the payload for creating a share link
+type CreateShareLinkRequest struct {
+	Label     string     `json:"label"`
+	Version   *int       `json:"version"`
+	Password  string     `json:"password"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ShareLinkResponse represents a share link in API responses
+// Token and URL are only populated when the link is created
+type ShareLinkResponse struct {
+	ID           uint    `json:"id"`
+	Token        string  `json:"token,omitempty"`
+	URL          string  `json:"url,omitempty"`
+	TokenPrefix  string  `json:"token_prefix"`
+	Label        string  `json:"label,omitempty"`
+	Version      *int    `json:"version,omitempty"`
+	HasPassword  bool    `json:"has_password"`
+	ExpiresAt    *string `json:"expires_at,omitempty"`
+	RevokedAt    *string `json:"revoked_at,omitempty"`
+	Active       bool    `json:"active"`
+	ViewCount    int     `json:"view_count"`
+	LastViewedAt *string `json:"last_viewed_at,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+}
@@ -20,14 +20,21 @@ type DiagramJSONRequest struct {
 
 // DiagramListResponse represents a diagram in the list
 type DiagramListResponse struct {
-	ID           uint   `json:"id"`
-	DiagramID    string `json:"diagram_id"`
-	Name         string `json:"name"`
-	DatabaseType string `json:"database_type"`
-	Version      int    `json:"version"`
-	TableCount   int    `json:"table_count"`
-	CreatedAt    string `json:"created_at"`
-	UpdatedAt    string `json:"updated_at"`
+	ID                uint     `json:"id"`
+	DiagramID         string   `json:"diagram_id"`
+	Name              string   `json:"name"`
+	DatabaseType      string   `json:"database_type"`
+	Version           int      `json:"version"`
+	TableCount        int      `json:"table_count"`
+	RelationshipCount int      `json:"relationship_count"`
+	Tags              []string `json:"tags"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// SetDiagramTagsRequest replaces the tags of a diagram
+type SetDiagramTagsRequest struct {
+	Tags []string `json:"tags"`
 }
 
 // VersionListResponse represents a version in the list
@@ -35,9 +42,28 @@ type VersionListResponse struct {
 	ID          uint   `json:"id"`
 	Version     int    `json:"version"`
 	Description string `json:"description"`
+	Source      string `json:"source,omitempty"`
 	CreatedAt   string `json:"created_at"`
 }
 
+// TimelineEntryResponse represents a version in the timeline of a diagram
+type TimelineEntryResponse struct {
+	Version     int             `json:"version"`
+	Description string          `json:"description"`
+	Source      string          `json:"source,omitempty"`
+	Client      string          `json:"client,omitempty"`
+	Author      *TimelineAuthor `json:"author,omitempty"` // Empty for versions from before authors were recorded
+	CreatedAt   string          `json:"created_at"`
+}
+
+// TimelineAuthor identifies the user who created a version
+// Email and name are empty once the account has been erased
+type TimelineAuthor struct {
+	ID    uint   `json:"id"`
+	Email string `json:"email,omitempty"`
+	Name  string `json:"name,omitempty"`
+}
+
 // Note: All detailed entity input models (TableInput, FieldInput, etc.) have been removed.
 // The JSON format from ChartDB is stored directly as DiagramVersion.Data.
 // This eliminates the need for complex entity normalization and makes the system
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// PersonalAccessTokenPrefix marks a bearer token as a personal access token rather than a session JWT
+const PersonalAccessTokenPrefix = "cdb_pat_"
+
+// Token scopes limit what a personal access token may do
+const (
+	ScopeDiagramsRead  = "diagrams:read"
+	ScopeDiagramsWrite = "diagrams:write"
+	ScopeAdmin         = "admin"
+)
+
+// TokenScopes lists all scopes a personal access token can be granted
+var TokenScopes = []string{ScopeDiagramsRead, ScopeDiagramsWrite, ScopeAdmin}
+
+// PersonalAccessToken is a named, scoped token for scripts and CI
+// It authenticates independently of the browser session
+type PersonalAccessToken struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index;not null" json:"user_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	TokenHash   string     `gorm:"uniqueIndex;not null" json:"-"` // SHA-256 of the token
+	TokenPrefix string     `json:"token_prefix"`                  // First characters, for display only
+	Scopes      string     `json:"scopes"`                        // Comma-separated list of scopes
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ScopeList returns the scopes of the token
+func (t *PersonalAccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// IsExpired reports whether the token has passed its expiry date
+func (t *PersonalAccessToken) IsExpired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
+
+// CreatePersonalAccessTokenRequest is the payload for creating a personal access token
+type CreatePersonalAccessTokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// PersonalAccessTokenResponse represents a personal access token in API responses
+// Token is only populated when the token is created
+type PersonalAccessTokenResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Token       string   `json:"token,omitempty"`
+	TokenPrefix string   `json:"token_prefix"`
+	Scopes      []string `json:"scopes"`
+	ExpiresAt   *string  `json:"expires_at,omitempty"`
+	LastUsedAt  *string  `json:"last_used_at,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+}
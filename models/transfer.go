@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// DiagramTransfer records a change of diagram ownership
+type DiagramTransfer struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	DiagramID     uint      `gorm:"index;not null" json:"diagram_id"`
+	FromUserID    uint      `gorm:"index;not null" json:"from_user_id"`
+	ToUserID      uint      `gorm:"index;not null" json:"to_user_id"`
+	TransferredBy uint      `gorm:"not null" json:"transferred_by"` // User who performed the transfer
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TransferDiagramRequest identifies the new owner by ID or email
+type TransferDiagramRequest struct {
+	ToUserID uint   `json:"to_user_id"`
+	ToEmail  string `json:"to_email"`
+}
+
+// DiagramTransferResponse represents a transfer in the ownership history
+type DiagramTransferResponse struct {
+	ID            uint   `json:"id"`
+	FromUserID    uint   `json:"from_user_id"`
+	FromEmail     string `json:"from_email"`
+	ToUserID      uint   `json:"to_user_id"`
+	ToEmail       string `json:"to_email"`
+	TransferredBy uint   `json:"transferred_by"`
+	CreatedAt     string `json:"created_at"`
+}
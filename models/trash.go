@@ -0,0 +1,11 @@
+package models
+
+// TrashedDiagramResponse represents a diagram in the trash
+type TrashedDiagramResponse struct {
+	DiagramID    string `json:"diagram_id"`
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+	Version      int    `json:"version"`
+	DeletedAt    string `json:"deleted_at"`
+	PurgeAt      string `json:"purge_at,omitempty"` // Empty when trashed diagrams are kept until the trash is emptied
+}
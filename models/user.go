@@ -7,18 +7,45 @@ import (
 )
 
 type User struct {
-	ID           uint           `gorm:"primaryKey" json:"id"`
-	Email        string         `gorm:"uniqueIndex;not null" json:"email"`
-	Password     string         `json:"-"`
-	Name         string         `json:"name"`
-	OIDCSubject  string         `gorm:"uniqueIndex" json:"-"`     // OIDC sub claim
-	OIDCIssuer   string         `json:"-"`                        // OIDC issuer URL
-	AuthProvider string         `gorm:"default:'local'" json:"-"` // 'local' or 'oidc'
-	CurrentToken string         `json:"-"`                        // Current active session token
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
-	Diagrams     []Diagram      `gorm:"foreignKey:UserID" json:"diagrams,omitempty"`
+	ID              uint           `gorm:"primaryKey" json:"id"`
+	Email           string         `gorm:"uniqueIndex;not null" json:"email"`
+	Password        string         `json:"-"`
+	Name            string         `json:"name"`
+	AuthProvider    string         `gorm:"default:'local'" json:"-"` // 'local', 'oidc' or 'ldap'
+	IsAdmin         bool           `gorm:"default:false" json:"-"`   // Administrator role
+	AdminSource     string         `json:"-"`                        // Who granted the administrator role: AdminSourceManual, ldap, scim or oidc:<provider>
+	EmailVerifiedAt *time.Time     `json:"-"`                        // When the user confirmed their email address
+	TOTPSecret      string         `json:"-"`                        // Base32 TOTP secret, set during enrollment
+	TOTPEnabledAt   *time.Time     `json:"-"`                        // When 2FA was confirmed, nil if disabled
+	TOTPLastStep    int64          `json:"-"`                        // Time step of the last accepted code, against replay
+	DeactivatedAt   *time.Time     `json:"-"`                        // When the account was deactivated, nil if active
+	ExternalID      string         `gorm:"index" json:"-"`           // ID in the identity provider that provisioned the user (SCIM externalId)
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	Diagrams        []Diagram      `gorm:"foreignKey:UserID" json:"diagrams,omitempty"`
+}
+
+// AdminSourceManual marks an administrator role granted by an administrator, ADMIN_EMAIL or as the first account
+// Directory admin groups never revoke it
+const AdminSourceManual = "admin"
+
+// BeforeCreate makes the first account an administrator, whichever way it is created (signup, OIDC, LDAP or SCIM)
+// Accounts of an ADMIN_EMAIL address are promoted once their email is verified, see database.PromoteAdminEmail
+func (u *User) BeforeCreate(tx *gorm.DB) error {
+	if u.IsAdmin {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Session(&gorm.Session{NewDB: true}).Unscoped().Model(&User{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		u.IsAdmin = true
+		u.AdminSource = AdminSourceManual
+	}
+	return nil
 }
 
 type UserLoginRequest struct {
@@ -26,19 +53,88 @@ type UserLoginRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
-type UserSignupRequest struct {
-	Email    string `json:"email" binding:"required,email"`
+// LDAPLoginRequest is the login request while LDAP is enabled, where the directory login name also works
+type LDAPLoginRequest struct {
+	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required,min=6"`
-	Name     string `json:"name" binding:"required"`
+}
+
+type UserSignupRequest struct {
+	Email       string `json:"email" binding:"required,email"`
+	Password    string `json:"password" binding:"required,min=6"`
+	Name        string `json:"name" binding:"required"`
+	InviteToken string `json:"invite_token"` // Required when registration is invite-only
 }
 
 type UserResponse struct {
-	ID    uint   `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	ID               uint   `json:"id"`
+	Email            string `json:"email"`
+	Name             string `json:"name"`
+	EmailVerified    bool   `json:"email_verified"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+}
+
+// IsLocal reports whether the user signs in with a password rather than OIDC
+func (u *User) IsLocal() bool {
+	return u.AuthProvider == "" || u.AuthProvider == "local"
+}
+
+// TwoFactorEnabled reports whether the user completed TOTP enrollment
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// IsActive reports whether the user may sign in
+func (u *User) IsActive() bool {
+	return u.DeactivatedAt == nil
+}
+
+// IsEmailVerified reports whether the user confirmed their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
 }
 
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresIn    int          `json:"expires_in"` // Access token lifetime in seconds
+	User         UserResponse `json:"user"`
+}
+
+// AdminUserResponse represents a user in the admin console
+type AdminUserResponse struct {
+	ID               uint    `json:"id"`
+	Email            string  `json:"email"`
+	Name             string  `json:"name"`
+	AuthProvider     string  `json:"auth_provider"`
+	IsAdmin          bool    `json:"is_admin"`
+	Active           bool    `json:"active"`
+	EmailVerified    bool    `json:"email_verified"`
+	TwoFactorEnabled bool    `json:"two_factor_enabled"`
+	DiagramCount     int64   `json:"diagram_count"`
+	SessionCount     int64   `json:"session_count"`
+	DeactivatedAt    *string `json:"deactivated_at,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+// AdminUserListResponse is a page of users in the admin console
+type AdminUserListResponse struct {
+	Users   []AdminUserResponse `json:"users"`
+	Total   int64               `json:"total"`
+	Page    int                 `json:"page"`
+	PerPage int                 `json:"per_page"`
+}
+
+// AdminUpdateUserRequest changes a user's profile or role; omitted fields are left alone
+// A changed email counts as verified, the administrator vouches for it
+type AdminUpdateUserRequest struct {
+	Email   *string `json:"email" binding:"omitempty,email"`
+	Name    *string `json:"name"`
+	IsAdmin *bool   `json:"is_admin"`
+}
+
+// AdminResetPasswordRequest sets a new password for a user
+// Without a password, the user is emailed a reset link instead
+type AdminResetPasswordRequest struct {
+	NewPassword string `json:"new_password" binding:"omitempty,min=6"`
 }
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Workspace roles
+const (
+	WorkspaceRoleAdmin  = "admin"  // Manages the workspace's members and invites into it
+	WorkspaceRoleMember = "member" // Belongs to the workspace
+)
+
+// WorkspaceSourceManual marks a membership added by an administrator or through an invitation
+// Directories never remove it, like AdminSourceManual
+const WorkspaceSourceManual = "admin"
+
+// WorkspaceSourceSCIM marks a membership that comes from a SCIM group
+const WorkspaceSourceSCIM = "scim"
+
+// Workspace groups the users of a team
+type Workspace struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WorkspaceMember puts a user in a workspace with a role
+type WorkspaceMember struct {
+	WorkspaceID uint   `gorm:"primaryKey;autoIncrement:false"`
+	UserID      uint   `gorm:"primaryKey;autoIncrement:false;index"`
+	Role        string `gorm:"not null;default:'member'"`
+	Source      string `gorm:"not null;default:'admin'"` // Who added the member: WorkspaceSourceManual, scim or oidc:<provider>
+	CreatedAt   time.Time
+}
+
+// WorkspaceRequest is the payload for creating or renaming a workspace
+type WorkspaceRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// WorkspaceMemberRequest adds a user to a workspace or changes their role
+type WorkspaceMemberRequest struct {
+	Role string `json:"role" binding:"omitempty,oneof=admin member"` // Defaults to member
+}
+
+// WorkspaceResponse represents a workspace in API responses
+// Role is the current user's role, only set when listing their own workspaces
+type WorkspaceResponse struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Role        string `json:"role,omitempty"`
+	MemberCount int64  `json:"member_count"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// WorkspaceMemberResponse represents a member of a workspace in API responses
+type WorkspaceMemberResponse struct {
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Source    string `json:"source"`
+	CreatedAt string `json:"created_at"`
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/thorved/chartdb-backend/handlers"
 	"github.com/thorved/chartdb-backend/middleware"
+	"github.com/thorved/chartdb-backend/models"
 )
 
 func SetupRoutes(r *gin.Engine) {
@@ -16,25 +17,66 @@ func SetupRoutes(r *gin.Engine) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// Public keys for verifying our JWTs
+	r.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// Public assets for sync dashboard (JS, CSS files) - no auth required
 	r.Use(static.Serve("/sync/assets", static.LocalFile("./frontend/dist/assets", true)))
 
 	// Public assets for ChartDB (JS, CSS files) - no auth required
 	r.Use(static.Serve("/assets", static.LocalFile("./chartdb/dist/assets", true)))
 
+	// SCIM 2.0 provisioning for identity providers (bearer token in SCIM_TOKEN)
+	scim := r.Group("/scim/v2")
+	scim.Use(middleware.SCIMAuth())
+	{
+		scim.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig)
+		scim.GET("/Users", handlers.SCIMListUsers)
+		scim.POST("/Users", handlers.SCIMCreateUser)
+		scim.GET("/Users/:id", handlers.SCIMGetUser)
+		scim.PUT("/Users/:id", handlers.SCIMReplaceUser)
+		scim.PATCH("/Users/:id", handlers.SCIMPatchUser)
+		scim.DELETE("/Users/:id", handlers.SCIMDeleteUser)
+		scim.GET("/Groups", handlers.SCIMListGroups)
+		scim.POST("/Groups", handlers.SCIMCreateGroup)
+		scim.GET("/Groups/:id", handlers.SCIMGetGroup)
+		scim.PUT("/Groups/:id", handlers.SCIMReplaceGroup)
+		scim.PATCH("/Groups/:id", handlers.SCIMPatchGroup)
+		scim.DELETE("/Groups/:id", handlers.SCIMDeleteGroup)
+	}
+
 	// Sync routes group with auth enforcement
 	sync := r.Group("/sync")
 	sync.Use(middleware.EnforceAuthMiddleware())
 	{
 		// Auth routes (public - don't require auth)
-		sync.POST("/api/auth/signup", handlers.Signup)
-		sync.POST("/api/auth/login", handlers.Login)
+		sync.POST("/api/auth/signup", middleware.AuthRateLimit("signup", nil), handlers.Signup)
+		sync.POST("/api/auth/login", middleware.AuthRateLimit("login", middleware.LoginAccount), handlers.Login)
 		sync.POST("/api/auth/logout", handlers.Logout)
+		sync.POST("/api/auth/refresh", handlers.RefreshToken)
+		sync.POST("/api/auth/password/reset-request", middleware.AuthRateLimit("password_reset", nil), handlers.RequestPasswordReset)
+		sync.POST("/api/auth/password/reset", handlers.ResetPassword)
+		sync.GET("/api/auth/registration", handlers.GetRegistrationSettings)
+		sync.POST("/api/auth/verify-email", middleware.AuthRateLimit("verify_email", nil), handlers.VerifyEmail)
+		sync.GET("/api/auth/invitations/:token", handlers.GetInvitation)
+		sync.POST("/api/auth/2fa/verify", middleware.AuthRateLimit("two_factor", middleware.LoginChallengeAccount), handlers.VerifyTwoFactorLogin)
+		sync.POST("/api/auth/passkeys/login/begin", middleware.AuthRateLimit("passkey_login_begin", nil), handlers.BeginPasskeyLogin)
+		sync.POST("/api/auth/passkeys/login/finish", middleware.AuthRateLimit("passkey_login", nil), handlers.FinishPasskeyLogin)
+		sync.POST("/api/auth/restore-account", handlers.RestoreAccount)
 
 		// OIDC routes
 		sync.GET("/api/auth/oidc/enabled", handlers.GetOIDCEnabled)
 		sync.GET("/api/auth/oidc/login", handlers.OIDCLogin)
 		sync.GET("/api/auth/oidc/callback", handlers.OIDCCallback)
+		sync.GET("/api/auth/oidc/:provider/login", handlers.OIDCLogin)
+		sync.GET("/api/auth/oidc/:provider/callback", handlers.OIDCCallback)
+		sync.GET("/api/auth/identities/link/:token", handlers.GetIdentityLink)
+		sync.POST("/api/auth/identities/link", middleware.AuthRateLimit("link_identity", nil), handlers.LinkIdentity)
+
+		// Public share links (anonymous, read-only)
+		sync.GET("/api/share/:token", middleware.AuthRateLimit("share_password", nil), handlers.GetSharedDiagram)
+		sync.GET("/share/:token", handlers.ViewSharedDiagram)
+		sync.POST("/share/:token", middleware.AuthRateLimit("share_password", nil), handlers.ViewSharedDiagram)
 
 		// Protected routes (require auth)
 		protected := sync.Group("")
@@ -42,38 +84,151 @@ func SetupRoutes(r *gin.Engine) {
 		{
 			// User routes
 			protected.GET("/api/auth/me", handlers.GetCurrentUser)
-			protected.PUT("/api/auth/me", handlers.UpdateUser)
-			protected.PUT("/api/auth/password", handlers.ChangePassword)
-
-			// Diagram sync routes
-			protected.POST("/api/diagrams/push", handlers.PushDiagram)
-			protected.POST("/api/diagrams/sync", handlers.SyncDiagram)
-			protected.GET("/api/diagrams/pull-all", handlers.PullAllDiagrams)
-			protected.GET("/api/diagrams/pull/:diagramId", handlers.PullDiagram)
-			protected.POST("/api/diagrams/:diagramId/snapshot", handlers.CreateSnapshot)
-			protected.GET("/api/diagrams", handlers.ListDiagrams)
-			protected.GET("/api/diagrams/:diagramId", handlers.GetDiagram)
-			protected.DELETE("/api/diagrams/:diagramId", handlers.DeleteDiagram)
-			protected.GET("/api/diagrams/:diagramId/versions", handlers.GetVersions)
-			protected.DELETE("/api/diagrams/:diagramId/versions/:version", handlers.DeleteVersion)
+
+			// Two-factor authentication routes (reachable while 2FA setup is still required)
+			twoFactor := protected.Group("/api/auth/2fa")
+			twoFactor.Use(middleware.RequireSession())
+			{
+				twoFactor.GET("", handlers.GetTwoFactorStatus)
+				twoFactor.POST("/setup", handlers.SetupTwoFactor)
+				twoFactor.POST("/enable", handlers.EnableTwoFactor)
+				twoFactor.POST("/disable", handlers.DisableTwoFactor)
+				twoFactor.POST("/recovery-codes", handlers.RegenerateRecoveryCodes)
+			}
+
+			// Account routes (browser session only, not personal access tokens)
+			account := protected.Group("")
+			account.Use(middleware.RequireSession(), middleware.RequireTwoFactor())
+			{
+				account.PUT("/api/auth/me", handlers.UpdateUser)
+				account.PUT("/api/auth/password", middleware.AuthRateLimit("change_password", middleware.SessionAccount), handlers.ChangePassword)
+				account.POST("/api/auth/resend-verification", handlers.ResendVerificationEmail)
+				account.GET("/api/auth/export", handlers.ExportAccountData)
+				account.DELETE("/api/auth/me", middleware.AuthRateLimit("delete_account", middleware.SessionAccount), handlers.DeleteAccount)
+
+				// Personal access token routes
+				account.GET("/api/auth/tokens", handlers.ListPersonalAccessTokens)
+				account.POST("/api/auth/tokens", handlers.CreatePersonalAccessToken)
+				account.DELETE("/api/auth/tokens/:tokenId", handlers.RevokePersonalAccessToken)
+
+				// Passkey routes
+				account.GET("/api/auth/passkeys", handlers.ListPasskeys)
+				account.POST("/api/auth/passkeys/register/begin", handlers.BeginPasskeyRegistration)
+				account.POST("/api/auth/passkeys/register/finish", handlers.FinishPasskeyRegistration)
+				account.DELETE("/api/auth/passkeys/:passkeyId", handlers.DeletePasskey)
+
+				// Linked OIDC identity routes
+				account.GET("/api/auth/identities", handlers.ListIdentities)
+				account.DELETE("/api/auth/identities/:identityId", handlers.UnlinkIdentity)
+
+				// Invitation routes
+				account.GET("/api/invitations", handlers.ListInvitations)
+				account.POST("/api/invitations", handlers.CreateInvitation)
+				account.DELETE("/api/invitations/:invitationId", handlers.RevokeInvitation)
+
+				// Workspace routes
+				account.GET("/api/workspaces", handlers.ListMyWorkspaces)
+
+				// Session management routes
+				account.GET("/api/auth/sessions", handlers.ListSessions)
+				account.DELETE("/api/auth/sessions", handlers.RevokeOtherSessions)
+				account.DELETE("/api/auth/sessions/:sessionId", handlers.RevokeSession)
+			}
+
+			// Diagram read routes
+			read := protected.Group("")
+			read.Use(middleware.RequireScope(models.ScopeDiagramsRead), middleware.RequireTwoFactor())
+			{
+				read.GET("/api/diagrams/pull-all", handlers.PullAllDiagrams)
+				read.GET("/api/diagrams/pull/:diagramId", handlers.PullDiagram)
+				read.GET("/api/diagrams", handlers.ListDiagrams)
+				read.GET("/api/diagrams/:diagramId", handlers.GetDiagram)
+				read.GET("/api/diagrams/:diagramId/versions", handlers.GetVersions)
+				read.GET("/api/diagrams/:diagramId/timeline", handlers.GetDiagramTimeline)
+				read.GET("/api/diagrams/:diagramId/shares", handlers.ListShareLinks)
+				read.GET("/api/diagrams/:diagramId/transfers", handlers.GetDiagramTransfers)
+				read.GET("/api/diagrams/:diagramId/activity", handlers.GetDiagramActivity)
+				read.GET("/api/trash", handlers.ListTrash)
+				read.GET("/api/search", handlers.Search)
+			}
+
+			// Diagram write routes (unverified accounts can't push when email verification is required)
+			write := protected.Group("")
+			write.Use(middleware.RequireScope(models.ScopeDiagramsWrite), middleware.RequireTwoFactor(), middleware.RequireVerifiedEmail())
+			{
+				write.POST("/api/diagrams/push", handlers.PushDiagram)
+				write.POST("/api/diagrams/sync", handlers.SyncDiagram)
+				write.POST("/api/diagrams/:diagramId/snapshot", handlers.CreateSnapshot)
+				write.DELETE("/api/diagrams/:diagramId", handlers.DeleteDiagram)
+				write.DELETE("/api/diagrams/:diagramId/versions/:version", handlers.DeleteVersion)
+				write.PUT("/api/diagrams/:diagramId/tags", handlers.SetDiagramTags)
+
+				// Trash routes
+				write.POST("/api/trash/:diagramId/restore", handlers.RestoreDiagram)
+				write.DELETE("/api/trash/:diagramId", handlers.PurgeDiagram)
+				write.DELETE("/api/trash", handlers.EmptyTrash)
+
+				// Share link routes
+				write.POST("/api/diagrams/:diagramId/shares", handlers.CreateShareLink)
+				write.DELETE("/api/diagrams/:diagramId/shares/:shareId", handlers.RevokeShareLink)
+
+				// Ownership transfer routes
+				write.POST("/api/diagrams/:diagramId/transfer", handlers.TransferDiagram)
+			}
+
+			// Admin routes (require administrator role)
+			admin := protected.Group("/api/admin")
+			admin.Use(middleware.RequireScope(models.ScopeAdmin), middleware.AdminMiddleware(), middleware.RequireVerifiedEmail(), middleware.RequireTwoFactor())
+			{
+				admin.GET("/settings", handlers.GetSettings)
+				admin.PUT("/settings", handlers.UpdateSettings)
+				admin.GET("/users", handlers.ListUsers)
+				admin.GET("/users/:userId", handlers.GetUser)
+				admin.PUT("/users/:userId", handlers.AdminUpdateUser)
+				admin.DELETE("/users/:userId", handlers.DeleteUser)
+				admin.POST("/users/:userId/disable", handlers.DisableUser)
+				admin.POST("/users/:userId/enable", handlers.EnableUser)
+				admin.POST("/users/:userId/logout", handlers.LogoutUser)
+				admin.POST("/users/:userId/reset-password", handlers.ResetUserPassword)
+				admin.POST("/users/:userId/transfer-diagrams", handlers.TransferUserDiagrams)
+				admin.GET("/audit", handlers.ListAuditEvents)
+				admin.GET("/workspaces", handlers.ListWorkspaces)
+				admin.POST("/workspaces", handlers.CreateWorkspace)
+				admin.PUT("/workspaces/:workspaceId", handlers.UpdateWorkspace)
+				admin.DELETE("/workspaces/:workspaceId", handlers.DeleteWorkspace)
+				admin.GET("/workspaces/:workspaceId/members", handlers.ListWorkspaceMembers)
+				admin.PUT("/workspaces/:workspaceId/members/:userId", handlers.SetWorkspaceMember)
+				admin.DELETE("/workspaces/:workspaceId/members/:userId", handlers.RemoveWorkspaceMember)
+			}
 		}
 
 		// Serve Vue SPA for /sync/ routes (index.html)
-		// Login/signup are public (middleware handles this), others require auth
+		// Login/signup/password reset are public (middleware handles this), others require auth
 		sync.GET("", serveSyncSPA)
 		sync.GET("/", serveSyncSPA)
 		sync.GET("/login", serveSyncSPA)
 		sync.GET("/signup", serveSyncSPA)
+		sync.GET("/forgot-password", serveSyncSPA)
+		sync.GET("/reset-password", serveSyncSPA)
+		sync.GET("/verify-email", serveSyncSPA)
+		sync.GET("/two-factor", serveSyncSPA)
+		sync.GET("/passkeys", serveSyncSPA)
+		sync.GET("/link-account", serveSyncSPA)
+		sync.GET("/connected-accounts", serveSyncSPA)
+		sync.GET("/account", serveSyncSPA)
+		sync.GET("/restore-account", serveSyncSPA)
 		sync.GET("/sync", serveSyncSPA)
 		sync.GET("/dashboard", serveSyncSPA)
+		sync.GET("/trash", serveSyncSPA)
+		sync.GET("/search", serveSyncSPA)
 		sync.GET("/dashboard/*any", serveSyncSPA)
 	}
 
 	// Catch-all for ChartDB React SPA at root
 	r.NoRoute(func(c *gin.Context) {
 		path := c.Request.URL.Path
-		// Don't serve ChartDB SPA for /sync/ or /api/ routes
-		if strings.HasPrefix(path, "/sync") || strings.HasPrefix(path, "/api") {
+		// Don't serve ChartDB SPA for /sync/, /api/ or /scim/ routes
+		if strings.HasPrefix(path, "/sync") || strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/scim") {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
 			return
 		}
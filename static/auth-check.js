@@ -4,14 +4,29 @@
   'use strict';
   
   // Check authentication on page load via API
+  function fetchCurrentUser() {
+    return fetch('/sync/api/auth/me', {
+      credentials: 'include',
+      headers: {
+        'Accept': 'application/json'
+      }
+    });
+  }
+
   async function checkAuth() {
     try {
-      const response = await fetch('/sync/api/auth/me', {
-        credentials: 'include',
-        headers: {
-          'Accept': 'application/json'
+      let response = await fetchCurrentUser();
+
+      // Access tokens are short-lived, try to renew the session before giving up
+      if (response.status === 401) {
+        const refresh = await fetch('/sync/api/auth/refresh', {
+          method: 'POST',
+          credentials: 'include'
+        });
+        if (refresh.ok || refresh.status === 409) {
+          response = await fetchCurrentUser();
         }
-      });
+      }
       
       if (!response.ok) {
         // User is not authenticated, redirect to login
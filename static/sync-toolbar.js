@@ -43,7 +43,7 @@
             this.baseUrl = CONFIG.apiBaseUrl;
         }
 
-        async request(endpoint, options = {}) {
+        async request(endpoint, options = {}, retry = true) {
             const response = await fetch(`${this.baseUrl}${endpoint}`, {
                 ...options,
                 headers: {
@@ -54,6 +54,10 @@
             });
 
             if (response.status === 401) {
+                // Access tokens are short-lived, renew silently and try once more
+                if (retry && await this.refreshSession()) {
+                    return this.request(endpoint, options, false);
+                }
                 state.isAuthenticated = false;
                 throw new Error('Unauthorized');
             }
@@ -61,6 +65,23 @@
             return response;
         }
 
+        async refreshSession() {
+            // Share a single refresh between concurrent requests
+            if (!this.refreshPromise) {
+                this.refreshPromise = fetch(`${this.baseUrl}/auth/refresh`, {
+                    method: 'POST',
+                    credentials: 'include'
+                })
+                    // 409: another tab refreshed a moment ago and the cookies are already renewed
+                    .then(response => response.ok || response.status === 409)
+                    .catch(() => false)
+                    .finally(() => {
+                        this.refreshPromise = null;
+                    });
+            }
+            return this.refreshPromise;
+        }
+
         async syncDiagram(diagramJSON) {
             console.log('[Sync Toolbar] API: Sending sync request...');
             const response = await this.request('/diagrams/sync', {
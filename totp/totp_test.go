@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 4226 Appendix D
+func TestGenerateHOTPVectors(t *testing.T) {
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for counter, want := range []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	} {
+		if got := generate(key, int64(counter)); got != want {
+			t.Errorf("generate(counter %d) = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+// RFC 6238 Appendix B, SHA-1 rows; the vectors have 8 digits, a 6-digit code is their last 6
+func TestValidateTOTPVectors(t *testing.T) {
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	} {
+		now := time.Unix(tc.unix, 0)
+		code := tc.code[len(tc.code)-Digits:]
+
+		step, ok := Validate(rfcSecret, code, now)
+		if !ok || step != tc.unix/Period {
+			t.Errorf("Validate(%s at %d) = %d, %v; want %d, true", code, tc.unix, step, ok, tc.unix/Period)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code := "050471" // RFC 6238 vector for 1111111111
+
+	for _, tc := range []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"previous period", Period * time.Second, true},
+		{"next period", -Period * time.Second, true},
+		{"two periods later", 2 * Period * time.Second, false},
+		{"two periods earlier", -2 * Period * time.Second, false},
+	} {
+		if _, ok := Validate(rfcSecret, code, now.Add(tc.offset)); ok != tc.ok {
+			t.Errorf("%s: Validate = %v, want %v", tc.name, ok, tc.ok)
+		}
+	}
+
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted a malformed code", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 050 471 ", now); !ok {
+		t.Error("Validate rejected a code with spaces")
+	}
+	if _, ok := Validate("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code, now); !ok {
+		t.Error("Validate rejected a lowercase secret")
+	}
+}